@@ -0,0 +1,32 @@
+package lazy
+
+import "context"
+
+// loadArgsKey is context key of arguments passed to `LoadOneWithArgsFunc`
+type loadArgsKey struct{}
+
+// GetWithArgs returns value of the entry as `Get`, args are passed to
+// `LoadOneWithArgsFunc` when the read loads the entry (not cached or expired
+// entry). Args do not affect which entry is read: reads of valid entries ignore
+// them and concurrent reads of the same entry share one load (with args of the
+// read which started it). Automatic reloads and other reads load with nil args.
+func (c *Cache[K, T]) GetWithArgs(ID K, args any) *T {
+	return c.output(c.get(context.WithValue(c.ctx, loadArgsKey{}, args), ID, nil))
+}
+
+// loaderFor returns loader of the read with given context (with its arguments
+// when the read passed them)
+func (c *Cache[K, T]) loaderFor(ctx context.Context) LoadOneFunc[K, T] {
+	if c.loadOneWithArgs == nil {
+		return c.loadOneFunc
+	}
+
+	args := ctx.Value(loadArgsKey{})
+	if args == nil {
+		return c.loadOneFunc
+	}
+
+	return func(ID K) (*T, error) {
+		return c.loadOneWithArgs(ID, args)
+	}
+}
@@ -0,0 +1,73 @@
+package lazy
+
+import (
+	"sync"
+)
+
+// defaultMaxBackgroundLoads is used when `Params.MaxBackgroundLoads` is not set
+const defaultMaxBackgroundLoads = 64
+
+// backgroundLoads limits number of routines running background (re)loads, loads
+// over the limit are queued and run by routines which finished their load
+type backgroundLoads struct {
+	limit   int
+	mu      sync.Mutex
+	running int
+	queue   []func()
+}
+
+// runInBackground runs the load in a new routine or queues it when the limit of
+// background routines is reached
+func (c *Cache[K, T]) runInBackground(load func()) {
+	b := &c.background
+
+	b.mu.Lock()
+	if b.running >= b.limit {
+		b.queue = append(b.queue, load)
+		b.mu.Unlock()
+
+		if c.metrics != nil {
+			c.metrics.BackgroundLoadsQueued.Inc()
+		}
+
+		return
+	}
+	b.running++
+	b.mu.Unlock()
+
+	if c.metrics != nil {
+		c.metrics.BackgroundLoadsRunning.Inc()
+	}
+
+	go c.backgroundWorker(load)
+}
+
+// backgroundWorker runs the load and then queued loads until the queue is empty
+func (c *Cache[K, T]) backgroundWorker(load func()) {
+	b := &c.background
+
+	for {
+		load()
+
+		b.mu.Lock()
+		if len(b.queue) == 0 {
+			b.running--
+			b.mu.Unlock()
+
+			if c.metrics != nil {
+				c.metrics.BackgroundLoadsRunning.Dec()
+			}
+
+			return
+		}
+
+		load = b.queue[0]
+		b.queue[0] = nil
+		b.queue = b.queue[1:]
+		b.mu.Unlock()
+
+		if c.metrics != nil {
+			c.metrics.BackgroundLoadsQueued.Dec()
+		}
+	}
+}
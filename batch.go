@@ -0,0 +1,386 @@
+package lazy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// GetMultiple returns values of entries with given IDs (not found entries are
+// omitted). Entries which are not cached or are expired are loaded in one batch
+// by `LoadBatchFunc` (split into chunks by `MaxBatchSize`). When
+// `LoadBatchFunc` is not set, entries are loaded one by one by `LoadOneFunc`.
+// Values are returned under normalized keys (see `NormalizeKey`). Values older
+// than `MaxStaleness` are omitted and reads are counted the same way as by `Get`.
+func (c *Cache[K, T]) GetMultiple(IDs []K) map[K]*T {
+	result := make(map[K]*T, len(IDs))
+
+	if c.normalize != nil {
+		normalizedIDs := make([]K, len(IDs))
+		for i, ID := range IDs {
+			normalizedIDs[i] = c.normalize(ID)
+		}
+		IDs = normalizedIDs
+	}
+
+	if c.loadMultipleFunc == nil {
+		for _, ID := range IDs {
+			value := c.Get(ID)
+			if value != nil {
+				result[ID] = value
+			}
+		}
+
+		return result
+	}
+
+	c.stats.reads.Add(uint64(len(IDs)))
+	if c.metrics != nil {
+		c.metrics.ReadsCount.Add(float64(len(IDs)))
+	}
+
+	if c.validKey != nil {
+		validIDs := make([]K, 0, len(IDs))
+		for _, ID := range IDs {
+			if c.isValidKey(ID) {
+				validIDs = append(validIDs, ID)
+			}
+		}
+		c.stats.misses.Add(uint64(len(IDs) - len(validIDs)))
+		IDs = validIDs
+	}
+
+	nowMillis := c.nowMillis()
+
+	// read is counted and its value returned the same way as by `Get` (value which
+	// is too stale is not returned)
+	read := func(ID K, entry *cachedEntry[T], value *T, fromCache bool) {
+		if fromCache {
+			c.stats.hits.Add(1)
+		} else {
+			c.stats.misses.Add(1)
+		}
+		if c.topKeys != nil {
+			c.topKeys.read(ID, fromCache)
+		}
+
+		if entry != nil {
+			value = c.notTooStale(entry, value, nowMillis)
+		}
+		if value != nil {
+			result[ID] = value
+		}
+	}
+
+	// entries claimed for loading by this call are locked (new entries are created
+	// as placeholders), entries being loaded by other routines are waited for, so
+	// overlapping calls load each entry only once
+	type claimedEntry struct {
+		entry   *cachedEntry[T]
+		created bool
+	}
+	claimed := make(map[K]claimedEntry, len(IDs))
+	toLoad := make([]K, 0, len(IDs))
+	waiting := make(map[K]*cachedEntry[T])
+	// referenced entries which have to be reloaded
+	stale := make(map[K]*cachedEntry[T])
+	var missing []K
+	created := false
+
+	// fresh entries are read under read lock, the cache is locked for writing only
+	// to create placeholders of missing entries
+	c.mu.RLock()
+	for _, ID := range IDs {
+		if _, duplicate := stale[ID]; duplicate {
+			continue
+		}
+
+		entry, exists := c.data[ID]
+		if !exists {
+			missing = append(missing, ID)
+			continue
+		}
+
+		c.markAccess(entry, nowMillis)
+		if nowMillis < entry.nextReload.Load() {
+			read(ID, entry, entry.get(), true)
+			continue
+		}
+
+		entry.refs.Add(1)
+		stale[ID] = entry
+	}
+	c.mu.RUnlock()
+
+	if len(missing) > 0 {
+		c.mu.Lock()
+		for _, ID := range missing {
+			if _, duplicate := claimed[ID]; duplicate {
+				continue
+			}
+
+			// entry was created by other routine meanwhile
+			if entry, exists := c.data[ID]; exists {
+				if _, duplicate := stale[ID]; !duplicate {
+					entry.refs.Add(1)
+					stale[ID] = entry
+				}
+				continue
+			}
+
+			if c.overMemoryCeiling() || !c.loadAllowed(ID, nowMillis) {
+				read(ID, nil, nil, false)
+				continue
+			}
+
+			entry := c.newEntry()
+			entry.mu.Lock()
+			c.markAccess(entry, nowMillis)
+			c.storeEntry(ID, entry)
+			claimed[ID] = claimedEntry{entry: entry, created: true}
+			toLoad = append(toLoad, ID)
+			created = true
+		}
+		c.mu.Unlock()
+	}
+
+	for ID, entry := range stale {
+		// entry is being loaded by other routine
+		if !entry.mu.TryLock() {
+			waiting[ID] = entry
+			continue
+		}
+
+		// entry was loaded by other routine meanwhile, or stale data (or nothing)
+		// are served when loading is not allowed (checked only for entries which
+		// are really loaded, so half-open circuit probe is always reported)
+		if nowMillis < entry.nextReload.Load() || !c.loadAllowed(ID, nowMillis) {
+			entry.mu.Unlock()
+			read(ID, entry, entry.get(), true)
+			c.releaseEntry(entry)
+			continue
+		}
+
+		claimed[ID] = claimedEntry{entry: entry}
+		toLoad = append(toLoad, ID)
+	}
+
+	if created {
+		c.enforceCapacity()
+	}
+
+	if len(toLoad) > 0 {
+		loaded, unrequested := c.collectLoadedEntries(toLoad, c.loadMultiple(toLoad))
+
+		for _, ID := range toLoad {
+			loadedEntry := loaded[ID]
+			c.reportLoad(ID, loadedEntry.Err, nowMillis)
+
+			claimedEntry := claimed[ID]
+			value := c.storeLoadedValue(ID, claimedEntry.entry, claimedEntry.created, loadedEntry.Value, loadedEntry.Err, nowMillis)
+			read(ID, claimedEntry.entry, value, false)
+			c.releaseEntry(claimedEntry.entry)
+		}
+
+		if c.cacheUnrequested {
+			for _, loadedEntry := range unrequested {
+				c.addLoadedEntry(loadedEntry, nowMillis, 0, false)
+			}
+		}
+	}
+
+	for ID, entry := range waiting {
+		value, fromCache := c.waitForEntry(ID, entry, nowMillis)
+		read(ID, entry, value, fromCache)
+		c.releaseEntry(entry)
+	}
+
+	for ID, value := range result {
+		result[ID] = c.output(value)
+	}
+
+	return result
+}
+
+// waitForEntry waits until the entry is loaded by other routine and returns its
+// value (fromCache is false when the entry was loaded by this call). When the
+// entry was not loaded (e.g. the load failed), it is reloaded.
+func (c *Cache[K, T]) waitForEntry(ID K, entry *cachedEntry[T], nowMillis int64) (value *T, fromCache bool) {
+	entry.mu.Lock()
+
+	if nowMillis < entry.nextReload.Load() {
+		entry.mu.Unlock()
+
+		c.stats.dedupedLoads.Add(1)
+		if c.metrics != nil {
+			c.metrics.DedupedLoadCount.Inc()
+		}
+
+		return entry.get(), true
+	}
+
+	value, err := c.reloadEntry(c.ctx, ID, entry, nowMillis)
+	fromCache = errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPaused) || errors.Is(err, ErrFatal)
+
+	return value, fromCache
+}
+
+// loadMultiple loads entries by `LoadBatchFunc` in chunks of at most
+// `MaxBatchSize` IDs and merges their results
+func (c *Cache[K, T]) loadMultiple(IDs []K) []LoadedEntry[K, T] {
+	var loadedEntries []LoadedEntry[K, T]
+	if c.maxBatchSize == 0 || len(IDs) <= c.maxBatchSize {
+		loadedEntries = c.loadBatch(IDs)
+	} else {
+		loadedEntries = make([]LoadedEntry[K, T], 0, len(IDs))
+		for start := 0; start < len(IDs); start += c.maxBatchSize {
+			end := min(start+c.maxBatchSize, len(IDs))
+			loadedEntries = append(loadedEntries, c.loadBatch(IDs[start:end])...)
+		}
+	}
+
+	for _, loadedEntry := range loadedEntries {
+		c.checkFatal(loadedEntry.Err)
+	}
+
+	return loadedEntries
+}
+
+// loadBatch loads entries by one `LoadBatchFunc` call
+func (c *Cache[K, T]) loadBatch(IDs []K) []LoadedEntry[K, T] {
+	c.inFlight.add(IDs...)
+	defer c.inFlight.remove(IDs...)
+
+	start := time.Now()
+	defer c.logSlowLoad(loadKindMultiple, start, func(e *zerolog.Event) {
+		e.Int("keys", len(IDs))
+	})
+
+	if c.onLoadStart == nil && c.onLoadEnd == nil {
+		return c.limitedLoadMultiple(IDs)
+	}
+
+	contexts := make([]context.Context, len(IDs))
+	for i, ID := range IDs {
+		contexts[i] = c.startLoad(c.ctx, ID)
+	}
+
+	loadedEntries := c.limitedLoadMultiple(IDs)
+
+	// requested entries missing in the result are not found
+	errs := make(map[K]error, len(loadedEntries))
+	for _, loadedEntry := range loadedEntries {
+		if _, exists := errs[loadedEntry.ID]; !exists {
+			errs[loadedEntry.ID] = loadedEntry.Err
+		}
+	}
+	for i, ID := range IDs {
+		err, exists := errs[ID]
+		if !exists {
+			err = ErrNotFound
+		}
+		c.endLoad(contexts[i], ID, err)
+	}
+
+	return loadedEntries
+}
+
+// collectLoadedEntries matches entries loaded in batch to requested IDs. Requested
+// IDs missing in loaded entries are returned as not found. Only first occurrence
+// of duplicate IDs is used. Loaded entries which were not requested are returned
+// separately. `PostLoad` hook is applied on all returned entries.
+func (c *Cache[K, T]) collectLoadedEntries(
+	requested []K,
+	loadedEntries []LoadedEntry[K, T],
+) (loaded map[K]LoadedEntry[K, T], unrequested []LoadedEntry[K, T]) {
+	requestedSet := make(map[K]struct{}, len(requested))
+	for _, ID := range requested {
+		requestedSet[ID] = struct{}{}
+	}
+
+	loaded = make(map[K]LoadedEntry[K, T], len(requested))
+	unrequestedSet := make(map[K]struct{})
+	duplicates := 0
+
+	for _, loadedEntry := range loadedEntries {
+		ID := loadedEntry.ID
+
+		if _, isRequested := requestedSet[ID]; !isRequested {
+			if _, duplicate := unrequestedSet[ID]; duplicate {
+				duplicates++
+				continue
+			}
+			unrequestedSet[ID] = struct{}{}
+			unrequested = append(unrequested, loadedEntry)
+			continue
+		}
+
+		if _, duplicate := loaded[ID]; duplicate {
+			duplicates++
+			continue
+		}
+		loaded[ID] = loadedEntry
+	}
+
+	if duplicates > 0 {
+		c.log.Warn().
+			Int("duplicates", duplicates).
+			Msg("batch load returned duplicate entries, using first occurrence")
+	}
+
+	for _, ID := range requested {
+		if _, exists := loaded[ID]; !exists {
+			loaded[ID] = LoadedEntry[K, T]{ID: ID, Err: ErrNotFound}
+		}
+	}
+
+	if c.postLoadFunc != nil {
+		for ID, loadedEntry := range loaded {
+			loadedEntry.Value, loadedEntry.Err = c.postLoad(ID, loadedEntry.Value, loadedEntry.Err)
+			loaded[ID] = loadedEntry
+		}
+		for i := range unrequested {
+			unrequested[i].Value, unrequested[i].Err = c.postLoad(unrequested[i].ID, unrequested[i].Value, unrequested[i].Err)
+		}
+	}
+
+	return
+}
+
+// storeLoadedValue stores loaded value into claimed entry and returns value which
+// should be returned to the reader. Entry mutex has to be locked (it is unlocked
+// by this function) and reference of the entry held by the caller. Created entry
+// (placeholder) is removed when the value should not be cached.
+func (c *Cache[K, T]) storeLoadedValue(ID K, entry *cachedEntry[T], created bool, value *T, err error, nowMillis int64) *T {
+	ttl := entry.set(value, err, nowMillis, c.reloadOptions(ID), created)
+	entry.expireAfter(nowMillis, ttl)
+
+	entry.mu.Unlock()
+
+	// do not store into cache when TTL is 0
+	if created && ttl == 0 {
+		c.mu.Lock()
+		if c.data[ID] == entry {
+			c.deleteEntry(ID, entry)
+		}
+		c.mu.Unlock()
+
+		return entry.value.Load()
+	}
+
+	// update watchers
+	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
+	c.distributeLoadedEntry(ID, value, err, nowMillis)
+	if created {
+		c.emitEvent(EventLoad, ID)
+	} else {
+		c.emitEvent(EventReload, ID)
+	}
+
+	c.countLoad(false, err)
+
+	return entry.get()
+}
@@ -3,15 +3,17 @@ package lazy
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/moderntv/deathrow"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
-	"github.com/moderntv/lazy-cache/internal/memsize"
 	metrics_pkg "github.com/moderntv/lazy-cache/internal/metrics"
+	"github.com/moderntv/lazy-cache/internal/utils"
 )
 
 const (
@@ -21,20 +23,105 @@ const (
 	minAutomaticReloadDuration              = 100 * time.Millisecond
 )
 
+// Cache is a lazy loading cache of entries of type T identified by keys of type K.
+//
+// Entry values are stored (and returned by `Get`) as pointers, which makes reads
+// lock-free. Returned values are shared by all readers and must be treated as read-only
+// (unless `Params.Clone` is set).
+// Pointer storage is recommended for small value types (e.g. int64 counters) as well:
+// `atomic.Value` allocates on store the same way and reads it slightly slower, mutex
+// guarded slot avoids the allocation, but its reads are an order of magnitude slower
+// (see storage benchmarks in tests).
+// Loaders of small values can avoid repeated allocations by returning pointers
+// to shared immutable values (e.g. pre-allocated enum values).
+//
+// Entries are stored in a single map guarded by RWMutex (read lock is held only for
+// the map lookup), loads of an entry are coordinated by a per-entry mutex.
+// Routines waiting for the mutex read the value of the load they waited for, so
+// concurrent reads of an expired entry cause only one load.
+// `sync.Map` was not chosen, because cache keys churn (misses and TTL expirations
+// write into the map) and `sync.Map` writes are slower and allocate. Sharded map
+// would need a hash function for generic comparable keys, which is not available
+// without reflection (see `BenchmarkCacheMapParallel` and `BenchmarkCacheGetParallel`).
 type Cache[K comparable, T any] struct {
 	// static attributes (does not change its value after initialization)
-	ctx                 context.Context
-	log                 zerolog.Logger
-	metrics             *metrics_pkg.Metrics
-	name                string
-	timeouts            Timeouts
-	loadOneFunc         LoadOneFunc[K, T]
-	loadMultipleFunc    LoadMultipleFunc[K, T]
-	automaticReloadType AutomaticReload
-	ttlWatcher          *deathrow.Prison[K]
-	reloadWatcher       *deathrow.Prison[K]
+	ctx                   context.Context
+	log                   zerolog.Logger
+	metrics               *metrics_pkg.Metrics
+	name                  string
+	loadOneFunc           LoadOneFunc[K, T]
+	loadOneWithArgs       LoadOneWithArgsFunc[K, T] // loadOneFunc calls it with nil args when set
+	fallbacks             []LoadOneFunc[K, T]
+	loadMultipleFunc      LoadBatchFunc[K, T]
+	postLoadFunc          PostLoadFunc[K, T]
+	equal                 func(old, new *T) bool
+	clone                 func(value *T) *T
+	cacheUnrequested      bool
+	evictionPolicy        EvictionPolicy
+	evictionSamples       int
+	capacityDryRun        bool
+	measureMemsize        bool // memory size of cached values is measured periodically
+	memsizeWorkers        int
+	classifyError         ClassifyErrorFunc
+	permanentErrorTTL     func(err error) (time.Duration, bool)
+	shouldNegativeCache   func(ID K) bool
+	validKey              func(ID K) bool
+	normalize             func(ID K) K
+	onHealthChange        func(ID K, healthy bool)
+	onTTLAssigned         func(ID K, ttl, reloadInterval time.Duration)
+	onLoadStart           func(ctx context.Context, ID K) context.Context
+	onLoadEnd             func(ctx context.Context, ID K, err error)
+	slowLoadThreshold     time.Duration
+	loadLimiter           *LoadLimiter // loader calls are not limited when nil
+	mapCompactionRatio    float64
+	reloadAt              func(ID K, value *T) time.Time
+	expireAt              func(ID K, value *T) (time.Time, bool)
+	compressValues        bool
+	retainValueOnNotFound bool
+	skipCancelledLoads    bool
+	decayAccessCounts     bool
+	insertAfterLoad       bool
+	lazyFirstLoad         bool
+	coalesceColdLoads     time.Duration
+	maxStaleness          time.Duration
+	automaticReloadType   AutomaticReload
+	reloadBatchWindow     time.Duration
+	maxBatchSize          int
+	preloadWait           time.Duration
+	preloaded             chan struct{} // closed when preloading finishes (nil without preloading)
+	preloadResults        chan<- PreloadResult[K, T]
+	writeThrough          WriteThroughFunc[K, T]
+	writeThroughPolicy    WriteThroughPolicy
+	distributor           *distributor[K, T]
+	circuits              *circuits[K]
+	topKeys               *topKeys[K]
+	grace                 *graceRing[K, T]
+	ttlWatcher            entryWatcher[K]
+	reloadWatcher         entryWatcher[K]
+	events                chan CacheEvent[K]
+	clock                 Clock
 	// dynamic attributes (not using mutex)
-	memSizeValue atomic.Uint64
+	timeouts          atomic.Pointer[Timeouts]
+	hardMemoryCeiling atomic.Uint64
+	maxMemoryBytes    atomic.Uint64 // memory budget enforced by eviction (see `Resize`)
+	maxEntries        atomic.Int64
+	trackAccess       atomic.Bool // store time of last access of entries
+	memSizeValue      atomic.Uint64
+	stats             cacheStats
+	entryPool         sync.Pool // pool of unused entries (see `newEntry`)
+	inFlight          inFlightSet[K]
+	detachedLoads     detachedLoads[K]
+	tryGetLoads       sync.Map // keys whose detached load was started by `TryGet`
+	background        backgroundLoads
+	mapDeletions      int         // entries deleted from data since the map was rebuilt (guarded by mu)
+	mapRebuilding     atomic.Bool // automatic rebuild of the map is scheduled (see `Compact`)
+	health            loadOutcomes
+	paused            atomic.Bool           // loads are not allowed (see `Pause`)
+	fatalErr          atomic.Pointer[error] // loads are disabled by fatal loader error (see `ErrFatal`)
+	pausedReloads     pausedReloads[K]
+	pinned            pinnedKeys[K]                               // keys exempt from expiration and eviction (see `Pin`)
+	indexes           atomic.Pointer[map[string]cacheIndex[K, T]] // secondary indexes (copied on write)
+	indexesMu         sync.Mutex                                  // serializes adding of indexes
 	// attributes protected by mutex
 	mu   sync.RWMutex
 	data map[K]*cachedEntry[T]
@@ -48,7 +135,19 @@ func NewCache[K comparable, T any](params Params[K, T]) (c *Cache[K, T], err err
 
 	var metrics *metrics_pkg.Metrics
 	if params.MetricsRegistry != nil {
-		metrics, err = metrics_pkg.New(params.Name, params.MetricsRegistry)
+		metrics, err = metrics_pkg.New(params.Name, params.MetricsLabels, params.MetricsRegistry)
+		if errors.Is(err, metrics_pkg.ErrAlreadyRegistered) {
+			err = fmt.Errorf("%w: %s", ErrMetricsRegistered, params.Name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if params.MetricsRegisterer != nil {
+		metrics, err = metrics_pkg.NewWithRegisterer(params.Name, params.MetricsLabels, params.MetricsRegisterer)
+		if errors.Is(err, metrics_pkg.ErrAlreadyRegistered) {
+			err = fmt.Errorf("%w: %s", ErrMetricsRegistered, params.Name)
+		}
 		if err != nil {
 			return
 		}
@@ -57,26 +156,141 @@ func NewCache[K comparable, T any](params Params[K, T]) (c *Cache[K, T], err err
 	log := params.Log.With().Str("cache", params.Name).Logger()
 
 	c = &Cache[K, T]{
-		ctx:                 params.Context,
-		log:                 log,
-		metrics:             metrics,
-		name:                params.Name,
-		timeouts:            params.Timeouts,
-		loadOneFunc:         params.LoadOneFunc,
-		loadMultipleFunc:    params.LoadMultipleFunc,
-		automaticReloadType: params.AutomaticReload,
-		ttlWatcher:          deathrow.NewPrison[K](),
-		reloadWatcher:       deathrow.NewPrison[K](),
-		data:                make(map[K]*cachedEntry[T]),
+		ctx:                   params.Context,
+		log:                   log,
+		metrics:               metrics,
+		name:                  params.Name,
+		loadOneFunc:           params.LoadOneFunc,
+		loadOneWithArgs:       params.LoadOneWithArgsFunc,
+		fallbacks:             slices.Clone(params.Fallbacks),
+		loadMultipleFunc:      params.loadBatch(),
+		postLoadFunc:          params.PostLoad,
+		equal:                 params.Equal,
+		clone:                 params.Clone,
+		cacheUnrequested:      params.CacheUnrequestedEntries,
+		evictionPolicy:        params.EvictionPolicy,
+		evictionSamples:       params.EvictionSamples,
+		capacityDryRun:        params.CapacityDryRun,
+		classifyError:         params.ClassifyError,
+		permanentErrorTTL:     params.PermanentErrorTTL,
+		shouldNegativeCache:   params.ShouldNegativeCache,
+		validKey:              params.ValidKey,
+		normalize:             params.NormalizeKey,
+		onHealthChange:        params.OnHealthChange,
+		onTTLAssigned:         params.OnTTLAssigned,
+		onLoadStart:           params.OnLoadStart,
+		onLoadEnd:             params.OnLoadEnd,
+		slowLoadThreshold:     params.SlowLoadThreshold,
+		loadLimiter:           params.LoadLimiter,
+		mapCompactionRatio:    params.MapCompactionRatio,
+		reloadAt:              params.ReloadAt,
+		expireAt:              params.ExpireAt,
+		compressValues:        params.Compress,
+		retainValueOnNotFound: params.RetainValueOnNotFound,
+		skipCancelledLoads:    params.SkipCancelledLoads,
+		decayAccessCounts:     params.DecayAccessCounts,
+		memsizeWorkers:        params.MemsizeWorkers,
+		insertAfterLoad:       params.InsertAfterLoad,
+		lazyFirstLoad:         params.LazyFirstLoad,
+		coalesceColdLoads:     params.CoalesceColdLoads,
+		maxStaleness:          params.MaxStaleness,
+		automaticReloadType:   params.AutomaticReload,
+		reloadBatchWindow:     params.AutomaticReloadBatchWindow,
+		maxBatchSize:          params.MaxBatchSize,
+		preloadWait:           params.PreloadWait,
+		preloadResults:        params.PreloadResults,
+		clock:                 params.Clock,
+		writeThrough:          params.WriteThrough,
+		writeThroughPolicy:    params.WriteThroughPolicy,
+		data:                  make(map[K]*cachedEntry[T]),
+	}
+
+	c.guardHooks()
+
+	// loads without arguments (see `GetWithArgs`)
+	if c.loadOneWithArgs != nil {
+		c.loadOneFunc = func(ID K) (*T, error) {
+			return c.loadOneWithArgs(ID, nil)
+		}
+	}
+
+	timeouts := params.Timeouts
+	c.timeouts.Store(&timeouts)
+
+	c.hardMemoryCeiling.Store(params.HardMemoryCeiling)
+	c.maxEntries.Store(int64(params.MaxEntries))
+	c.trackAccess.Store(params.MaxEntries > 0 || params.Timeouts.NegativeCompaction > 0 || params.MemoryPressure != nil)
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+
+	// watchers are set before anything can schedule entries
+	var sched *scheduler[K]
+	var ttlWatcher, reloadWatcher *watcher[K]
+	if params.SingleScheduler {
+		sched = newScheduler[K](c.clock.Now)
+		// clock other than real one can be moved forward at any time
+		if _, isReal := c.clock.(realClock); !isReal {
+			sched.poll = schedulerClockPoll
+		}
+		c.ttlWatcher = scheduledWatcher[K]{s: sched, kind: scheduleExpire}
+		c.reloadWatcher = scheduledWatcher[K]{s: sched, kind: scheduleReload}
+	} else {
+		ttlWatcher, reloadWatcher = newWatcher[K](), newWatcher[K]()
+		c.ttlWatcher, c.reloadWatcher = ttlWatcher, reloadWatcher
+	}
+
+	if params.TopKeys > 0 {
+		c.topKeys = newTopKeys[K](params.TopKeys)
+	}
+
+	if params.GraceEntries > 0 {
+		c.grace = newGraceRing[K, T](params.GraceEntries)
+	}
+
+	if params.EventsBuffer > 0 {
+		c.events = make(chan CacheEvent[K], params.EventsBuffer)
+	}
+
+	if c.evictionSamples == 0 {
+		c.evictionSamples = defaultEvictionSamples
+	}
+
+	c.background.limit = params.MaxBackgroundLoads
+	if c.background.limit == 0 {
+		c.background.limit = defaultMaxBackgroundLoads
+	}
+
+	c.health.init(params.HealthWindow, params.HealthMaxErrorRate)
+
+	if params.MaxConcurrentLoads > 0 {
+		c.loadLimiter = NewLoadLimiter(params.MaxConcurrentLoads)
+	}
+
+	for _, ID := range params.PinnedKeys {
+		c.Pin(ID)
+	}
+
+	if params.CircuitBreaker != nil {
+		c.circuits = newCircuits[K](params.CircuitBreaker)
+	}
+
+	if params.Distribution != nil {
+		c.startDistribution(params.Distribution)
 	}
 
 	if params.PreloadChan != nil {
+		c.preloaded = make(chan struct{})
 		go c.startPreloading(params.PreloadChan)
 	} else {
 		c.log.Info().Msg("preloading disabled")
 	}
 
-	go c.startTTLWatcher()
+	if sched != nil {
+		go c.startScheduler(sched)
+	} else {
+		go c.startTTLWatcher(ttlWatcher)
+	}
 
 	if c.automaticReloadType != AutomaticReloadDisabled {
 		realMinReloadInterval := time.Duration(
@@ -91,145 +305,1039 @@ func NewCache[K comparable, T any](params Params[K, T]) (c *Cache[K, T], err err
 				Msg("combination of automatic reload interval is too short, setting to minimum default value")
 		}
 
-		go c.startReloadWatcher()
+		if sched == nil {
+			go c.startReloadWatcher(reloadWatcher)
+		}
+
+	} else {
+		c.log.Info().Msg("automatic reload disabled")
+	}
+
+	// memory size is needed for metrics and hard memory ceiling
+	c.measureMemsize = (c.metrics != nil || params.HardMemoryCeiling > 0) && params.Timeouts.MemsizeUpdate > 0
+	if c.measureMemsize {
+		go c.startMemoryMeassurement(params.Timeouts.MemsizeUpdate)
+	} else {
+		c.log.Info().Msg("memory size calculation is disabled")
+	}
+
+	if params.Timeouts.NegativeCompaction > 0 {
+		go c.startNegativeCompaction(params.Timeouts.NegativeCompaction)
+	}
+
+	if params.MemoryPressure != nil {
+		go c.startMemoryPressureWatcher(*params.MemoryPressure)
+	}
+
+	return
+}
+
+func (c *Cache[K, T]) Get(ID K) *T {
+	return c.output(c.get(c.ctx, ID, nil))
+}
+
+// output returns cached value in the form returned to callers (decompressed and
+// cloned when `Clone` is set)
+func (c *Cache[K, T]) output(value *T) *T {
+	value = c.decompress(value)
+	if c.clone == nil || value == nil {
+		return value
+	}
+
+	return c.clone(value)
+}
+
+// GetWithContext returns value of the entry the same way as `Get`, but the load
+// triggered by this read gets given context in `OnLoadStart` hook (e.g. as parent
+// of tracing span). The context is not passed to loaders and its cancellation
+// does not stop the read (see `WaitForKey`).
+func (c *Cache[K, T]) GetWithContext(ctx context.Context, ID K) *T {
+	return c.output(c.get(ctx, ID, nil))
+}
+
+// get returns value of the entry (loads it when needed) and fills metadata of
+// the entry (when meta is not nil)
+func (c *Cache[K, T]) get(ctx context.Context, ID K, meta *EntryMeta) *T {
+	ID = c.normalizeKey(ID)
+
+	c.countRead()
+
+	if !c.isValidKey(ID) {
+		c.stats.misses.Add(1)
+		return nil
+	}
+
+	entry, exists := c.acquireEntry(ID)
+
+	nowMillis := c.nowMillis()
+	created := false
+	graced := false // new entry was restored from grace ring
+
+	// not found in cache
+	if !exists {
+		if c.overMemoryCeiling() || c.paused.Load() {
+			c.stats.misses.Add(1)
+			return nil
+		}
+
+		if err := c.FatalError(); err != nil {
+			c.stats.misses.Add(1)
+			if meta != nil {
+				*meta = EntryMeta{Err: err}
+			}
+			return nil
+		}
+
+		// entry may be preloaded meanwhile (checked under the lock)
+		if c.waitForPreload() {
+			nowMillis = c.nowMillis()
+		}
+
+		if c.insertAfterLoad {
+			c.stats.misses.Add(1)
+			return c.loadDetachedEntry(ctx, ID, meta, nowMillis)
+		}
+
+		c.mu.Lock()
+
+		// check if entry was not created by other routine during waiting for lock
+		entry, exists = c.data[ID]
+		if exists {
+			entry.refs.Add(1)
+		} else {
+			entry = c.newEntry()
+			entry.mu.Lock()
+			c.markAccess(entry, nowMillis)
+			graced = c.restoreGraceEntry(ID, entry)
+			c.storeEntry(ID, entry)
+			created = true
+		}
+
+		c.mu.Unlock()
+	}
+	defer c.releaseEntry(entry)
+
+	entry.accessCount.Add(1)
+
+	// metadata are read before the entry is released
+	fromCache := true
+	defer func() {
+		if fromCache {
+			c.stats.hits.Add(1)
+		} else {
+			c.stats.misses.Add(1)
+		}
+	}()
+	if meta != nil {
+		defer func() {
+			*meta = entryMeta(entry, fromCache)
+		}()
+	}
+	if c.topKeys != nil {
+		defer func() {
+			c.topKeys.read(ID, fromCache)
+		}()
+	}
+
+	if created {
+		c.enforceCapacity()
+		if graced {
+			return c.notTooStale(entry, c.revalidateGraceEntry(ID, entry, nowMillis), nowMillis)
+		}
+
+		fromCache = false
+		if c.lazyFirstLoad {
+			// reference of the entry is released after the load
+			entry.refs.Add(1)
+			c.runInBackground(func() {
+				defer c.releaseEntry(entry)
+				c.loadNewEntry(c.ctx, ID, entry, nowMillis)
+			})
+			return nil
+		}
+		return c.loadNewEntry(ctx, ID, entry, nowMillis)
+	}
+
+	c.markAccess(entry, nowMillis)
+
+	// valid value
+	if nowMillis < entry.nextReload.Load() {
+		return c.notTooStale(entry, entry.get(), nowMillis)
+	}
+
+	// data are expired, check if entry is being reloaded
+	loads := entry.loads.Load()
+	if c.lazyFirstLoad && loads == 0 {
+		// first load of the entry is running in the background
+		if !entry.mu.TryLock() {
+			fromCache = false
+			return nil
+		}
+	} else {
+		entry.mu.Lock()
+	}
+
+	// check if entry was loaded by other routine during waiting for lock
+	if entry.loadedMeanwhile(loads, nowMillis) {
+		entry.mu.Unlock()
+
+		c.stats.dedupedLoads.Add(1)
+		if c.metrics != nil {
+			c.metrics.DedupedLoadCount.Inc()
+		}
+
+		return c.notTooStale(entry, entry.get(), nowMillis)
+	}
+
+	value, err := c.reloadEntry(ctx, ID, entry, nowMillis)
+	fromCache = errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPaused) || errors.Is(err, ErrFatal)
+
+	return c.notTooStale(entry, value, nowMillis)
+}
+
+// notTooStale returns the value unless the entry was last loaded earlier than
+// `MaxStaleness` ago (nil is returned then)
+func (c *Cache[K, T]) notTooStale(entry *cachedEntry[T], value *T, nowMillis int64) *T {
+	if c.maxStaleness == 0 || value == nil {
+		return value
+	}
+
+	lastLoaded := entry.lastLoaded.Load()
+	if lastLoaded != 0 && nowMillis-lastLoaded > c.maxStaleness.Milliseconds() {
+		return nil
+	}
+
+	return value
+}
+
+// reloadEntry reloads data of cached entry and returns its value and load error
+// (except not found). Entry mutex has to be locked and reference of the entry held
+// by the caller. The mutex is unlocked by this function.
+func (c *Cache[K, T]) reloadEntry(ctx context.Context, ID K, entry *cachedEntry[T], nowMillis int64) (*T, error) {
+	// serve stale data when loading is not allowed
+	if !c.loadAllowed(ID, nowMillis) {
+		entry.mu.Unlock()
+
+		if err := c.FatalError(); err != nil {
+			return entry.get(), err
+		}
+		if c.paused.Load() {
+			return entry.get(), ErrPaused
+		}
+		return entry.get(), ErrCircuitOpen
+	}
+
+	loadedValue, err := c.loadOne(ctx, ID)
+	c.reportLoad(ID, err, nowMillis)
+	ttl := entry.set(loadedValue, err, nowMillis, c.reloadOptions(ID), false)
+	entry.expireAfter(nowMillis, ttl)
+
+	entry.mu.Unlock()
+
+	// update watchers
+	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
+	c.distributeLoadedEntry(ID, loadedValue, err, nowMillis)
+	c.emitEvent(EventReload, ID)
+
+	c.countLoad(false, err)
+
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+
+	return entry.get(), err
+}
+
+// normalizeKey returns canonical form of the key (see `NormalizeKey`)
+func (c *Cache[K, T]) normalizeKey(ID K) K {
+	if c.normalize == nil {
+		return ID
+	}
+
+	return c.normalize(ID)
+}
+
+// isValidKey returns false when the key is rejected by `ValidKey`
+func (c *Cache[K, T]) isValidKey(ID K) bool {
+	return c.validKey == nil || c.validKey(ID)
+}
+
+// loadOne loads entry by `LoadOneFunc` and applies `PostLoad` hook on the result
+func (c *Cache[K, T]) loadOne(ctx context.Context, ID K) (*T, error) {
+	c.inFlight.add(ID)
+	// deferred, so a panic cannot leave the key listed
+	defer c.inFlight.remove(ID)
+	ctx = c.startLoad(ctx, ID)
+	start := time.Now()
+	value, err := c.limitedLoadOne(c.loaderFor(ctx), ID)
+	c.checkFatal(err)
+	for _, fallback := range c.fallbacks {
+		if err == nil || c.errorClass(err) == ErrorClassNotFound {
+			break
+		}
+		value, err = c.limitedLoadOne(fallback, ID)
+		c.checkFatal(err)
+	}
+	c.logSlowLoad(loadKindOne, start, func(e *zerolog.Event) {
+		e.Interface("key", ID)
+	})
+	c.endLoad(ctx, ID, err)
+
+	return c.postLoad(ID, value, err)
+}
+
+// errorClass classifies load error by `ClassifyError` (or `DefaultClassifyError`)
+func (c *Cache[K, T]) errorClass(err error) ErrorClass {
+	if c.classifyError != nil {
+		return c.classifyError(err)
+	}
+
+	return DefaultClassifyError(err)
+}
+
+// postLoad applies `PostLoad` hook on loaded entry (when set)
+func (c *Cache[K, T]) postLoad(ID K, value *T, err error) (*T, error) {
+	if c.postLoadFunc == nil {
+		return value, err
+	}
+
+	return c.postLoadFunc(ID, value, err)
+}
+
+// reloadOptions returns options for setting reloaded data into cached entries
+// (changes of values are counted when `Equal` is set)
+func (c *Cache[K, T]) reloadOptions(ID K) *entryOptions[T] {
+	opts := c.entryOptions(ID)
+	if c.equal != nil {
+		opts.reloaded = c.countReload
+	}
+
+	return opts
+}
+
+// countReload counts reload as changed or unchanged according to `Equal`
+func (c *Cache[K, T]) countReload(oldValue, newValue *T) {
+	oldValue = c.decompress(oldValue)
+
+	unchanged := oldValue == newValue
+	if oldValue != nil && newValue != nil && !unchanged {
+		unchanged = c.equal(oldValue, newValue)
+	}
+
+	if unchanged {
+		c.stats.unchangedReloads.Add(1)
+		if c.metrics != nil {
+			c.metrics.UnchangedReloadCount.Inc()
+		}
+		return
+	}
+
+	c.stats.changedReloads.Add(1)
+	if c.metrics != nil {
+		c.metrics.ChangedReloadCount.Inc()
+	}
+}
+
+// entryOptions returns current options for setting loaded data into entries
+func (c *Cache[K, T]) entryOptions(ID K) *entryOptions[T] {
+	opts := &entryOptions[T]{
+		timeouts:              c.timeouts.Load(),
+		classifyError:         c.classifyError,
+		retainValueOnNotFound: c.retainValueOnNotFound,
+		permanentErrorTTL:     c.permanentErrorTTL,
+		skipCancelled:         c.skipCancelledLoads,
+		decayAccessCount:      c.decayAccessCounts,
+	}
+
+	if c.shouldNegativeCache != nil {
+		opts.shouldNegativeCache = func() bool {
+			return c.shouldNegativeCache(ID)
+		}
+	}
+
+	if c.reloadAt != nil {
+		opts.reloadAt = func(value *T) time.Time {
+			return c.reloadAt(ID, value)
+		}
+	}
+
+	if c.expireAt != nil {
+		opts.expireAt = func(value *T) (time.Time, bool) {
+			return c.expireAt(ID, value)
+		}
+	}
+
+	if c.compressValues {
+		opts.compress = c.compress
+	}
+
+	if c.onTTLAssigned != nil {
+		opts.ttlAssigned = func(ttl, reloadInterval time.Duration) {
+			c.onTTLAssigned(ID, ttl, reloadInterval)
+		}
+	}
+
+	if indexes := c.indexes.Load(); indexes != nil {
+		opts.stored = func(entry *cachedEntry[T], value *T) {
+			// cache lock orders the update with removal of the entry (see
+			// `deleteEntry`), so entry removed during its load is not indexed again
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			if entry.removed.Load() {
+				return
+			}
+			for _, index := range *indexes {
+				index.store(ID, value)
+			}
+		}
+	}
+
+	return opts
+}
+
+// overMemoryCeiling returns true (and counts rejected insertion) when new entries
+// cannot be inserted into cache, because memory size exceeds hard memory ceiling.
+func (c *Cache[K, T]) overMemoryCeiling() bool {
+	hardMemoryCeiling := c.hardMemoryCeiling.Load()
+	if hardMemoryCeiling == 0 || c.memSizeValue.Load() <= hardMemoryCeiling {
+		return false
+	}
+
+	c.stats.rejectedInsertions.Add(1)
+	if c.metrics != nil {
+		c.metrics.RejectedInsertionCount.Inc()
+	}
+
+	return true
+}
+
+// loadNewEntry loads data of entry which was not found in cache. Entry has to be
+// already stored in cache, its mutex locked and its reference held by the caller.
+func (c *Cache[K, T]) loadNewEntry(ctx context.Context, ID K, entry *cachedEntry[T], nowMillis int64) *T {
+	// do not cache the entry when loading is not allowed
+	if !c.loadAllowed(ID, nowMillis) {
+		entry.mu.Unlock()
+
+		c.mu.Lock()
+		if c.data[ID] == entry {
+			c.deleteEntry(ID, entry)
+		}
+		c.mu.Unlock()
+
+		return nil
+	}
+
+	loadedValue, err := c.loadOne(ctx, ID)
+	c.reportLoad(ID, err, nowMillis)
+	ttl := entry.set(loadedValue, err, nowMillis, c.entryOptions(ID), true)
+	entry.expireAfter(nowMillis, ttl)
+
+	entry.mu.Unlock()
+
+	c.mu.Lock()
+
+	// entry could be removed (or replaced) by other routine during loading,
+	// in that case the load is discarded
+	current, exists := c.data[ID]
+	removed := !exists || current != entry
+
+	// do not store into cache when TTL is 0
+	if ttl == 0 && !removed {
+		c.deleteEntry(ID, entry)
+	}
+
+	c.mu.Unlock()
+
+	if removed || ttl == 0 {
+		return entry.value.Load()
+	}
+
+	c.newEntryLoaded(ID, entry, ttl, loadedValue, err, nowMillis)
+
+	return entry.get()
+}
+
+// loadDetachedEntry loads not cached entry without inserting it into cache before
+// the load (see `Params.InsertAfterLoad`) and returns its value. The entry is
+// inserted only when it should be cached and it was not cached by other routine
+// meanwhile.
+func (c *Cache[K, T]) loadDetachedEntry(ctx context.Context, ID K, meta *EntryMeta, nowMillis int64) *T {
+	if c.coalesceColdLoads > 0 {
+		cached, finish := c.waitForDetachedLoad(ctx, ID)
+		if cached != nil {
+			defer c.releaseEntry(cached)
+
+			if meta != nil {
+				*meta = entryMeta(cached, true)
+			}
+			if c.topKeys != nil {
+				c.topKeys.read(ID, true)
+			}
+
+			c.markAccess(cached, nowMillis)
+			return c.notTooStale(cached, cached.get(), nowMillis)
+		}
+		if finish != nil {
+			defer finish()
+		}
+	}
+
+	entry := c.newEntry()
+	defer c.releaseEntry(entry)
+
+	// metadata are read before the entry is released
+	if meta != nil {
+		defer func() {
+			*meta = entryMeta(entry, false)
+		}()
+	}
+	if c.topKeys != nil {
+		defer c.topKeys.read(ID, false)
+	}
+
+	if !c.loadAllowed(ID, nowMillis) {
+		return nil
+	}
+
+	c.markAccess(entry, nowMillis)
+	loadedValue, err := c.loadOne(ctx, ID)
+	c.reportLoad(ID, err, nowMillis)
+	ttl := entry.set(loadedValue, err, nowMillis, c.entryOptions(ID), true)
+	if ttl == 0 {
+		return entry.value.Load()
+	}
+	entry.expireAfter(nowMillis, ttl)
+
+	c.mu.Lock()
+	_, exists := c.data[ID]
+	if !exists {
+		c.storeEntry(ID, entry)
+	}
+	c.mu.Unlock()
+
+	if exists {
+		return entry.value.Load()
+	}
+
+	c.enforceCapacity()
+	c.newEntryLoaded(ID, entry, ttl, loadedValue, err, nowMillis)
+
+	return entry.get()
+}
+
+// newEntryLoaded updates watchers of new entry stored into cache after its load
+// and reports the load
+func (c *Cache[K, T]) newEntryLoaded(ID K, entry *cachedEntry[T], ttl time.Duration, loadedValue *T, err error, nowMillis int64) {
+	// update watchers
+	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
+	c.distributeLoadedEntry(ID, loadedValue, err, nowMillis)
+	c.emitEvent(EventLoad, ID)
+
+	c.countLoad(false, err)
+}
+
+func (c *Cache[K, T]) Remove(ID K) {
+	ID = c.normalizeKey(ID)
+
+	c.mu.Lock()
+
+	c.dropGraceEntry(ID)
+
+	entry, exists := c.data[ID]
+	if !exists {
+		c.mu.Unlock()
+		return
+	}
+	c.deleteEntry(ID, entry)
+
+	c.mu.Unlock()
+
+	// remove watchers
+	c.ttlWatcher.Drop(ID)
+	c.reloadWatcher.Drop(ID)
+	c.emitEvent(EventEvict, ID)
+
+	if c.metrics != nil {
+		c.metrics.ItemsCount.Dec()
+	}
+}
+
+func (c *Cache[K, T]) Invalidate(ID K) {
+	ID = c.normalizeKey(ID)
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return
+	}
+	defer c.releaseEntry(entry)
+
+	entry.nextReload.Store(0)
+	c.emitEvent(EventInvalidate, ID)
+
+	if c.automaticReloadType != AutomaticReloadDisabled {
+		c.reloadWatcher.Push(ID, 0)
+	}
+}
+
+// InvalidateWithTTL invalidates the entry as `Invalidate` and sets TTL used by
+// its next successful load instead of `Timeouts.TTL` (e.g. shorter TTL during
+// a burst of changes of the record). Following loads use default timeouts again.
+// Non-positive TTL is ignored. Nothing is done when the entry is not cached.
+func (c *Cache[K, T]) InvalidateWithTTL(ID K, ttl time.Duration) {
+	ID = c.normalizeKey(ID)
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return
+	}
+	defer c.releaseEntry(entry)
+
+	// stored before the entry is invalidated, so its reload uses the override
+	if ttl > 0 {
+		entry.ttlOverride.Store(max(ttl.Milliseconds(), 1))
+	}
+	entry.nextReload.Store(0)
+	c.emitEvent(EventInvalidate, ID)
+
+	if c.automaticReloadType != AutomaticReloadDisabled {
+		c.reloadWatcher.Push(ID, 0)
+	}
+}
+
+// InvalidateAndWait reloads the entry synchronously and returns its new value,
+// so following reads see fresh data. Loads of the entry already in progress are
+// waited for, but their results are not used. Not cached entry is loaded as by
+// `Get`. When the reload does not finish within the timeout, `ErrTimeout` is
+// returned (the reload continues in the background). Load errors (except not
+// found) are returned together with the value which is kept in cache.
+// `ErrCacheClosed` is returned when the cache is closed (before or during waiting).
+func (c *Cache[K, T]) InvalidateAndWait(ID K, timeout time.Duration) (*T, error) {
+	if c.ctx.Err() != nil {
+		return nil, ErrCacheClosed
+	}
+
+	ID = c.normalizeKey(ID)
+
+	type result struct {
+		value *T
+		err   error
+	}
+
+	// buffered, so the reload does not block when waiting times out
+	done := make(chan result, 1)
+
+	go func() {
+		entry, exists := c.acquireEntry(ID)
+		if !exists {
+			done <- result{value: c.get(c.ctx, ID, nil)}
+			return
+		}
+		defer c.releaseEntry(entry)
+
+		entry.nextReload.Store(0)
+		c.emitEvent(EventInvalidate, ID)
+		entry.mu.Lock()
+
+		value, err := c.reloadEntry(c.ctx, ID, entry, c.nowMillis())
+		done <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return c.output(res.value), res.err
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-c.ctx.Done():
+		return nil, ErrCacheClosed
+	}
+}
+
+// WaitForKey returns value of the entry once it is loaded. Loads of the entry
+// already in progress are waited for (expired or not cached entry is loaded as
+// by `Get`). When the entry has no value after the load (not found or the load
+// failed), `ErrNotFound` is returned. When the context is cancelled before, its
+// error is returned (the load continues in the background, its context keeps
+// values of the context, but it is not cancelled with it). `ErrCacheClosed` is
+// returned when the cache is closed (before or during waiting).
+func (c *Cache[K, T]) WaitForKey(ctx context.Context, ID K) (*T, error) {
+	if c.ctx.Err() != nil {
+		return nil, ErrCacheClosed
+	}
+
+	// buffered, so the load does not block when waiting is cancelled
+	done := make(chan *T, 1)
+
+	loadCtx := context.WithoutCancel(ctx)
+	go func() {
+		done <- c.get(loadCtx, ID, nil)
+	}()
+
+	select {
+	case value := <-done:
+		if value == nil {
+			return nil, ErrNotFound
+		}
+		return c.output(value), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrCacheClosed
+	}
+}
+
+// Name returns name of the cache (see `Params.Name`).
+func (c *Cache[K, T]) Name() string {
+	return c.name
+}
+
+// AutomaticReloadMode returns automatic reload mode of the cache (see
+// `Params.AutomaticReload`).
+func (c *Cache[K, T]) AutomaticReloadMode() AutomaticReload {
+	return c.automaticReloadType
+}
+
+// Timeouts returns current timeouts of the cache (as set by `NewCache` or
+// `SetTimeouts`).
+func (c *Cache[K, T]) Timeouts() Timeouts {
+	return *c.timeouts.Load()
+}
+
+// SetTimeouts validates and replaces cache timeouts at runtime (without dropping
+// cached entries). New timeouts apply to subsequent (re)loads. Already scheduled
+// expirations and reloads keep their timing until they fire (or until the entry
+// is reloaded). Changes of `MemsizeUpdate` and `NegativeCompaction` are ignored.
+func (c *Cache[K, T]) SetTimeouts(timeouts Timeouts) error {
+	err := timeouts.check()
+	if err != nil {
+		return err
+	}
+
+	timeouts.MemsizeUpdate = c.timeouts.Load().MemsizeUpdate
+	timeouts.NegativeCompaction = c.timeouts.Load().NegativeCompaction
+	c.timeouts.Store(&timeouts)
+
+	return nil
+}
+
+// Touch renews TTL of cached entry without reloading it and marks the entry as
+// accessed. Returns false when the entry is not cached.
+func (c *Cache[K, T]) Touch(ID K) bool {
+	ID = c.normalizeKey(ID)
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return false
+	}
+	defer c.releaseEntry(entry)
+
+	timeouts := c.timeouts.Load()
+	ttl := utils.RandomizeDuration(c.touchTTL(entry, timeouts), timeouts.Randomizer)
+
+	if !entry.accessed.Load() {
+		entry.accessed.Store(true)
+	}
+	entry.expiresAt.Store(c.nowMillis() + ttl.Milliseconds())
+	c.ttlWatcher.Push(ID, ttl)
+
+	return true
+}
+
+// touchTTL returns TTL renewed by `Touch`, entry without value keeps TTL of its
+// error class (the same as it got by its load)
+func (c *Cache[K, T]) touchTTL(entry *cachedEntry[T], timeouts *Timeouts) time.Duration {
+	if entry.value.Load() != nil {
+		return timeouts.TTL
+	}
+
+	err := entry.loadErr()
+	if err == nil {
+		return timeouts.NotFoundTTL
+	}
+
+	switch c.errorClass(err) {
+	case ErrorClassNotFound:
+		return timeouts.NotFoundTTL
+	case ErrorClassPermanent:
+		if c.permanentErrorTTL != nil {
+			if errTTL, ok := c.permanentErrorTTL(err); ok {
+				return errTTL
+			}
+		}
+		return timeouts.PermanentErrorTTL
+	default:
+		return timeouts.ErrorTTL
+	}
+}
+
+// Set stores value of entry into cache as if it was loaded (TTL and reload interval
+// are renewed). Nil value is stored as not found entry.
+// When `WriteThrough` is set, the value is persisted first. If persisting fails,
+// the error is returned and cache is updated according to `WriteThroughPolicy`.
+// `ErrCacheClosed` is returned (and nothing is stored) when the cache is closed.
+func (c *Cache[K, T]) Set(ID K, value *T) (err error) {
+	if c.ctx.Err() != nil {
+		return ErrCacheClosed
+	}
+
+	ID = c.normalizeKey(ID)
 
+	c.mu.Lock()
+
+	entry, exists := c.data[ID]
+	if exists {
+		entry.refs.Add(1)
 	} else {
-		c.log.Info().Msg("automatic reload disabled")
+		entry = c.newEntry()
+		entry.mu.Lock()
+		c.markAccess(entry, c.nowMillis())
+		c.storeEntry(ID, entry)
 	}
 
-	if c.metrics != nil && params.Timeouts.MemsizeUpdate > 0 {
-		go c.startMemoryMeassurement(params.Timeouts.MemsizeUpdate)
+	c.mu.Unlock()
+
+	defer c.releaseEntry(entry)
+
+	if exists {
+		entry.mu.Lock()
 	} else {
-		c.log.Info().Msg("memory size calculation is disabled")
+		c.enforceCapacity()
 	}
 
-	return
-}
+	if c.writeThrough != nil {
+		err = c.writeThrough(ID, value)
+		if err != nil {
+			err = fmt.Errorf("cannot write through value: %w", err)
 
-func (c *Cache[K, T]) Get(ID K) *T {
-	c.mu.RLock()
-	entry, exists := c.data[ID]
-	c.mu.RUnlock()
+			if c.writeThroughPolicy == WriteThroughStrict {
+				entry.mu.Unlock()
 
-	if c.metrics != nil {
-		c.metrics.ReadsCount.Inc()
+				// remove entry created only for this set
+				if !exists {
+					c.mu.Lock()
+					if c.data[ID] == entry {
+						c.deleteEntry(ID, entry)
+					}
+					c.mu.Unlock()
+				}
+
+				return
+			}
+		}
 	}
 
-	nowMillis := time.Now().UnixMilli()
+	var loadErr error
+	if value == nil {
+		loadErr = ErrNotFound
+	}
 
-	// entry found in cache
-	if exists {
-		// valid value
-		if nowMillis < entry.nextReload.Load() {
-			return entry.get()
-		}
+	nowMillis := c.nowMillis()
+	ttl := entry.set(value, loadErr, nowMillis, c.entryOptions(ID), !exists)
+	entry.expireAfter(nowMillis, ttl)
 
-		// data are expired, check if entry is being reloaded
-		entry.mu.Lock()
+	entry.mu.Unlock()
 
-		// check if entry was loaded by other routine during waiting for lock
-		if nowMillis < entry.nextReload.Load() {
-			entry.mu.Unlock()
+	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
 
-			return entry.get()
-		}
+	if c.metrics != nil && !exists {
+		c.metrics.ItemsCount.Inc()
+	}
+
+	return
+}
 
-		// reload entry
-		loadedValue, err := c.loadOneFunc(ID)
-		ttl := entry.set(loadedValue, err, nowMillis, &c.timeouts, false)
+// ReplaceAll atomically replaces content of the cache by given entries (e.g.
+// mirror of a small table refreshed as a whole): entries not cached yet are
+// inserted, cached ones are replaced and cached entries missing in the map are
+// removed (pinned ones as well, `EventEvict` is emitted for them). Values are
+// stored the same way as by `Set` (nil value is stored as not found entry), but
+// `WriteThrough` is not called. Readers never observe partially replaced content,
+// entries are prepared before the cache is locked once for the swap of both data
+// and secondary indexes.
+func (c *Cache[K, T]) ReplaceAll(entries map[K]*T) {
+	nowMillis := c.nowMillis()
+
+	type replacement struct {
+		entry *cachedEntry[T]
+		value *T
+		ttl   time.Duration
+	}
+	replacements := make(map[K]replacement, len(entries))
+	for ID, value := range entries {
+		ID = c.normalizeKey(ID)
 
-		entry.mu.Unlock()
+		var err error
+		if value == nil {
+			err = ErrNotFound
+		}
 
-		// update watchers
-		c.setEntryWatchers(ID, ttl, entry, nowMillis)
+		// indexes are updated together with the data below
+		opts := c.entryOptions(ID)
+		opts.stored = nil
 
-		if c.metrics != nil {
-			c.metrics.LazyLoadCount.Inc()
-			if err != nil && !errors.Is(err, ErrNotFound) {
-				c.metrics.ErrorLoadCount.Inc()
-			}
+		entry := c.newEntry()
+		ttl := entry.set(value, err, nowMillis, opts, true)
+		// entry which should not be cached is removed
+		if ttl == 0 {
+			c.releaseEntry(entry)
+			continue
 		}
+		entry.expireAfter(nowMillis, ttl)
+		c.markAccess(entry, nowMillis)
 
-		return entry.get()
+		// the last one of keys normalized to the same key is used
+		if previous, exists := replacements[ID]; exists {
+			c.releaseEntry(previous.entry)
+		}
+		replacements[ID] = replacement{entry: entry, value: value, ttl: ttl}
 	}
 
-	// not found in cache
-	entry = &cachedEntry[T]{}
-	entry.mu.Lock()
+	var removed []K
+	inserted := 0
 
 	c.mu.Lock()
-	c.data[ID] = entry
+	if c.grace != nil {
+		c.grace.clear()
+	}
+	for ID, entry := range c.data {
+		if _, replaced := replacements[ID]; !replaced {
+			c.deleteEntry(ID, entry)
+			removed = append(removed, ID)
+		}
+	}
+	for ID, r := range replacements {
+		if oldEntry, exists := c.data[ID]; exists {
+			// replaced entry being reloaded does not update indexes anymore
+			oldEntry.removed.Store(true)
+			c.releaseEntry(oldEntry)
+		} else {
+			inserted++
+		}
+		c.storeEntry(ID, r.entry)
+		c.reindex(ID, r.value)
+	}
 	c.mu.Unlock()
 
-	loadedValue, err := c.loadOneFunc(ID)
-	ttl := entry.set(loadedValue, err, nowMillis, &c.timeouts, true)
-
-	entry.mu.Unlock()
-
-	// do not store into cache when TTL is 0
-	if ttl == 0 {
-		c.mu.Lock()
-		delete(c.data, ID)
-		c.mu.Unlock()
-
-		return entry.value.Load()
+	for _, ID := range removed {
+		c.ttlWatcher.Drop(ID)
+		c.reloadWatcher.Drop(ID)
+		c.emitEvent(EventEvict, ID)
 	}
 
-	// update watchers
-	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	for ID, r := range replacements {
+		c.setEntryWatchers(ID, r.ttl, r.entry, nowMillis)
+		// reference of the preparation (the map holds its own one)
+		c.releaseEntry(r.entry)
+	}
 
 	if c.metrics != nil {
-		c.metrics.LazyLoadCount.Inc()
-		if err != nil && !errors.Is(err, ErrNotFound) {
-			c.metrics.ErrorLoadCount.Inc()
-		}
+		c.metrics.ItemsCount.Add(float64(inserted - len(removed)))
 	}
 
-	return entry.get()
+	c.enforceCapacity()
 }
 
-func (c *Cache[K, T]) Remove(ID K) {
-	c.mu.Lock()
+// Peek returns cached value of the entry without loading it and without marking
+// it as accessed (expired values are returned as well). The second return value
+// is false when the entry is not cached or it has no value (not found).
+func (c *Cache[K, T]) Peek(ID K) (*T, bool) {
+	ID = c.normalizeKey(ID)
 
-	_, exists := c.data[ID]
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.data[ID]
 	if !exists {
-		c.mu.Unlock()
-		return
+		return nil, false
 	}
-	delete(c.data, ID)
 
-	c.mu.Unlock()
+	value := c.output(entry.value.Load())
 
-	// remove watchers
-	c.ttlWatcher.Drop(ID)
-	c.reloadWatcher.Drop(ID)
+	return value, value != nil
+}
 
-	if c.metrics != nil {
-		c.metrics.ItemsCount.Dec()
+// GetStale returns cached value of the entry without ever loading it synchronously.
+// Expired values are returned as well and their reload is started in the
+// background. Not cached entries are not loaded. The second return value is false
+// when the entry is not cached or it has no value (not found or being loaded).
+func (c *Cache[K, T]) GetStale(ID K) (*T, bool) {
+	ID = c.normalizeKey(ID)
+
+	entry, exists := c.acquireEntry(ID)
+
+	c.countRead()
+
+	if !exists {
+		return nil, false
 	}
+
+	nowMillis := c.nowMillis()
+	c.markAccess(entry, nowMillis)
+	value := c.output(entry.get())
+
+	// reload expired entry unless it is being loaded by other routine
+	if nowMillis >= entry.nextReload.Load() && entry.mu.TryLock() {
+		// check if entry was not reloaded by other routine in the meantime
+		if nowMillis >= entry.nextReload.Load() {
+			// reference of the entry is released after the reload
+			c.runInBackground(func() {
+				defer c.releaseEntry(entry)
+				_, _ = c.reloadEntry(c.ctx, ID, entry, nowMillis)
+			})
+
+			return value, value != nil
+		}
+
+		entry.mu.Unlock()
+	}
+
+	c.releaseEntry(entry)
+
+	return value, value != nil
 }
 
-func (c *Cache[K, T]) Invalidate(ID K) {
+// IsCached returns true when the entry is stored in cache (including not found
+// entries and entries being loaded).
+func (c *Cache[K, T]) IsCached(ID K) bool {
+	ID = c.normalizeKey(ID)
+
 	c.mu.RLock()
-	entry, exists := c.data[ID]
+	_, exists := c.data[ID]
 	c.mu.RUnlock()
 
-	if !exists {
-		return
-	}
-
-	entry.nextReload.Store(0)
+	return exists
+}
 
-	if c.automaticReloadType != AutomaticReloadDisabled {
-		c.reloadWatcher.Push(ID, 0)
+// Collectors returns prometheus collectors of the cache metrics (nil when metrics
+// are disabled), e.g. to register them into other registry.
+func (c *Cache[K, T]) Collectors() []prometheus.Collector {
+	if c.metrics == nil {
+		return nil
 	}
+
+	return c.metrics.Collectors()
 }
 
-// func (c *Cache[K, T]) IsCached(ID K) bool {
-// 	return false
-// }
+// Len returns number of entries stored in cache.
+func (c *Cache[K, T]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.data)
+}
 
 func (c *Cache[K, T]) startPreloading(preloadChan <-chan LoadedEntry[K, T]) {
+	defer close(c.preloaded)
+	if c.preloadResults != nil {
+		defer close(c.preloadResults)
+	}
+
 	// read data from reload channel and store it to cache
 	for {
 		select {
@@ -238,7 +1346,30 @@ func (c *Cache[K, T]) startPreloading(preloadChan <-chan LoadedEntry[K, T]) {
 				return
 			}
 
-			c.addLoadedEntry(loadedEntry, time.Now().UnixMilli())
+			stored := c.addLoadedEntry(loadedEntry, c.nowMillis(), 0, true)
+
+			// counted here (not in addLoadedEntry), because addLoadedEntry
+			// stores also unrequested entries of batch loads
+			c.stats.preloads.Add(1)
+			if c.metrics != nil {
+				c.metrics.PreloadCount.Inc()
+			}
+
+			if c.preloadResults == nil {
+				continue
+			}
+			result := PreloadResult[K, T]{Entry: loadedEntry, Status: PreloadStored}
+			if loadedEntry.Err != nil && c.errorClass(loadedEntry.Err) != ErrorClassNotFound {
+				result.Status = PreloadFailed
+				result.Err = loadedEntry.Err
+			} else if !stored {
+				result.Status = PreloadSkipped
+			}
+			select {
+			case c.preloadResults <- result:
+			case <-c.ctx.Done():
+				return
+			}
 
 		case <-c.ctx.Done():
 			return
@@ -246,125 +1377,329 @@ func (c *Cache[K, T]) startPreloading(preloadChan <-chan LoadedEntry[K, T]) {
 	}
 }
 
-// addLoadedEntry adds already loaded entry to cache (if it makes sense)
-func (c *Cache[K, T]) addLoadedEntry(loadedEntry LoadedEntry[K, T], nowMillis int64) {
-	entry := &cachedEntry[T]{}
-	ttl := entry.set(loadedEntry.Value, loadedEntry.Err, nowMillis, &c.timeouts, true)
+// waitForPreload waits until preloading finishes (at most `PreloadWait`) and
+// returns true when it waited
+func (c *Cache[K, T]) waitForPreload() bool {
+	if c.preloaded == nil || c.preloadWait == 0 {
+		return false
+	}
+
+	select {
+	case <-c.preloaded:
+		return false
+	default:
+	}
+
+	timer := time.NewTimer(c.preloadWait)
+	defer timer.Stop()
+
+	select {
+	case <-c.preloaded:
+	case <-timer.C:
+	}
+
+	return true
+}
+
+// addLoadedEntry adds already loaded entry to cache (if it makes sense). TTL of
+// the entry is limited by maxTTL (when it is positive). Cached entry with the same
+// ID is replaced unless keepExisting is set. Returns true when the entry was stored.
+func (c *Cache[K, T]) addLoadedEntry(loadedEntry LoadedEntry[K, T], nowMillis int64, maxTTL time.Duration, keepExisting bool) bool {
+	entry := c.newEntry()
+	defer c.releaseEntry(entry)
+
+	ID := c.normalizeKey(loadedEntry.ID)
 
-	ID := loadedEntry.ID
+	ttl := entry.set(loadedEntry.Value, loadedEntry.Err, nowMillis, c.entryOptions(ID), true)
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	entry.expireAfter(nowMillis, ttl)
+	c.markAccess(entry, nowMillis)
 
 	c.mu.Lock()
 
-	_, exists := c.data[ID]
+	oldEntry, exists := c.data[ID]
+	if exists && keepExisting {
+		c.mu.Unlock()
+		return false
+	}
 	// do not override existing entry in case of error (except NotFound)
-	if exists && loadedEntry.Err != nil && !errors.Is(loadedEntry.Err, ErrNotFound) {
+	if exists && loadedEntry.Err != nil && c.errorClass(loadedEntry.Err) != ErrorClassNotFound {
 		c.mu.Unlock()
 
+		c.stats.errorLoads.Add(1)
 		if c.metrics != nil {
 			c.metrics.ErrorLoadCount.Inc()
 		}
 
-		return
+		return false
 	}
 
-	c.data[ID] = entry
+	if exists {
+		c.deleteEntry(ID, oldEntry)
+		// removal of the old entry dropped the key from indexes
+		if c.indexes.Load() != nil {
+			c.reindex(ID, c.decompress(entry.value.Load()))
+		}
+	}
+	c.storeEntry(ID, entry)
 
 	c.mu.Unlock()
 
+	if !exists {
+		c.enforceCapacity()
+	}
+
 	// update TTL watcher
 	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
+	c.emitEvent(EventLoad, ID)
 
 	if c.metrics != nil {
 		c.metrics.ItemsCount.Inc()
 		// c.memSizeValue.Add(entry.memSize())
 	}
+
+	return true
 }
 
-func (c *Cache[K, T]) startTTLWatcher() {
-	ch := c.ttlWatcher.PopperWithResolution(c.ctx, tllWatcherInterval)
+func (c *Cache[K, T]) startTTLWatcher(w *watcher[K]) {
+	ch := w.popper(c.ctx, tllWatcherInterval)
 
 	// read data from TTL watcher and remove expired entries from cache
 	// channel is closed when context is done
 	for {
-		item, more := <-ch
+		ID, more := <-ch
 		if !more {
 			break
 		}
 
-		ID := item.ID()
+		c.expireEntry(ID)
+	}
+}
 
-		c.mu.Lock()
+// expireEntry removes entry whose TTL passed from cache
+func (c *Cache[K, T]) expireEntry(ID K) {
+	// TTL of pinned entry is scheduled again when it is unpinned
+	if c.pinned.has(ID) {
+		return
+	}
 
-		_, exists := c.data[ID]
-		if !exists {
-			c.mu.Unlock()
-			continue
-		}
+	// cached entries are kept when they cannot be loaded anymore
+	if c.fatalErr.Load() != nil {
+		return
+	}
 
-		delete(c.data, ID)
+	c.mu.Lock()
 
+	entry, exists := c.data[ID]
+	if !exists {
 		c.mu.Unlock()
+		return
+	}
 
-		// remove from TTL watcher
-		c.reloadWatcher.Drop(ID)
+	c.keepGraceEntry(ID, entry)
+	c.deleteEntry(ID, entry)
 
-		if c.metrics != nil {
-			c.metrics.ItemsCount.Dec()
-		}
+	c.mu.Unlock()
+
+	// remove from reload watcher
+	c.reloadWatcher.Drop(ID)
+	c.emitEvent(EventEvict, ID)
+
+	if c.metrics != nil {
+		c.metrics.ItemsCount.Dec()
 	}
 }
 
-func (c *Cache[K, T]) startReloadWatcher() {
-	ch := c.reloadWatcher.PopperWithResolution(c.ctx, reloadWatcherInterval)
+func (c *Cache[K, T]) startReloadWatcher(w *watcher[K]) {
+	ch := w.popper(c.ctx, reloadWatcherInterval)
+
+	batching := c.loadMultipleFunc != nil && c.reloadBatchWindow > 0
 
 	// read data from reload watcher and reload expired entries
 	// channel is closed when context is done
 	for {
-		item, more := <-ch
+		ID, more := <-ch
 		if !more {
 			break
 		}
 
-		id := item.ID()
+		if !batching {
+			c.automaticReload(ID)
+			continue
+		}
+
+		// collect entries which should be reloaded within batch window
+		IDs := []K{ID}
+		timer := time.NewTimer(c.reloadBatchWindow)
+	collect:
+		for {
+			select {
+			case ID, more = <-ch:
+				if !more {
+					break collect
+				}
+				IDs = append(IDs, ID)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		c.automaticReloadBatch(IDs)
 
-		c.mu.Lock()
-		entry, exists := c.data[id]
-		c.mu.Unlock()
+		if !more {
+			break
+		}
+	}
+}
+
+// automaticReload reloads entry triggered by reload watcher
+func (c *Cache[K, T]) automaticReload(ID K) {
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return
+	}
+	defer c.releaseEntry(entry)
+
+	// prevent unnecessary reloads of entries that are not used (except pinned ones)
+	// if entry is later accessed, it is lazy-reloaded
+	if c.automaticReloadType == AutomaticReloadAccessedEntries && !entry.accessed.Load() && !c.pinned.has(ID) {
+		return
+	}
+
+	loads := entry.loads.Load()
+	entry.mu.Lock()
+
+	// entry was reloaded by other routine during waiting for lock (watchers were
+	// rescheduled by that load)
+	if entry.loads.Load() != loads {
+		entry.mu.Unlock()
+
+		c.stats.dedupedLoads.Add(1)
+		if c.metrics != nil {
+			c.metrics.DedupedLoadCount.Inc()
+		}
+
+		return
+	}
+
+	// checked after deduplication, so probe of half-open circuit is taken only
+	// when the reload really follows (and is reported)
+	nowMillis := c.nowMillis()
+	if !c.reloadAllowed(ID, nowMillis) {
+		entry.mu.Unlock()
+		return
+	}
+
+	loadedValue, err := c.loadOne(c.ctx, ID)
+	c.reportLoad(ID, err, nowMillis)
+	c.setAutomaticallyLoaded(ID, entry, loadedValue, err, nowMillis)
+}
+
+// automaticReloadBatch reloads entries triggered by reload watcher in one batch
+// by `LoadBatchFunc`
+func (c *Cache[K, T]) automaticReloadBatch(IDs []K) {
+	entries := make(map[K]*cachedEntry[T], len(IDs))
+	loads := make(map[K]uint64, len(IDs))
+	candidates := make([]K, 0, len(IDs))
 
+	for _, ID := range IDs {
+		if _, duplicate := entries[ID]; duplicate {
+			continue
+		}
+
+		entry, exists := c.acquireEntry(ID)
 		if !exists {
 			continue
 		}
 
-		// prevent unnecessary reloads of entries that are not used
-		// if entry is later accessed, it is lazy-reloaded
-		if c.automaticReloadType == AutomaticReloadAccessedEntries && !entry.accessed.Load() {
+		// prevent unnecessary reloads of entries that are not used (except pinned ones)
+		if c.automaticReloadType == AutomaticReloadAccessedEntries && !entry.accessed.Load() && !c.pinned.has(ID) {
+			c.releaseEntry(entry)
 			continue
 		}
 
+		entries[ID] = entry
+		loads[ID] = entry.loads.Load()
+		candidates = append(candidates, ID)
+	}
+
+	// entries are locked during load (as single entry reload does), only this
+	// routine locks multiple entries, so it cannot deadlock
+	toLoad := make([]K, 0, len(candidates))
+	nowMillis := c.nowMillis()
+	for _, ID := range candidates {
+		entry := entries[ID]
 		entry.mu.Lock()
 
-		nowMillis := time.Now().UnixMilli()
-		loadedValue, err := c.loadOneFunc(id)
-		accessed := entry.accessed.Load()
-		ttl := entry.set(loadedValue, err, nowMillis, &c.timeouts, false)
-		if !accessed {
-			ttl = -1 // do not prolong TTL for not accessed entries
+		// entry was reloaded by other routine during waiting for lock
+		if entry.loads.Load() != loads[ID] {
+			entry.mu.Unlock()
+			c.releaseEntry(entry)
+
+			c.stats.dedupedLoads.Add(1)
+			if c.metrics != nil {
+				c.metrics.DedupedLoadCount.Inc()
+			}
+			continue
 		}
 
-		entry.mu.Unlock()
+		// checked after deduplication (as by `automaticReload`)
+		if !c.reloadAllowed(ID, nowMillis) {
+			entry.mu.Unlock()
+			c.releaseEntry(entry)
+			continue
+		}
 
-		// update watchers
-		c.setEntryWatchers(id, ttl, entry, nowMillis)
+		toLoad = append(toLoad, ID)
+	}
 
-		if c.metrics != nil {
-			c.metrics.AutomaticLoadCount.Inc()
-			if err != nil && !errors.Is(err, ErrNotFound) {
-				c.metrics.ErrorLoadCount.Inc()
-			}
+	if len(toLoad) == 0 {
+		return
+	}
+
+	loaded, unrequested := c.collectLoadedEntries(toLoad, c.loadMultiple(toLoad))
+
+	for _, ID := range toLoad {
+		entry := entries[ID]
+		loadedEntry := loaded[ID]
+		c.reportLoad(ID, loadedEntry.Err, nowMillis)
+		c.setAutomaticallyLoaded(ID, entry, loadedEntry.Value, loadedEntry.Err, nowMillis)
+		c.releaseEntry(entry)
+	}
+
+	if c.cacheUnrequested {
+		for _, loadedEntry := range unrequested {
+			c.addLoadedEntry(loadedEntry, nowMillis, 0, false)
 		}
 	}
 }
 
+// setAutomaticallyLoaded sets automatically reloaded data into the entry. Entry
+// mutex has to be locked (it is unlocked by this function) and reference of the
+// entry held by the caller.
+func (c *Cache[K, T]) setAutomaticallyLoaded(ID K, entry *cachedEntry[T], loadedValue *T, err error, nowMillis int64) {
+	accessed := entry.accessed.Load()
+	ttl := entry.set(loadedValue, err, nowMillis, c.reloadOptions(ID), false)
+	if !accessed && c.automaticReloadType != AutomaticReloadAllEntriesKeepAlive {
+		ttl = -1 // do not prolong TTL for not accessed entries
+	}
+	entry.expireAfter(nowMillis, ttl)
+
+	entry.mu.Unlock()
+
+	// update watchers
+	c.setEntryWatchers(ID, ttl, entry, nowMillis)
+	c.reportHealth(ID, entry)
+	c.distributeLoadedEntry(ID, loadedValue, err, nowMillis)
+	c.emitEvent(EventReload, ID)
+
+	c.countLoad(true, err)
+}
+
 func (c *Cache[K, T]) setEntryWatchers(
 	entryID K,
 	ttl time.Duration,
@@ -372,6 +1707,7 @@ func (c *Cache[K, T]) setEntryWatchers(
 	nowMillis int64,
 ) {
 	if ttl >= 0 {
+		entry.expireAfter(nowMillis, ttl)
 		c.ttlWatcher.Push(entryID, ttl)
 	}
 
@@ -396,12 +1732,36 @@ func (c *Cache[K, T]) startMemoryMeassurement(interval time.Duration) {
 			return
 
 		case <-timer.C:
-			c.updateMemsize()
+			_ = c.updateMemsize()
 		}
 	}
 }
 
-func (c *Cache[K, T]) updateMemsize() {
+// MemSizeOf returns memory size of cached value of the entry (measured the same
+// way as size of the whole cache). Not found entries have size 0. The second
+// return value is false when the entry is not cached.
+func (c *Cache[K, T]) MemSizeOf(ID K) (uint64, bool) {
+	ID = c.normalizeKey(ID)
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return 0, false
+	}
+	defer c.releaseEntry(entry)
+
+	return entry.memSize(), true
+}
+
+// RefreshMemSize measures memory size of the cache immediately (the same way as
+// periodic measurement, see `Timeouts.MemsizeUpdate`) and returns it. It can be
+// used even when periodic measurement is disabled.
+func (c *Cache[K, T]) RefreshMemSize() uint64 {
+	return c.updateMemsize()
+}
+
+// updateMemsize measures memory size of the cache and returns it (previous size
+// is returned when measurement fails)
+func (c *Cache[K, T]) updateMemsize() (size uint64) {
 	// handle potential panic (calculating size should not affect running app)
 	defer func() {
 		err := recover()
@@ -409,28 +1769,42 @@ func (c *Cache[K, T]) updateMemsize() {
 			c.log.Warn().
 				Interface("err", err).
 				Msg("panic occurred during cache size calculation")
+			size = c.memSizeValue.Load()
 		}
 	}()
 
-	// get list of entries using read lock
+	// get list of values using read lock (entries can be reused after unlock),
+	// accessed entries are counted meanwhile
+	var accessed, unaccessed uint64
 	c.mu.RLock()
-	entries := make([]*cachedEntry[T], 0, len(c.data))
-	for id := range c.data {
-		entries = append(entries, c.data[id])
-	}
-	c.mu.RUnlock()
-
-	// get memory size of each entry
-	var size uint64
-	for _, entry := range entries {
+	values := make([]*T, 0, len(c.data))
+	for _, entry := range c.data {
 		value := entry.value.Load()
-		if value == nil {
-			continue
+		if value != nil {
+			values = append(values, value)
 		}
+		if entry.accessed.Load() {
+			accessed++
+		} else {
+			unaccessed++
+		}
+	}
+	c.mu.RUnlock()
 
-		size += memsize.Entry(value)
+	c.stats.accessedEntries.Store(accessed)
+	c.stats.unaccessedEntries.Store(unaccessed)
+	if c.metrics != nil {
+		c.metrics.AccessedItemsCount.Set(float64(accessed))
+		c.metrics.UnaccessedItemsCount.Set(float64(unaccessed))
 	}
 
+	// get memory size of each value
+	size = c.measureValues(values)
+
 	c.memSizeValue.Store(size)
-	c.metrics.MemoryUsage.Set(float64(size))
+	if c.metrics != nil {
+		c.metrics.MemoryUsage.Set(float64(size))
+	}
+
+	return size
 }
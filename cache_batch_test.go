@@ -0,0 +1,293 @@
+package lazy
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func newBatchTestCache(
+	t *testing.T,
+	cacheUnrequested bool,
+	loadMultipleFunc LoadBatchFunc[int, string],
+) *Cache[int, string] {
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			t.Error("LoadOneFunc should not be called")
+			return nil, ErrNotFound
+		},
+		LoadBatchFunc:           loadMultipleFunc,
+		CacheUnrequestedEntries: cacheUnrequested,
+		Timeouts:                cacheTestTimeouts,
+		AutomaticReload:         AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	return c
+}
+
+func testCacheBatchMissingEntries(t *testing.T) {
+	t.Parallel()
+
+	loads := 0
+	c := newBatchTestCache(t, false, func(IDs []int) (entries []LoadedEntry[int, string]) {
+		loads++
+		// only first entry is returned
+		return []LoadedEntry[int, string]{
+			{ID: IDs[0], Value: test_utils.StringPointer("value")},
+		}
+	})
+
+	values := c.GetMultiple([]int{1, 2, 3})
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value")}, values)
+	assert.Equal(t, 1, loads)
+
+	// missing entries are cached as not found
+	assert.Equal(t, 3, len(c.data))
+	assert.Nil(t, c.data[2].value.Load())
+	assert.Nil(t, c.data[3].value.Load())
+
+	values = c.GetMultiple([]int{1, 2, 3})
+	assert.Equal(t, 1, len(values))
+	assert.Equal(t, 1, loads)
+}
+
+func testCacheBatchUnrequestedEntries(t *testing.T) {
+	t.Parallel()
+
+	loadMultiple := func(IDs []int) (entries []LoadedEntry[int, string]) {
+		return []LoadedEntry[int, string]{
+			{ID: 1, Value: test_utils.StringPointer("value1")},
+			{ID: 100, Value: test_utils.StringPointer("value100")},
+		}
+	}
+
+	// ignored by default
+	c := newBatchTestCache(t, false, loadMultiple)
+	values := c.GetMultiple([]int{1})
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value1")}, values)
+	assert.Equal(t, 1, len(c.data))
+	assert.Nil(t, c.data[100])
+
+	// cached when enabled
+	c = newBatchTestCache(t, true, loadMultiple)
+	values = c.GetMultiple([]int{1})
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value1")}, values)
+	assert.Equal(t, 2, len(c.data))
+	assert.Equal(t, "value100", *c.Get(100))
+}
+
+func testCacheBatchDuplicateEntries(t *testing.T) {
+	t.Parallel()
+
+	var requested []int
+	c := newBatchTestCache(t, false, func(IDs []int) (entries []LoadedEntry[int, string]) {
+		requested = IDs
+		return []LoadedEntry[int, string]{
+			{ID: 1, Value: test_utils.StringPointer("first")},
+			{ID: 1, Value: test_utils.StringPointer("second")},
+			{ID: 2, Err: ErrNotFound},
+			{ID: 2, Value: test_utils.StringPointer("second")},
+		}
+	})
+
+	// duplicate requested IDs are loaded once
+	values := c.GetMultiple([]int{1, 2, 1})
+	assert.Equal(t, []int{1, 2}, requested)
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("first")}, values)
+	assert.Nil(t, c.data[2].value.Load())
+}
+
+func testCacheBatchAutomaticReload(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var batches [][]int
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			t.Error("LoadOneFunc should not be called")
+			return nil, ErrNotFound
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			mu.Lock()
+			batches = append(batches, IDs)
+			mu.Unlock()
+
+			for _, ID := range IDs {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("reloaded")})
+			}
+			return
+		},
+		Timeouts: Timeouts{
+			TTL:            7 * time.Second,
+			NotFoundTTL:    5 * time.Second,
+			ErrorTTL:       1 * time.Second,
+			ReloadInterval: 1 * time.Second,
+		},
+		AutomaticReload:            AutomaticReloadAllEntries,
+		AutomaticReloadBatchWindow: 200 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	// reloads of all entries are due at the same time
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Set(i, test_utils.StringPointer("value")))
+	}
+
+	assert.Eventually(t, func() bool {
+		for i := 0; i < 10; i++ {
+			value, _ := c.Peek(i)
+			if value == nil || *value != "reloaded" {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	reloaded := 0
+	for _, batch := range batches {
+		assert.Greater(t, len(batch), 1)
+		reloaded += len(batch)
+	}
+	assert.GreaterOrEqual(t, reloaded, 10)
+	assert.Less(t, len(batches), 10)
+}
+
+func testCacheBatchOverlappingLoads(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	loads := map[int]int{}
+
+	c := newBatchTestCache(t, false, func(IDs []int) (entries []LoadedEntry[int, string]) {
+		mu.Lock()
+		for _, ID := range IDs {
+			loads[ID]++
+		}
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		for _, ID := range IDs {
+			entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value_" + strconv.Itoa(ID))})
+		}
+		return
+	})
+
+	// two calls with overlapping cold keys
+	requests := [][]int{{0, 1, 2, 3}, {2, 3, 4, 5}}
+	results := make([]map[int]*string, len(requests))
+
+	wg := sync.WaitGroup{}
+	for i, IDs := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.GetMultiple(IDs)
+		}()
+	}
+	wg.Wait()
+
+	for i, IDs := range requests {
+		assert.Len(t, results[i], len(IDs))
+		for _, ID := range IDs {
+			assert.Equal(t, "value_"+strconv.Itoa(ID), *results[i][ID])
+		}
+	}
+
+	// each cold key is loaded only once
+	for ID := 0; ID <= 5; ID++ {
+		assert.Equal(t, 1, loads[ID], ID)
+	}
+}
+
+func testCacheBatchMaxSize(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]int
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			t.Error("LoadOneFunc should not be called")
+			return nil, ErrNotFound
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			batches = append(batches, IDs)
+			for _, ID := range IDs {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer(strconv.Itoa(ID))})
+			}
+			return
+		},
+		MaxBatchSize:    100,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	IDs := make([]int, 350)
+	for i := range IDs {
+		IDs[i] = i
+	}
+
+	values := c.GetMultiple(IDs)
+	assert.Equal(t, 350, len(values))
+	for _, ID := range IDs {
+		assert.Equal(t, strconv.Itoa(ID), *values[ID])
+	}
+
+	// IDs are split into chunks of at most MaxBatchSize in the requested order
+	assert.Equal(t, 4, len(batches))
+	for i, batch := range batches {
+		assert.Equal(t, IDs[i*100:min((i+1)*100, len(IDs))], batch)
+	}
+}
+
+func testCacheBatchDeprecatedLoader(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			t.Error("LoadOneFunc should not be called")
+			return nil, ErrNotFound
+		},
+		LoadMultipleFunc: func(IDs []int) (entries []LoadedEntry[int, *string]) {
+			value := test_utils.StringPointer("value1")
+			return []LoadedEntry[int, *string]{
+				{ID: 1, Value: &value},
+				{ID: 2, Value: new(*string)},
+				{ID: 3},
+			}
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// values of original loader are unwrapped, nil ones are not found
+	values := c.GetMultiple([]int{1, 2, 3})
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value1")}, values)
+	assert.Equal(t, 3, len(c.data))
+	assert.Nil(t, c.data[2].value.Load())
+	assert.Nil(t, c.data[3].value.Load())
+}
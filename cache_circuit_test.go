@@ -0,0 +1,296 @@
+package lazy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func testCacheCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	for _, perKey := range []bool{false, true} {
+		var loads atomic.Int64
+		var failing atomic.Bool
+		failing.Store(true)
+
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				loads.Add(1)
+				if failing.Load() {
+					return nil, errors.New("load failed")
+				}
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts: Timeouts{
+				TTL:            7 * time.Second,
+				NotFoundTTL:    5 * time.Second,
+				ReloadInterval: 3 * time.Second,
+			},
+			AutomaticReload: AutomaticReloadDisabled,
+			CircuitBreaker: &CircuitBreaker{
+				FailureThreshold: 3,
+				OpenDuration:     200 * time.Millisecond,
+				PerKey:           perKey,
+			},
+		})
+		assert.Nil(t, err)
+
+		// sustained failures open the circuit
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, c.Get(0))
+		}
+		assert.Equal(t, int64(3), loads.Load())
+
+		assert.Nil(t, c.Get(0))
+		assert.Equal(t, int64(3), loads.Load())
+		assert.Equal(t, uint64(1), c.Stats().CircuitOpenSkips)
+
+		// other keys are affected only by cache-wide circuit
+		assert.Nil(t, c.Get(1))
+		if perKey {
+			assert.Equal(t, int64(4), loads.Load())
+		} else {
+			assert.Equal(t, int64(3), loads.Load())
+		}
+
+		// successful probe closes the circuit
+		failing.Store(false)
+		time.Sleep(250 * time.Millisecond)
+		loadsBefore := loads.Load()
+		assert.Equal(t, "value", *c.Get(0))
+		assert.Equal(t, loadsBefore+1, loads.Load())
+		assert.Equal(t, "value", *c.Get(2))
+		assert.Equal(t, loadsBefore+2, loads.Load())
+	}
+}
+
+func testCacheCircuitBreakerAutomaticReload(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	var failing atomic.Bool
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			if failing.Load() {
+				return nil, errors.New("load failed")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: Timeouts{
+			TTL:            7 * time.Second,
+			NotFoundTTL:    5 * time.Second,
+			ReloadInterval: 300 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadAllEntries,
+		CircuitBreaker: &CircuitBreaker{
+			FailureThreshold: 2,
+			OpenDuration:     1500 * time.Millisecond,
+		},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value", *c.Get(0))
+
+	// open the circuit
+	failing.Store(true)
+	assert.Nil(t, c.Get(1))
+	assert.Nil(t, c.Get(2))
+	loadsWhenOpened := loads.Load()
+
+	// no reloads while the circuit is open
+	time.Sleep(time.Second)
+	assert.Equal(t, loadsWhenOpened, loads.Load())
+	assert.Greater(t, c.Stats().CircuitSkippedReloads, uint64(0))
+	assert.Equal(t, "value", *c.Get(0))
+
+	// reload probes half-open circuit
+	failing.Store(false)
+	assert.Eventually(t, func() bool {
+		return loads.Load() > loadsWhenOpened
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func testCacheCircuitBreakerGetMultiple(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	failing.Store(true)
+	loadOne := func(ID int) (entry *string, err error) {
+		if failing.Load() {
+			return nil, errors.New("load failed")
+		}
+		return test_utils.StringPointer("value"), nil
+	}
+	// failed entry is retried on the next read
+	timeouts := cacheTestTimeouts
+	timeouts.ErrorRetryInterval = time.Millisecond
+
+	c, err := NewCache(Params[int, string]{
+		Context:     context.Background(),
+		Log:         test_utils.Logger(),
+		Name:        "test_cache1",
+		LoadOneFunc: loadOne,
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			for _, ID := range IDs {
+				value, err := loadOne(ID)
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: value, Err: err})
+			}
+			return entries
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		CircuitBreaker: &CircuitBreaker{
+			FailureThreshold: 1,
+			OpenDuration:     100 * time.Millisecond,
+		},
+	})
+	assert.Nil(t, err)
+
+	// failure opens the circuit
+	assert.Nil(t, c.Get(0))
+	failing.Store(false)
+	time.Sleep(150 * time.Millisecond)
+
+	// entry locked by other routine is waited for, probe of half-open circuit is
+	// taken only by the load which follows
+	c.mu.RLock()
+	entry := c.data[0]
+	c.mu.RUnlock()
+	entry.mu.Lock()
+	done := make(chan map[int]*string)
+	go func() {
+		done <- c.GetMultiple([]int{0})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	entry.mu.Unlock()
+	assert.Equal(t, map[int]*string{0: test_utils.StringPointer("value")}, <-done)
+
+	// successful probe closed the circuit
+	assert.Equal(t, "value", *c.Get(1))
+}
+
+func testCacheCircuitBreakerDedupedReload(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	failing.Store(true)
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if failing.Load() {
+				return nil, errors.New("load failed")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		CircuitBreaker: &CircuitBreaker{
+			FailureThreshold: 1,
+			OpenDuration:     100 * time.Millisecond,
+		},
+	})
+	assert.Nil(t, err)
+
+	// failure opens the circuit
+	assert.Nil(t, c.Get(0))
+	failing.Store(false)
+	time.Sleep(150 * time.Millisecond)
+
+	// automatic reload of entry reloaded by other routine meanwhile is skipped
+	// without taking probe of half-open circuit
+	c.mu.RLock()
+	entry := c.data[0]
+	c.mu.RUnlock()
+	entry.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.automaticReload(0)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	entry.loads.Add(1)
+	entry.mu.Unlock()
+	<-done
+	assert.Equal(t, uint64(1), c.Stats().DedupedLoads)
+
+	// probe is still available and closes the circuit
+	assert.Equal(t, "value", *c.Get(1))
+}
+
+func testCacheCircuitBreakerDedupedBatchReload(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	failing.Store(true)
+	batchLoads := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if failing.Load() {
+				return nil, errors.New("load failed")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			batchLoads.Add(1)
+			for _, ID := range IDs {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+			}
+			return
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		CircuitBreaker: &CircuitBreaker{
+			FailureThreshold: 1,
+			OpenDuration:     100 * time.Millisecond,
+		},
+	})
+	assert.Nil(t, err)
+
+	// failure opens the circuit
+	assert.Nil(t, c.Get(0))
+	failing.Store(false)
+	time.Sleep(150 * time.Millisecond)
+
+	// batch reload of entry reloaded by other routine meanwhile is skipped
+	// without taking probe of half-open circuit
+	c.mu.RLock()
+	entry := c.data[0]
+	c.mu.RUnlock()
+	entry.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.automaticReloadBatch([]int{0})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	entry.loads.Add(1)
+	entry.mu.Unlock()
+	<-done
+	assert.Equal(t, uint64(1), c.Stats().DedupedLoads)
+	assert.Equal(t, int64(0), batchLoads.Load())
+
+	// probe is still available and closes the circuit
+	assert.Equal(t, "value", *c.Get(1))
+}
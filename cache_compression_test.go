@@ -0,0 +1,93 @@
+package lazy
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+// blob is value compressed by flate
+type blob struct {
+	data       []byte
+	compressed bool
+}
+
+func (b *blob) Compress() *blob {
+	buf := &bytes.Buffer{}
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	_, _ = w.Write(b.data)
+	_ = w.Close()
+
+	return &blob{data: buf.Bytes(), compressed: true}
+}
+
+func (b *blob) Decompress() *blob {
+	data, _ := io.ReadAll(flate.NewReader(bytes.NewReader(b.data)))
+
+	return &blob{data: data}
+}
+
+func (b *blob) MemSize() uint64 {
+	return uint64(len(b.data))
+}
+
+func testCacheCompression(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(strings.Repeat("compressible value ", 10000))
+
+	newCache := func(compress bool) *Cache[int, blob] {
+		c, err := NewCache(Params[int, blob]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *blob, err error) {
+				return &blob{data: data}, nil
+			},
+			Compress:        compress,
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	c := newCache(true)
+	uncompressed := newCache(false)
+
+	// values are decompressed on read
+	assert.Equal(t, data, c.Get(0).data)
+	assert.False(t, c.Get(0).compressed)
+	assert.Equal(t, data, c.GetMultiple([]int{0, 1})[1].data)
+	value, _ := c.Peek(1)
+	assert.Equal(t, data, value.data)
+	assert.Nil(t, c.Set(2, &blob{data: []byte("set")}))
+	assert.Equal(t, []byte("set"), c.Get(2).data)
+
+	// cached values are compressed
+	_ = uncompressed.Get(0)
+	size, _ := c.MemSizeOf(0)
+	uncompressedSize, _ := uncompressed.MemSizeOf(0)
+	assert.Equal(t, uint64(len(data)), uncompressedSize)
+	assert.Less(t, size, uncompressedSize/100)
+
+	// values have to be compressible
+	_, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return nil, ErrNotFound
+		},
+		Compress: true,
+		Timeouts: cacheTestTimeouts,
+	})
+	assert.ErrorIs(t, err, ErrInvalidParams)
+}
@@ -0,0 +1,197 @@
+package lazy
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func testCacheDistribution(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.ReloadInterval = 1 * time.Second
+
+	connections := test_utils.NatsConnections(t, 2)
+	version := atomic.Int64{}
+
+	newCache := func(loads *atomic.Int64, connectionIdx int) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				loads.Add(1)
+				return test_utils.StringPointer("value" + strconv.FormatInt(version.Load(), 10)), nil
+			},
+			Timeouts:        timeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+			Distribution: &Distribution[int, string]{
+				Connection: connections[connectionIdx],
+				Subject:    "test.distribution",
+			},
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	loadsA := atomic.Int64{}
+	loadsB := atomic.Int64{}
+	cacheA := newCache(&loadsA, 0)
+	cacheB := newCache(&loadsB, 1)
+
+	// both instances cache the entry
+	assert.Equal(t, "value0", *cacheA.Get(0))
+	assert.Equal(t, "value0", *cacheB.Get(0))
+	assert.Equal(t, int64(1), loadsA.Load())
+	assert.Equal(t, int64(1), loadsB.Load())
+
+	// data expire, instance A reloads entry and distributes the new value
+	version.Store(1)
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, "value1", *cacheA.Get(0))
+	time.Sleep(200 * time.Millisecond)
+
+	// instance B applied the value instead of reloading
+	assert.Equal(t, "value1", *cacheB.Get(0))
+	assert.Equal(t, int64(2), loadsA.Load())
+	assert.Equal(t, int64(1), loadsB.Load())
+
+	// older results are ignored
+	key, _ := json.Marshal(0)
+	value, _ := json.Marshal("outdated")
+	msg, _ := json.Marshal(distributedEntry{
+		Instance: "other",
+		Key:      key,
+		Value:    value,
+		LoadedAt: time.Now().Add(-time.Minute).UnixMilli(),
+	})
+	cacheB.receiveDistributedEntry(msg)
+	assert.Equal(t, "value1", *cacheB.Get(0))
+}
+
+func testCacheRemoveAndBroadcast(t *testing.T) {
+	t.Parallel()
+
+	connections := test_utils.NatsConnections(t, 2)
+
+	newCache := func(distribution *Distribution[int, string]) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value" + strconv.Itoa(ID)), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+			Distribution:    distribution,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	cacheA := newCache(&Distribution[int, string]{Connection: connections[0], Subject: "test.remove"})
+	cacheB := newCache(&Distribution[int, string]{Connection: connections[1], Subject: "test.remove"})
+	// make sure subscriptions are registered by the server
+	for _, connection := range connections {
+		assert.Nil(t, connection.Flush())
+	}
+
+	assert.Equal(t, "value0", *cacheA.Get(0))
+	assert.Equal(t, "value0", *cacheB.Get(0))
+	assert.Equal(t, "value1", *cacheB.Get(1))
+
+	assert.Nil(t, cacheA.RemoveAndBroadcast(0))
+	assert.False(t, cacheA.IsCached(0))
+	assert.Eventually(t, func() bool {
+		return !cacheB.IsCached(0)
+	}, time.Second, 10*time.Millisecond)
+	// other entries are kept
+	assert.True(t, cacheB.IsCached(1))
+
+	// without distribution entry is removed only locally
+	local := newCache(nil)
+	assert.Equal(t, "value0", *local.Get(0))
+	assert.ErrorIs(t, local.RemoveAndBroadcast(0), ErrDistributionDisabled)
+	assert.False(t, local.IsCached(0))
+}
+
+func testCacheDistributionLocalState(t *testing.T) {
+	t.Parallel()
+
+	connections := test_utils.NatsConnections(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: ctx,
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Distribution: &Distribution[int, string]{
+			Connection: connections[0],
+			Subject:    "test.distribution_local",
+		},
+	})
+	assert.Nil(t, err)
+
+	message := func(value string, loadedAt time.Time) []byte {
+		encodedKey, _ := json.Marshal(0)
+		encodedValue, _ := json.Marshal(value)
+		msg, _ := json.Marshal(distributedEntry{
+			Instance: "other",
+			Key:      encodedKey,
+			Value:    encodedValue,
+			LoadedAt: loadedAt.UnixMilli(),
+		})
+		return msg
+	}
+
+	assert.Equal(t, "value", *c.Get(0))
+	c.mu.RLock()
+	entry := c.data[0]
+	c.mu.RUnlock()
+
+	// TTL of entry not accessed by this instance is not prolonged
+	entry.accessed.Store(false)
+	expiresAt := entry.expiresAt.Load()
+	time.Sleep(5 * time.Millisecond)
+	c.receiveDistributedEntry(message("value1", time.Now()))
+	assert.Equal(t, "value1", *entry.value.Load())
+	assert.Equal(t, expiresAt, entry.expiresAt.Load())
+	assert.False(t, entry.accessed.Load())
+
+	// accessed entry stays accessed, load time in the future is clamped
+	entry.accessed.Store(true)
+	time.Sleep(5 * time.Millisecond)
+	c.receiveDistributedEntry(message("value2", time.Now().Add(time.Hour)))
+	assert.Equal(t, "value2", *entry.value.Load())
+	assert.True(t, entry.accessed.Load())
+	assert.LessOrEqual(t, entry.lastLoaded.Load(), time.Now().UnixMilli())
+	assert.LessOrEqual(t, entry.expiresAt.Load(), time.Now().Add(cacheTestTimeouts.TTL).UnixMilli())
+
+	// results are received until the cache context is done
+	assert.Nil(t, connections[0].Flush())
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, connections[1].Publish("test.distribution_local", message("value3", time.Now())))
+	assert.Eventually(t, func() bool {
+		return *entry.value.Load() == "value3"
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return connections[0].NumSubscriptions() == 0
+	}, time.Second, 10*time.Millisecond)
+}
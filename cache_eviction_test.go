@@ -0,0 +1,318 @@
+package lazy
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func testCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	maxEntries := 10
+
+	newCache := func(policy EvictionPolicy) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+			MaxEntries:      maxEntries,
+			EvictionPolicy:  policy,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	// capacity is respected by all policies
+	for _, policy := range []EvictionPolicy{EvictionLRU, EvictionSampledLRU, EvictionRandom} {
+		c := newCache(policy)
+		for i := 0; i < 50; i++ {
+			assert.Equal(t, "value_"+strconv.Itoa(i), *c.Get(i))
+			assert.Nil(t, c.Set(100+i, test_utils.StringPointer("set")))
+			assert.LessOrEqual(t, c.Len(), maxEntries)
+		}
+		assert.Equal(t, maxEntries, c.Len())
+		assert.Equal(t, uint64(90), c.Stats().Evictions)
+	}
+
+	// least recently accessed entry is evicted
+	c := newCache(EvictionLRU)
+	for i := 0; i < maxEntries; i++ {
+		_ = c.Get(i)
+		time.Sleep(2 * time.Millisecond)
+	}
+	_ = c.Get(0)
+	time.Sleep(2 * time.Millisecond)
+	_ = c.Get(maxEntries)
+
+	assert.True(t, c.IsCached(0))
+	assert.False(t, c.IsCached(1))
+	assert.True(t, c.IsCached(maxEntries))
+}
+
+func testCacheResize(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(timeouts Timeouts, hardMemoryCeiling uint64) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+			},
+			Timeouts:          timeouts,
+			AutomaticReload:   AutomaticReloadDisabled,
+			HardMemoryCeiling: hardMemoryCeiling,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	c := newCache(cacheTestTimeouts, 0)
+	assert.ErrorIs(t, c.Resize(-1, 0), ErrInvalidParams)
+	// memory size is not measured
+	assert.ErrorIs(t, c.Resize(0, 1024), ErrInvalidParams)
+
+	for i := 0; i < 100; i++ {
+		_ = c.Get(i)
+	}
+	assert.Equal(t, 100, c.Len())
+
+	// shrinking is safe under concurrent reads
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = c.Get(i % 100)
+		}
+	}()
+
+	assert.Nil(t, c.Resize(10, 0))
+	assert.LessOrEqual(t, c.Len(), 10)
+	<-done
+	assert.LessOrEqual(t, c.Len(), 10)
+
+	// most recently accessed entry is kept
+	time.Sleep(2 * time.Millisecond)
+	_ = c.Get(1000)
+	assert.Nil(t, c.Resize(5, 0))
+	assert.Equal(t, 5, c.Len())
+	assert.True(t, c.IsCached(1000))
+
+	// growing does not evict anything
+	assert.Nil(t, c.Resize(0, 0))
+	for i := 0; i < 100; i++ {
+		_ = c.Get(i)
+	}
+	assert.Equal(t, 101, c.Len())
+
+	// evict by memory size
+	timeouts := cacheTestTimeouts
+	timeouts.MemsizeUpdate = 50 * time.Millisecond
+	c = newCache(timeouts, 1<<30)
+	for i := 0; i < 100; i++ {
+		_ = c.Get(i)
+	}
+	assert.Eventually(t, func() bool {
+		return c.memSizeValue.Load() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	maxBytes := c.memSizeValue.Load() / 2
+	assert.Nil(t, c.Resize(0, maxBytes))
+	assert.Less(t, c.Len(), 100)
+	assert.LessOrEqual(t, c.memSizeValue.Load(), maxBytes)
+
+	// memory budget does not replace hard memory ceiling (insertions are allowed)
+	assert.Equal(t, uint64(1<<30), c.hardMemoryCeiling.Load())
+	assert.NotNil(t, c.Get(1000))
+	assert.True(t, c.IsCached(1000))
+}
+
+func BenchmarkCacheEviction(b *testing.B) {
+	policies := map[string]EvictionPolicy{
+		"lru":         EvictionLRU,
+		"sampled_lru": EvictionSampledLRU,
+		"random":      EvictionRandom,
+	}
+
+	for name, policy := range policies {
+		b.Run(name, func(b *testing.B) {
+			var loads atomic.Int64
+			value := "value"
+
+			c, err := NewCache(Params[int, string]{
+				Context: context.Background(),
+				Log:     zerolog.Nop(),
+				Name:    "test_cache1",
+				LoadOneFunc: func(ID int) (entry *string, err error) {
+					loads.Add(1)
+					return &value, nil
+				},
+				Timeouts:        cacheTestTimeouts,
+				AutomaticReload: AutomaticReloadDisabled,
+				MaxEntries:      1000,
+				EvictionPolicy:  policy,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// skewed workload (small part of keys is read most of the time)
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 100000)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = c.Get(int(zipf.Uint64()))
+			}
+			b.ReportMetric(1-float64(loads.Load())/float64(b.N), "hit_ratio")
+		})
+	}
+}
+
+func testCacheCapacityDryRun(t *testing.T) {
+	t.Parallel()
+
+	maxEntries := 5
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		MetricsRegisterer: prometheus.NewRegistry(),
+		Timeouts:          cacheTestTimeouts,
+		AutomaticReload:   AutomaticReloadDisabled,
+		MaxEntries:        maxEntries,
+		CapacityDryRun:    true,
+	})
+	assert.Nil(t, err)
+
+	// entries over capacity are counted, but not evicted
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, "value_"+strconv.Itoa(i), *c.Get(i))
+		assert.Equal(t, i+1, c.Len())
+		assert.Equal(t, uint64(max(i+1-maxEntries, 0)), c.Stats().WouldEvictions)
+	}
+	assert.Equal(t, uint64(0), c.Stats().Evictions)
+	assert.Equal(t, float64(15), testutil.ToFloat64(c.metrics.WouldEvictCount))
+	for i := 0; i < 20; i++ {
+		assert.True(t, c.IsCached(i))
+	}
+
+	// resize counts entries over the new limit
+	assert.Nil(t, c.Resize(10, 0))
+	assert.Equal(t, uint64(25), c.Stats().WouldEvictions)
+	assert.Equal(t, 20, c.Len())
+}
+
+func testCacheMemoryPressure(t *testing.T) {
+	t.Parallel()
+
+	const threshold = 1 << 30
+
+	// heap is reported over the threshold only once after it is raised
+	var heap atomic.Uint64
+	heapSize := func() uint64 {
+		return heap.Swap(0)
+	}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "memory_pressure",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer(strconv.Itoa(ID)), nil
+		},
+		Timeouts: cacheTestTimeouts,
+		MemoryPressure: &MemoryPressure{
+			HeapThreshold: threshold,
+			EvictFraction: 0.5,
+			CheckInterval: 5 * time.Millisecond,
+			HeapSize:      heapSize,
+		},
+		PinnedKeys: []int{0},
+	})
+	assert.Nil(t, err)
+
+	for ID := range 100 {
+		_ = c.Get(ID)
+	}
+	// recently accessed entries are kept
+	time.Sleep(5 * time.Millisecond)
+	for ID := 50; ID < 100; ID++ {
+		_ = c.Get(ID)
+	}
+
+	// heap under the threshold does not evict
+	heap.Store(threshold)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 100, c.Len())
+
+	heap.Store(threshold + 1)
+	assert.Eventually(t, func() bool {
+		return c.Len() == 50
+	}, time.Second, time.Millisecond)
+	// pinned entry and all but one recently accessed entries are kept
+	assert.True(t, c.IsCached(0))
+	recent := 0
+	for ID := 50; ID < 100; ID++ {
+		if c.IsCached(ID) {
+			recent++
+		}
+	}
+	assert.Equal(t, 49, recent)
+	assert.Equal(t, uint64(50), c.Stats().Evictions)
+}
+
+func testCacheMemoryPressureLargeBatch(t *testing.T) {
+	t.Parallel()
+
+	const entries = 20000
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "memory_pressure_large_batch",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer(strconv.Itoa(ID)), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		MemoryPressure: &MemoryPressure{
+			HeapThreshold: 1 << 30,
+			EvictFraction: 0.5,
+			CheckInterval: time.Hour,
+		},
+	})
+	assert.Nil(t, err)
+
+	for ID := range entries {
+		_ = c.Get(ID)
+	}
+
+	// victims are selected at once, so the cache is not locked for time
+	// proportional to number of entries times number of victims
+	start := time.Now()
+	c.evictUnderPressure(2<<30, 0.5)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, entries/2, c.Len())
+	assert.Equal(t, uint64(entries/2), c.Stats().Evictions)
+}
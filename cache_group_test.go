@@ -0,0 +1,103 @@
+package lazy
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+// TestCacheGroup is not parallel, so goroutines of other tests do not affect it
+func TestCacheGroup(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	registry := prometheus.NewRegistry()
+	g, err := NewCacheGroup(GroupParams[string, int, string]{
+		Context: context.Background(),
+		Params: Params[int, string]{
+			Log:               test_utils.Logger(),
+			Name:              "group_cache",
+			Timeouts:          cacheTestTimeouts,
+			AutomaticReload:   AutomaticReloadAccessedEntries,
+			MetricsRegisterer: registry,
+		},
+		LoadOneFunc: func(ns string, ID int) (entry *string, err error) {
+			if ns == "empty" {
+				return nil, ErrNotFound
+			}
+
+			return test_utils.StringPointer(ns), nil
+		},
+	})
+	assert.Nil(t, err)
+
+	// namespaces do not share data
+	assert.Equal(t, "tenant", *g.Get("tenant", 1))
+	assert.Nil(t, g.Get("empty", 1))
+
+	tenant, err := g.Cache("tenant")
+	assert.Nil(t, err)
+	assert.Equal(t, "group_cache_tenant", tenant.Name())
+	assert.True(t, tenant.IsCached(1))
+	assert.False(t, tenant.IsCached(2))
+
+	empty, err := g.Cache("empty")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"tenant", "empty"}, g.Namespaces())
+
+	// cache of the namespace is created only once
+	again, err := g.Cache("tenant")
+	assert.Nil(t, err)
+	assert.Same(t, tenant, again)
+
+	// metrics are labeled by namespace
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	var namespaces []string
+	for _, family := range families {
+		if family.GetName() != "lazy_cache_reads_count" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == namespaceLabel {
+					namespaces = append(namespaces, label.GetValue())
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"tenant", "empty"}, namespaces)
+
+	// single close stops caches of all namespaces
+	g.Close()
+	assert.NotNil(t, tenant.ctx.Err())
+	assert.NotNil(t, empty.ctx.Err())
+	assert.Empty(t, g.Namespaces())
+	// polled without assert.Eventually, which runs the condition in own routine
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+
+	_, err = g.Cache("tenant")
+	assert.ErrorIs(t, err, ErrCacheClosed)
+	assert.Nil(t, g.Get("tenant", 1))
+
+	// namespace label is reserved
+	_, err = NewCacheGroup(GroupParams[string, int, string]{
+		Context: context.Background(),
+		Params: Params[int, string]{
+			Name:          "group_cache_invalid",
+			MetricsLabels: map[string]string{namespaceLabel: "tenant"},
+		},
+		LoadOneFunc: func(ns string, ID int) (entry *string, err error) {
+			return nil, ErrNotFound
+		},
+	})
+	assert.ErrorIs(t, err, ErrInvalidParams)
+}
@@ -0,0 +1,127 @@
+package lazy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+type testUser struct {
+	ID    int
+	Email string
+}
+
+func testCacheIndex(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+	var mu sync.Mutex
+	emails := map[int]string{1: "user1@example.com", 2: "user2@example.com"}
+
+	c, err := NewCache(Params[int, testUser]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *testUser, err error) {
+			loadCounter.Add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			email, exists := emails[ID]
+			if !exists {
+				return nil, ErrNotFound
+			}
+			return &testUser{ID: ID, Email: email}, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	_ = c.Get(1)
+
+	byEmail := func(user *testUser) (string, bool) {
+		return user.Email, user.Email != ""
+	}
+	assert.Nil(t, AddIndex(c, "email", byEmail))
+	assert.ErrorIs(t, AddIndex(c, "email", byEmail), ErrInvalidParams)
+
+	// already cached value is indexed
+	assert.Equal(t, &testUser{ID: 1, Email: "user1@example.com"}, GetByIndex(c, "email", "user1@example.com"))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// value is indexed when loaded
+	assert.Nil(t, GetByIndex(c, "email", "user2@example.com"))
+	_ = c.Get(2)
+	assert.Equal(t, 2, GetByIndex(c, "email", "user2@example.com").ID)
+	assert.Nil(t, GetByIndex(c, "email", "user3@example.com"))
+	assert.Nil(t, GetByIndex(c, "missing", "user2@example.com"))
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	// derived key changes on next load of the primary entry
+	mu.Lock()
+	emails[2] = "user2+new@example.com"
+	mu.Unlock()
+	c.Invalidate(2)
+	_ = c.Get(2)
+	assert.Nil(t, GetByIndex(c, "email", "user2@example.com"))
+	assert.Equal(t, 2, GetByIndex(c, "email", "user2+new@example.com").ID)
+
+	// removed entries are removed from index
+	c.Remove(1)
+	assert.Nil(t, GetByIndex(c, "email", "user1@example.com"))
+
+	// values set directly are indexed
+	assert.Nil(t, c.Set(3, &testUser{ID: 3, Email: "user3@example.com"}))
+	assert.Equal(t, 3, GetByIndex(c, "email", "user3@example.com").ID)
+}
+
+func testCacheIndexRemovedDuringReload(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+	reloading := make(chan struct{})
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, testUser]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *testUser, err error) {
+			// reload is blocked until the entry is removed
+			if loadCounter.Add(1) == 2 {
+				close(reloading)
+				<-release
+			}
+			return &testUser{ID: ID, Email: "user1@example.com"}, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, AddIndex(c, "email", func(user *testUser) (string, bool) {
+		return user.Email, true
+	}))
+
+	_ = c.Get(1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.InvalidateAndWait(1, time.Second)
+	}()
+
+	<-reloading
+	c.Remove(1)
+	close(release)
+	<-done
+
+	// removed entry is not indexed by its reload, so it is not loaded again
+	assert.False(t, c.IsCached(1))
+	assert.Nil(t, GetByIndex(c, "email", "user1@example.com"))
+	assert.Equal(t, int64(2), loadCounter.Load())
+}
@@ -2,11 +2,16 @@ package lazy
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/moderntv/lazy-cache/internal/memsize"
 	"github.com/moderntv/lazy-cache/internal/test_utils"
 )
 
@@ -170,3 +175,244 @@ func testCacheMemsizeManual(t *testing.T) {
 	t.Logf("10.5s size: %d", size)
 	assert.Equal(t, uint64(0), size)
 }
+
+func testCacheMemsizeHardCeiling(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.MemsizeUpdate = 100 * time.Millisecond
+
+	loads := atomic.Int64{}
+
+	c, err := NewCache(Params[int, entryMemTestManual]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *entryMemTestManual, err error) {
+			loads.Add(1)
+			return &entryMemTestManual{ID}, nil
+		},
+		Timeouts:          timeouts,
+		HardMemoryCeiling: 1000,
+		AutomaticReload:   AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	// below ceiling
+	_ = c.Get(1) // 100 B
+	_ = c.Get(2) // 1000 B
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, uint64(1100), c.memSizeValue.Load())
+	assert.Equal(t, int64(2), loads.Load())
+
+	// over ceiling, new entries are not cached (nor loaded)
+	assert.Nil(t, c.Get(3))
+	assert.Equal(t, map[int]*entryMemTestManual{}, c.GetMultiple([]int{4, 5}))
+	assert.Equal(t, int64(2), loads.Load())
+	assert.Equal(t, 2, len(c.data))
+	assert.Equal(t, uint64(3), c.Stats().RejectedInsertions)
+
+	// cached entries are still served
+	assert.Equal(t, 1, c.Get(1).value)
+	assert.Equal(t, 2, c.Get(2).value)
+
+	// memory dropped below ceiling
+	c.Remove(2)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 3, c.Get(3).value)
+	assert.Equal(t, int64(3), loads.Load())
+}
+
+func testCacheMemsizeOf(t *testing.T) {
+	t.Parallel()
+
+	manual, err := NewCache(Params[int, entryMemTestManual]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *entryMemTestManual, err error) {
+			if ID == 0 {
+				return nil, ErrNotFound
+			}
+			return &entryMemTestManual{ID}, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	_ = manual.Get(0)
+	_ = manual.Get(1)
+	_ = manual.Get(2)
+
+	size, cached := manual.MemSizeOf(1)
+	assert.True(t, cached)
+	assert.Equal(t, uint64(100), size)
+	size, cached = manual.MemSizeOf(2)
+	assert.True(t, cached)
+	assert.Equal(t, uint64(1000), size)
+
+	// not found entry
+	size, cached = manual.MemSizeOf(0)
+	assert.True(t, cached)
+	assert.Equal(t, uint64(0), size)
+
+	// not cached entry
+	size, cached = manual.MemSizeOf(3)
+	assert.False(t, cached)
+	assert.Equal(t, uint64(0), size)
+
+	// reflection measured entries
+	generic, err := NewCache(Params[int, entryMemTestGeneric]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *entryMemTestGeneric, err error) {
+			return &entryMemTestGeneric{
+				intValue:   135,
+				intPointer: test_utils.Int64Pointer(89465),
+				strValue:   strings.Repeat("a", ID),
+			}, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	_ = generic.Get(1)
+	_ = generic.Get(100)
+
+	smallSize, cached := generic.MemSizeOf(1)
+	assert.True(t, cached)
+	assert.Equal(t, memsize.Entry(generic.Get(1)), smallSize)
+	largeSize, cached := generic.MemSizeOf(100)
+	assert.True(t, cached)
+	assert.Greater(t, largeSize, smallSize)
+}
+
+func testCacheMemsizeRefresh(t *testing.T) {
+	t.Parallel()
+
+	// periodic measurement is disabled
+	c, err := NewCache(Params[int, entryMemTestManual]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *entryMemTestManual, err error) {
+			if ID == 0 {
+				return nil, ErrNotFound
+			}
+			return &entryMemTestManual{ID}, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, uint64(0), c.RefreshMemSize())
+
+	_ = c.Get(0)
+	_ = c.Get(1)
+	_ = c.Get(2)
+	assert.Equal(t, uint64(1100), c.RefreshMemSize())
+	assert.Equal(t, uint64(1100), c.memSizeValue.Load())
+
+	c.Remove(2)
+	assert.Equal(t, uint64(100), c.RefreshMemSize())
+}
+
+func testCacheAccessedEntries(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// read entries are accessed, inserted ones are not
+	for ID := 0; ID < 3; ID++ {
+		_ = c.Get(ID)
+	}
+	for ID := 10; ID < 14; ID++ {
+		assert.Nil(t, c.Set(ID, test_utils.StringPointer("set")))
+	}
+	_ = c.Get(10)
+
+	// counted by memory size measurement
+	assert.Equal(t, uint64(0), c.Stats().AccessedEntries)
+	c.RefreshMemSize()
+	stats := c.Stats()
+	assert.Equal(t, uint64(4), stats.AccessedEntries)
+	assert.Equal(t, uint64(3), stats.UnaccessedEntries)
+
+	// new value clears access of the entry
+	assert.Nil(t, c.Set(0, test_utils.StringPointer("set")))
+	c.RefreshMemSize()
+	stats = c.Stats()
+	assert.Equal(t, uint64(3), stats.AccessedEntries)
+	assert.Equal(t, uint64(4), stats.UnaccessedEntries)
+}
+
+func newMemsizeTestCache(tb testing.TB, entries int, workers int) *Cache[int, entryMemTestGeneric] {
+	c, err := NewCache(Params[int, entryMemTestGeneric]{
+		Context: context.Background(),
+		Log:     zerolog.Nop(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *entryMemTestGeneric, err error) {
+			return nil, ErrNotFound
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		MemsizeWorkers:  workers,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for ID := range entries {
+		c.Set(ID, &entryMemTestGeneric{
+			intValue:   int64(ID),
+			intPointer: test_utils.Int64Pointer(int64(ID)),
+			strValue:   strings.Repeat("a", ID%100),
+			strPointer: test_utils.StringPointer(strings.Repeat("b", ID%50)),
+		})
+	}
+
+	return c
+}
+
+func testCacheMemsizeWorkers(t *testing.T) {
+	t.Parallel()
+
+	// sharded measurement gives the same size as measurement by one routine
+	single := newMemsizeTestCache(t, 10000, 0)
+	sharded := newMemsizeTestCache(t, 10000, 4)
+	size := single.RefreshMemSize()
+	assert.Greater(t, size, uint64(0))
+	assert.Equal(t, size, sharded.RefreshMemSize())
+
+	// small caches are measured by one routine
+	small := newMemsizeTestCache(t, 10, 4)
+	assert.Equal(t, newMemsizeTestCache(t, 10, 0).RefreshMemSize(), small.RefreshMemSize())
+}
+
+func BenchmarkCacheMemsize(b *testing.B) {
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", workers), func(b *testing.B) {
+			c := newMemsizeTestCache(b, 100000, workers)
+
+			b.ResetTimer()
+			for range b.N {
+				_ = c.RefreshMemSize()
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package lazy
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func testCacheSingleScheduler(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+	// scheduler follows clock of the cache
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadAccessedEntries,
+		SingleScheduler: true,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, 1, c.TTLWatcherLen())
+	assert.Equal(t, 1, c.ReloadWatcherLen())
+
+	// accessed entry is reloaded automatically
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	assert.Eventually(t, func() bool {
+		return loadCounter.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// entry not accessed since the reload expires
+	clock.Advance(cacheTestTimeouts.TTL)
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(0)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, c.TTLWatcherLen())
+	assert.Equal(t, 0, c.ReloadWatcherLen())
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	c.Remove(0)
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := newScheduler[int](time.Now)
+
+	s.push(scheduleKey[int]{ID: 1, kind: scheduleReload}, time.Hour)
+	s.push(scheduleKey[int]{ID: 1, kind: scheduleExpire}, time.Hour)
+	s.push(scheduleKey[int]{ID: 2, kind: scheduleReload}, 2*time.Hour)
+	s.push(scheduleKey[int]{ID: 3, kind: scheduleExpire}, 3*time.Hour)
+	// rescheduled item keeps only the new deadline
+	s.push(scheduleKey[int]{ID: 2, kind: scheduleReload}, 0)
+	s.drop(scheduleKey[int]{ID: 3, kind: scheduleExpire})
+	assert.Equal(t, 2, s.len(scheduleReload))
+	assert.Equal(t, 1, s.len(scheduleExpire))
+
+	// expiration precedes reload scheduled for the same instant
+	deadline := s.items[scheduleKey[int]{ID: 1, kind: scheduleExpire}].deadline
+	s.items[scheduleKey[int]{ID: 1, kind: scheduleReload}].deadline = deadline
+
+	keys, next := s.pop(deadline)
+	assert.Equal(t, []scheduleKey[int]{
+		{ID: 2, kind: scheduleReload},
+		{ID: 1, kind: scheduleExpire},
+		{ID: 1, kind: scheduleReload},
+	}, keys)
+	assert.Equal(t, time.Duration(-1), next)
+	assert.Equal(t, 0, s.len(scheduleReload))
+	assert.Equal(t, 0, s.len(scheduleExpire))
+}
+
+// BenchmarkCacheWatcherGoroutines reports number of goroutines per cache with
+// separate watchers and with single scheduler.
+func BenchmarkCacheWatcherGoroutines(b *testing.B) {
+	for name, single := range map[string]bool{"watchers": false, "single_scheduler": true} {
+		b.Run(name, func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			before := runtime.NumGoroutine()
+			for i := 0; i < b.N; i++ {
+				_, err := NewCache(Params[int, string]{
+					Context: ctx,
+					Log:     zerolog.Nop(),
+					Name:    "test_cache1",
+					LoadOneFunc: func(ID int) (entry *string, err error) {
+						return test_utils.StringPointer("value"), nil
+					},
+					Timeouts:        cacheTestTimeouts,
+					AutomaticReload: AutomaticReloadAllEntries,
+					SingleScheduler: single,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			// wait for goroutines of watchers to start
+			time.Sleep(10 * time.Millisecond)
+
+			b.ReportMetric(float64(runtime.NumGoroutine()-before)/float64(b.N), "goroutines/cache")
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package lazy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func testCacheSetWriteThrough(t *testing.T) {
+	t.Parallel()
+
+	persisted := map[int]string{}
+	persistErr := errors.New("persist failed")
+	failPersist := false
+
+	newCache := func(policy WriteThroughPolicy) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("loaded"), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+			WriteThrough: func(ID int, value *string) (err error) {
+				if failPersist {
+					return persistErr
+				}
+				persisted[ID] = *value
+				return nil
+			},
+			WriteThroughPolicy: policy,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	c := newCache(WriteThroughStrict)
+
+	// persisted and cached
+	assert.Nil(t, c.Set(0, test_utils.StringPointer("value0")))
+	assert.Equal(t, "value0", persisted[0])
+	assert.Equal(t, "value0", *c.Get(0))
+
+	// failing persist does not update existing entry
+	failPersist = true
+	err := c.Set(0, test_utils.StringPointer("value1"))
+	assert.ErrorIs(t, err, persistErr)
+	assert.Equal(t, "value0", *c.Get(0))
+
+	// failing persist does not create new entry
+	err = c.Set(1, test_utils.StringPointer("value1"))
+	assert.ErrorIs(t, err, persistErr)
+	assert.Nil(t, c.data[1])
+
+	// best effort policy updates cache anyway
+	c = newCache(WriteThroughBestEffort)
+	err = c.Set(1, test_utils.StringPointer("value1"))
+	assert.ErrorIs(t, err, persistErr)
+	assert.Equal(t, "value1", *c.Get(1))
+}
+
+func testCacheReplaceAll(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("loaded"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadAllEntries,
+		EventsBuffer:    10,
+	})
+	assert.Nil(t, err)
+
+	for ID := 0; ID < 5; ID++ {
+		assert.Equal(t, "loaded", *c.Get(ID))
+		assert.Equal(t, EventLoad, (<-c.Events()).Type)
+	}
+	assert.Nil(t, AddIndex(c, "value", func(value *string) (string, bool) {
+		return *value, true
+	}))
+
+	c.ReplaceAll(map[int]*string{
+		3: test_utils.StringPointer("replaced"),
+		4: nil,
+		5: test_utils.StringPointer("inserted"),
+	})
+
+	// content is exactly the given set
+	assert.Equal(t, 3, c.Len())
+	for ID := 0; ID < 3; ID++ {
+		assert.False(t, c.IsCached(ID))
+	}
+	value, cached := c.Peek(3)
+	assert.True(t, cached)
+	assert.Equal(t, "replaced", *value)
+	assert.True(t, c.IsCached(4))
+	assert.Nil(t, c.Get(4))
+	assert.Equal(t, "inserted", *c.Get(5))
+
+	// index is replaced together with the data
+	assert.Nil(t, GetByIndex(c, "value", "loaded"))
+	assert.Equal(t, "replaced", *GetByIndex(c, "value", "replaced"))
+	assert.Equal(t, "inserted", *GetByIndex(c, "value", "inserted"))
+
+	// watchers track exactly the cached entries
+	assert.Equal(t, 3, c.TTLWatcherLen())
+	assert.Equal(t, 3, c.ReloadWatcherLen())
+
+	// removed entries are evicted
+	var evicted []int
+	for len(c.Events()) > 0 {
+		event := <-c.Events()
+		assert.Equal(t, EventEvict, event.Type)
+		evicted = append(evicted, event.Key)
+	}
+	assert.ElementsMatch(t, []int{0, 1, 2}, evicted)
+
+	// empty map clears the cache
+	c.ReplaceAll(nil)
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, 0, c.TTLWatcherLen())
+	assert.Equal(t, 0, c.ReloadWatcherLen())
+}
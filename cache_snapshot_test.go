@@ -0,0 +1,92 @@
+package lazy
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+// countingCodec counts encoded values
+type countingCodec struct {
+	JSONCodec[int, string]
+	encodedValues atomic.Int64
+}
+
+func (cc *countingCodec) EncodeValue(value *string) ([]byte, error) {
+	cc.encodedValues.Add(1)
+	return cc.JSONCodec.EncodeValue(value)
+}
+
+func testCacheSnapshot(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(loadCounter *atomic.Int64) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				loadCounter.Add(1)
+				if ID%2 == 1 {
+					return nil, ErrNotFound
+				}
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		})
+		assert.Nil(t, err)
+
+		return c
+	}
+
+	for _, includeNegative := range []bool{false, true} {
+		var loads atomic.Int64
+		c := newCache(&loads)
+		for ID := 0; ID < 4; ID++ {
+			c.Get(ID)
+		}
+
+		codec := &countingCodec{}
+		buf := &bytes.Buffer{}
+		err := c.Snapshot(buf, SnapshotOptions[int, string]{Codec: codec, IncludeNegative: includeNegative})
+		assert.Nil(t, err)
+		// values of negative entries are not encoded
+		assert.Equal(t, int64(2), codec.encodedValues.Load())
+
+		var restoredLoads atomic.Int64
+		restored := newCache(&restoredLoads)
+		err = restored.Restore(buf, SnapshotOptions[int, string]{Codec: codec, IncludeNegative: includeNegative})
+		assert.Nil(t, err)
+
+		assert.Equal(t, "value", *restored.Get(0))
+		assert.Equal(t, "value", *restored.Get(2))
+		assert.Equal(t, int64(0), restoredLoads.Load())
+
+		if !includeNegative {
+			assert.Equal(t, 2, restored.Len())
+			continue
+		}
+
+		// negative entries are restored with remaining TTL
+		assert.Equal(t, 4, restored.Len())
+		assert.Nil(t, restored.Get(1))
+		assert.Nil(t, restored.Get(3))
+		assert.Equal(t, int64(0), restoredLoads.Load())
+
+		restored.mu.RLock()
+		expiresAt := restored.data[1].expiresAt.Load()
+		restored.mu.RUnlock()
+		c.mu.RLock()
+		originalExpiresAt := c.data[1].expiresAt.Load()
+		c.mu.RUnlock()
+		assert.LessOrEqual(t, expiresAt, originalExpiresAt+time.Second.Milliseconds())
+		assert.Greater(t, expiresAt, time.Now().UnixMilli())
+	}
+}
@@ -1,15 +1,25 @@
 package lazy
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"math/rand"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/moderntv/lazy-cache/internal/test_utils"
@@ -29,9 +39,108 @@ func TestCache(t *testing.T) {
 	t.Run("error_entry_reload", testCacheErrorEntryReload)
 	t.Run("entry_ttl_prolong", testCacheEntryTTLProlong)
 	t.Run("entry_automatic_reload_all", testCacheEntryAutomaticReloadAll)
+	t.Run("entry_automatic_reload_keep_alive", testCacheEntryAutomaticReloadKeepAlive)
 	t.Run("entry_automatic_reload_accessed", testCacheEntryAutomaticReloadAccessed)
 	t.Run("testCacheMemsizeCalculated", testCacheMemsizeCalculated)
 	t.Run("testCacheMemsizeManual", testCacheMemsizeManual)
+	t.Run("memsize_hard_ceiling", testCacheMemsizeHardCeiling)
+	t.Run("memsize_of", testCacheMemsizeOf)
+	t.Run("memsize_refresh", testCacheMemsizeRefresh)
+	t.Run("remove_during_cold_load", testCacheRemoveDuringColdLoad)
+	t.Run("deduped_loads", testCacheDedupedLoads)
+	t.Run("dump", testCacheDump)
+	t.Run("set_write_through", testCacheSetWriteThrough)
+	t.Run("batch_missing_entries", testCacheBatchMissingEntries)
+	t.Run("batch_unrequested_entries", testCacheBatchUnrequestedEntries)
+	t.Run("batch_duplicate_entries", testCacheBatchDuplicateEntries)
+	t.Run("batch_automatic_reload", testCacheBatchAutomaticReload)
+	t.Run("batch_overlapping_loads", testCacheBatchOverlappingLoads)
+	t.Run("batch_max_size", testCacheBatchMaxSize)
+	t.Run("batch_deprecated_loader", testCacheBatchDeprecatedLoader)
+	t.Run("distribution", testCacheDistribution)
+	t.Run("distribution_local_state", testCacheDistributionLocalState)
+	t.Run("touch", testCacheTouch)
+	t.Run("zero_value", testCacheZeroValue)
+	t.Run("set_timeouts", testCacheSetTimeouts)
+	t.Run("freshness_stats", testCacheFreshnessStats)
+	t.Run("iterator", testCacheIterator)
+	t.Run("circuit_breaker", testCacheCircuitBreaker)
+	t.Run("circuit_breaker_automatic_reload", testCacheCircuitBreakerAutomaticReload)
+	t.Run("circuit_breaker_get_multiple", testCacheCircuitBreakerGetMultiple)
+	t.Run("circuit_breaker_deduped_reload", testCacheCircuitBreakerDedupedReload)
+	t.Run("circuit_breaker_deduped_batch_reload", testCacheCircuitBreakerDedupedBatchReload)
+	t.Run("entry_reuse", testCacheEntryReuse)
+	t.Run("preload", testCachePreload)
+	t.Run("preload_wait", testCachePreloadWait)
+	t.Run("invalidate_and_wait", testCacheInvalidateAndWait)
+	t.Run("read_only", testCacheReadOnly)
+	t.Run("eviction", testCacheEviction)
+	t.Run("resize", testCacheResize)
+	t.Run("should_negative_cache", testCacheShouldNegativeCache)
+	t.Run("negative_compaction", testCacheNegativeCompaction)
+	t.Run("post_load", testCachePostLoad)
+	t.Run("get_with_meta", testCacheGetWithMeta)
+	t.Run("events", testCacheEvents)
+	t.Run("clock", testCacheClock)
+	t.Run("get_stale", testCacheGetStale)
+	t.Run("snapshot", testCacheSnapshot)
+	t.Run("duplicate_metrics", testCacheDuplicateMetrics)
+	t.Run("reload_at", testCacheReloadAt)
+	t.Run("in_flight", testCacheInFlight)
+	t.Run("watcher_len", testCacheWatcherLen)
+	t.Run("compression", testCacheCompression)
+	t.Run("error_retry", testCacheErrorRetry)
+	t.Run("index", testCacheIndex)
+	t.Run("index_removed_during_reload", testCacheIndexRemovedDuringReload)
+	t.Run("valid_key", testCacheValidKey)
+	t.Run("top_keys", testCacheTopKeys)
+	t.Run("retain_value_on_not_found", testCacheRetainValueOnNotFound)
+	t.Run("wait_for_key", testCacheWaitForKey)
+	t.Run("closed", testCacheClosed)
+	t.Run("single_scheduler", testCacheSingleScheduler)
+	t.Run("try_get", testCacheTryGet)
+	t.Run("pause", testCachePause)
+	t.Run("permanent_error", testCachePermanentError)
+	t.Run("skip_cancelled_loads", testCacheSkipCancelledLoads)
+	t.Run("normalize_key", testCacheNormalizeKey)
+	t.Run("on_health_change", testCacheOnHealthChange)
+	t.Run("preload_results", testCachePreloadResults)
+	t.Run("hook_panics", testCacheHookPanics)
+	t.Run("max_staleness", testCacheMaxStaleness)
+	t.Run("collectors", testCacheCollectors)
+	t.Run("get_fairness", testCacheGetFairness)
+	t.Run("pin", testCachePin)
+	t.Run("fallbacks", testCacheFallbacks)
+	t.Run("reload_changes", testCacheReloadChanges)
+	t.Run("insert_after_load", testCacheInsertAfterLoad)
+	t.Run("replace_all", testCacheReplaceAll)
+	t.Run("load_hooks", testCacheLoadHooks)
+	t.Run("capacity_dry_run", testCacheCapacityDryRun)
+	t.Run("clone", testCacheClone)
+	t.Run("accessed_entries", testCacheAccessedEntries)
+	t.Run("remove_and_broadcast", testCacheRemoveAndBroadcast)
+	t.Run("max_background_loads", testCacheMaxBackgroundLoads)
+	t.Run("grace_entries", testCacheGraceEntries)
+	t.Run("metrics_snapshot", testCacheMetricsSnapshot)
+	t.Run("access_count", testCacheAccessCount)
+	t.Run("fatal_error", testCacheFatalError)
+	t.Run("memsize_workers", testCacheMemsizeWorkers)
+	t.Run("on_ttl_assigned", testCacheOnTTLAssigned)
+	t.Run("not_found_reason", testCacheNotFoundReason)
+	t.Run("coalesce_cold_loads", testCacheCoalesceColdLoads)
+	t.Run("config_accessors", testCacheConfigAccessors)
+	t.Run("slow_load_threshold", testCacheSlowLoadThreshold)
+	t.Run("invalidate_with_ttl", testCacheInvalidateWithTTL)
+	t.Run("health", testCacheHealth)
+	t.Run("expire_at", testCacheExpireAt)
+	t.Run("write_json", testCacheWriteJSON)
+	t.Run("lazy_first_load", testCacheLazyFirstLoad)
+	t.Run("keys_sorted", testCacheKeysSorted)
+	t.Run("load_limiter", testCacheLoadLimiter)
+	t.Run("get_with_args", testCacheGetWithArgs)
+	t.Run("compact", testCacheCompact)
+	t.Run("memory_pressure", testCacheMemoryPressure)
+	t.Run("memory_pressure_large_batch", testCacheMemoryPressureLargeBatch)
 }
 
 func testCacheParallelism(t *testing.T) {
@@ -72,6 +181,7 @@ func testCacheParallelism(t *testing.T) {
 	removeCounter := atomic.Int64{}
 
 	wg := sync.WaitGroup{}
+	start := time.Now()
 
 	for i := 0; i < routines; i++ {
 		wg.Add(1)
@@ -113,11 +223,15 @@ func testCacheParallelism(t *testing.T) {
 	}
 
 	wg.Wait()
+	elapsed := time.Since(start)
 
 	t.Log("Gets:", getCounter.Load())
 	t.Log("Loads:", loadCounter.Load())
 	t.Log("Invalidation:", invalidationsCounter.Load())
 	t.Log("Removals:", removeCounter.Load())
+	// throughput of the mixed workload (see `BenchmarkCacheMapParallel` for
+	// comparison of map designs)
+	t.Log("Gets/s:", int64(float64(getCounter.Load())/elapsed.Seconds()))
 }
 
 func testCacheErrorEntryReload(t *testing.T) {
@@ -292,6 +406,44 @@ func testCacheEntryAutomaticReloadAll(t *testing.T) {
 	assert.Equal(t, 4, loadCounter)
 }
 
+func testCacheEntryAutomaticReloadKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+
+	newCache := func(automaticReload AutomaticReload) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				loadCounter.Add(1)
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts: Timeouts{
+				TTL:            1 * time.Second,
+				NotFoundTTL:    1 * time.Second,
+				ReloadInterval: 500 * time.Millisecond,
+			},
+			AutomaticReload: automaticReload,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	keepAlive := newCache(AutomaticReloadAllEntriesKeepAlive)
+	all := newCache(AutomaticReloadAllEntries)
+
+	_ = keepAlive.Get(0)
+	_ = all.Get(0)
+	time.Sleep(2500 * time.Millisecond)
+
+	// not accessed entry lives well past TTL
+	assert.True(t, keepAlive.IsCached(0))
+	assert.False(t, all.IsCached(0))
+	assert.Greater(t, loadCounter.Load(), int64(4))
+}
+
 func testCacheEntryAutomaticReloadAccessed(t *testing.T) {
 	t.Parallel()
 
@@ -341,3 +493,3714 @@ func testCacheEntryAutomaticReloadAccessed(t *testing.T) {
 	assert.Equal(t, 4, loadCounter)
 	assert.Equal(t, 0, len(c.data))
 }
+
+func testCacheRemoveDuringColdLoad(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.TTL = 2 * time.Second
+	timeouts.ReloadInterval = 1 * time.Second
+
+	loadStarted := make(chan struct{})
+	loadRelease := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context:         context.Background(),
+		Log:             test_utils.Logger(),
+		MetricsRegistry: test_utils.Metrics("remove_during_cold_load"),
+		Name:            "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			close(loadStarted)
+			<-loadRelease
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadAllEntries,
+	})
+
+	assert.Nil(t, err)
+
+	done := make(chan *string)
+	go func() {
+		done <- c.Get(0)
+	}()
+
+	// remove placeholder entry while the load is running
+	<-loadStarted
+	c.Remove(0)
+	close(loadRelease)
+
+	// loaded value is returned to the caller, but not cached
+	value := <-done
+	assert.Equal(t, "value", *value)
+	c.mu.RLock()
+	assert.Nil(t, c.data[0])
+	c.mu.RUnlock()
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.LazyLoadCount))
+
+	// no watcher fires for the discarded load
+	time.Sleep(timeouts.TTL + 500*time.Millisecond)
+	c.mu.RLock()
+	assert.Nil(t, c.data[0])
+	c.mu.RUnlock()
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.LazyLoadCount))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.AutomaticLoadCount))
+}
+
+func testCacheDedupedLoads(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context:         context.Background(),
+		Log:             test_utils.Logger(),
+		MetricsRegistry: test_utils.Metrics("deduped_loads"),
+		Name:            "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	routines := 100
+	getConcurrently := func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < routines; i++ {
+			wg.Add(1)
+			go func() {
+				assert.Equal(t, "value", *c.Get(0))
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+	}
+
+	// cold entry
+	getConcurrently()
+	assert.Equal(t, int64(1), loadCounter.Load())
+	dedupedLoads := c.Stats().DedupedLoads
+	assert.Greater(t, dedupedLoads, uint64(0))
+
+	// expired entry
+	c.Invalidate(0)
+	getConcurrently()
+	assert.Equal(t, int64(2), loadCounter.Load())
+	assert.Greater(t, c.Stats().DedupedLoads, dedupedLoads)
+	assert.Equal(t, float64(c.Stats().DedupedLoads), testutil.ToFloat64(c.metrics.DedupedLoadCount))
+}
+
+func testCacheDump(t *testing.T) {
+	t.Parallel()
+
+	errLoad := errors.New("load failed")
+	var reloading atomic.Bool
+	loading := make(chan struct{})
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 1 {
+				return nil, ErrNotFound
+			}
+			if ID == 2 {
+				return nil, errLoad
+			}
+			if ID == 3 && reloading.Load() {
+				close(loading)
+				<-release
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	now := time.Now()
+	_ = c.Get(0)
+	_ = c.Get(1)
+	c.Invalidate(1)
+	_ = c.Get(2)
+
+	infos := c.Dump()
+	assert.Equal(t, 3, len(infos))
+
+	byKey := make(map[int]EntryInfo[int], len(infos))
+	for _, info := range infos {
+		byKey[info.Key] = info
+	}
+
+	found := byKey[0]
+	assert.True(t, found.HasValue)
+	assert.True(t, found.Accessed)
+	assert.WithinDuration(t, now.Add(cacheTestTimeouts.ReloadInterval), found.NextReload, 100*time.Millisecond)
+	assert.Equal(t, cacheTestTimeouts.ReloadInterval, found.ReloadInterval)
+	assert.WithinDuration(t, now.Add(cacheTestTimeouts.TTL), found.ExpiresAt, 100*time.Millisecond)
+
+	notFound := byKey[1]
+	assert.False(t, notFound.HasValue)
+	assert.True(t, notFound.Accessed)
+	assert.True(t, notFound.NextReload.IsZero()) // invalidated
+	assert.WithinDuration(t, now.Add(cacheTestTimeouts.NotFoundTTL), notFound.ExpiresAt, 100*time.Millisecond)
+	assert.Nil(t, notFound.Err)
+
+	failed := byKey[2]
+	assert.False(t, failed.HasValue)
+	assert.ErrorIs(t, failed.Err, errLoad)
+	assert.WithinDuration(t, now.Add(cacheTestTimeouts.ErrorTTL), failed.ExpiresAt, 100*time.Millisecond)
+
+	// entry being reloaded is dumped with state of the finished reload
+	_ = c.Get(3)
+	reloading.Store(true)
+	c.Invalidate(3)
+	go func() {
+		_ = c.Get(3)
+	}()
+	<-loading
+	dumped := make(chan []EntryInfo[int])
+	go func() {
+		dumped <- c.Dump()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	reloaded := time.Now()
+	close(release)
+	for _, info := range <-dumped {
+		if info.Key == 3 {
+			assert.WithinDuration(t, reloaded.Add(cacheTestTimeouts.ReloadInterval), info.NextReload, 100*time.Millisecond)
+			assert.WithinDuration(t, reloaded.Add(cacheTestTimeouts.TTL), info.ExpiresAt, 100*time.Millisecond)
+		}
+	}
+}
+
+func testCacheTouch(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.TTL = 2 * time.Second
+	timeouts.ReloadInterval = 1 * time.Second
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 1 {
+				return nil, errors.New("load failed")
+			}
+			loadCounter.Add(1)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	assert.False(t, c.Touch(0))
+
+	// failed entry keeps error TTL (it is not turned into not found entry)
+	assert.Nil(t, c.Get(1))
+	assert.True(t, c.Touch(1))
+	c.mu.RLock()
+	expiresIn := c.data[1].expiresAt.Load() - c.nowMillis()
+	c.mu.RUnlock()
+	assert.LessOrEqual(t, expiresIn, timeouts.ErrorTTL.Milliseconds())
+
+	// 0s
+	_ = c.Get(0) // TTL at 2s
+	time.Sleep(1500 * time.Millisecond)
+	// 1.5s
+	assert.True(t, c.Touch(0)) // TTL at 3.5s
+	time.Sleep(1000 * time.Millisecond)
+	// 2.5s (original TTL passed)
+	c.mu.RLock()
+	assert.NotNil(t, c.data[0])
+	c.mu.RUnlock()
+	time.Sleep(1500 * time.Millisecond)
+	// 4s
+	c.mu.RLock()
+	assert.Nil(t, c.data[0])
+	c.mu.RUnlock()
+	assert.Equal(t, int64(1), loadCounter.Load())
+}
+
+func testCacheZeroValue(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, int]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *int, err error) {
+			switch ID {
+			case 0:
+				return test_utils.IntPointer(0), nil
+			case 1:
+				return nil, ErrNotFound
+			default:
+				return nil, nil
+			}
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	// cached zero value
+	for i := 0; i < 2; i++ {
+		value := c.Get(0)
+		assert.NotNil(t, value)
+		assert.Equal(t, 0, *value)
+	}
+
+	// not found
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, c.Get(1))
+		assert.Nil(t, c.Get(2))
+	}
+}
+
+func testCacheSetTimeouts(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.ReloadInterval = 1 * time.Second
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	invalidTimeouts := timeouts
+	invalidTimeouts.TTL = 0
+	assert.NotNil(t, c.SetTimeouts(invalidTimeouts))
+
+	// 0s
+	_ = c.Get(0) // next reload at 1s
+	newTimeouts := timeouts
+	newTimeouts.ReloadInterval = 300 * time.Millisecond
+	assert.Nil(t, c.SetTimeouts(newTimeouts))
+	time.Sleep(500 * time.Millisecond)
+	// 0.5s (old reload interval still applies)
+	_ = c.Get(0)
+	assert.Equal(t, int64(1), loadCounter.Load())
+	time.Sleep(600 * time.Millisecond)
+	// 1.1s (reloaded, next reload at 1.4s)
+	_ = c.Get(0)
+	assert.Equal(t, int64(2), loadCounter.Load())
+	time.Sleep(400 * time.Millisecond)
+	// 1.5s
+	_ = c.Get(0)
+	assert.Equal(t, int64(3), loadCounter.Load())
+}
+
+func testCacheFreshnessStats(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 3 {
+				return nil, errors.New("adhoc error")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, FreshnessStats{}, c.FreshnessStats())
+
+	// 0s
+	_ = c.Get(0)
+	time.Sleep(300 * time.Millisecond)
+	// 0.3s
+	_ = c.Get(1)
+	_ = c.Get(3) // failed load is not included
+	time.Sleep(300 * time.Millisecond)
+	// 0.6s
+	_ = c.Get(2)
+
+	stats := c.FreshnessStats()
+	assert.Equal(t, 3, stats.Entries)
+	assert.InDelta(t, 600*time.Millisecond, stats.Oldest, float64(50*time.Millisecond))
+	assert.InDelta(t, 0, stats.Newest, float64(50*time.Millisecond))
+	assert.InDelta(t, 300*time.Millisecond, stats.Average, float64(50*time.Millisecond))
+}
+
+func testCacheIterator(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID%2 == 1 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		_ = c.Get(i)
+	}
+
+	// not found entries are skipped
+	values := map[int]string{}
+	for ID, value := range c.All() {
+		values[ID] = *value
+	}
+	assert.Equal(t, map[int]string{0: "value_0", 2: "value_2", 4: "value_4"}, values)
+
+	// entries removed during iteration are skipped
+	iterations := 0
+	for ID := range c.All() {
+		iterations++
+		for i := 0; i < 6; i++ {
+			if i != ID {
+				c.Remove(i)
+			}
+		}
+	}
+	assert.Equal(t, 1, iterations)
+
+	// early break
+	_ = c.Get(2)
+	_ = c.Get(4)
+	iterations = 0
+	for range c.All() {
+		iterations++
+		break
+	}
+	assert.Equal(t, 1, iterations)
+}
+
+func testCacheEntryReuse(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID%2 == 1 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		value := c.Get(i)
+		c.Invalidate(i)
+		c.Touch(i)
+		if i%2 == 0 {
+			assert.Equal(t, "value_"+strconv.Itoa(i), *value)
+			c.Remove(i)
+		} else {
+			assert.Nil(t, value)
+		}
+	}
+
+	// reused entries do not leak state of removed entries
+	for _, info := range c.Dump() {
+		assert.Equal(t, 1, info.Key%2)
+		assert.False(t, info.HasValue)
+	}
+
+	// only cache map holds references of entries
+	c.mu.RLock()
+	for _, entry := range c.data {
+		assert.Equal(t, int32(1), entry.refs.Load())
+	}
+	c.mu.RUnlock()
+}
+
+func testCachePreload(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+	preloadChan := make(chan LoadedEntry[int, string])
+
+	c, err := NewCache(Params[int, string]{
+		Context:         context.Background(),
+		Log:             test_utils.Logger(),
+		MetricsRegistry: test_utils.Metrics("preload"),
+		Name:            "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			return test_utils.StringPointer("loaded"), nil
+		},
+		PreloadChan:     preloadChan,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	preloaded := 10
+	for i := 0; i < preloaded; i++ {
+		preloadChan <- LoadedEntry[int, string]{ID: i, Value: test_utils.StringPointer("preloaded")}
+	}
+	close(preloadChan)
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Preloads == uint64(preloaded)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(preloaded), testutil.ToFloat64(c.metrics.PreloadCount))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.AutomaticLoadCount))
+
+	for i := 0; i < preloaded; i++ {
+		assert.Equal(t, "preloaded", *c.Get(i))
+	}
+	assert.Equal(t, int64(0), loadCounter.Load())
+}
+
+func testCachePreloadWait(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(preloadWait time.Duration, loadCounter *atomic.Int64) (*Cache[int, string], chan LoadedEntry[int, string]) {
+		preloadChan := make(chan LoadedEntry[int, string])
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				loadCounter.Add(1)
+				return test_utils.StringPointer("loaded"), nil
+			},
+			PreloadChan:     preloadChan,
+			PreloadWait:     preloadWait,
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		})
+		assert.Nil(t, err)
+
+		return c, preloadChan
+	}
+
+	// read waits for the entry to be preloaded
+	loadCounter := atomic.Int64{}
+	c, preloadChan := newCache(time.Second, &loadCounter)
+	value := make(chan *string)
+	go func() {
+		value <- c.Get(0)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	preloadChan <- LoadedEntry[int, string]{ID: 0, Value: test_utils.StringPointer("preloaded")}
+	close(preloadChan)
+
+	assert.Equal(t, "preloaded", *<-value)
+	assert.Equal(t, int64(0), loadCounter.Load())
+
+	// entry loaded by read after waiting is not replaced by preloading
+	loadCounter = atomic.Int64{}
+	c, preloadChan = newCache(10*time.Millisecond, &loadCounter)
+	assert.Equal(t, "loaded", *c.Get(0))
+
+	preloadChan <- LoadedEntry[int, string]{ID: 0, Value: test_utils.StringPointer("preloaded")}
+	preloadChan <- LoadedEntry[int, string]{ID: 1, Value: test_utils.StringPointer("preloaded")}
+	close(preloadChan)
+	assert.Eventually(t, func() bool {
+		return c.Stats().Preloads == 2
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, "loaded", *c.Get(0))
+	assert.Equal(t, "preloaded", *c.Get(1))
+	assert.Equal(t, int64(1), loadCounter.Load())
+}
+
+func testCacheInvalidateAndWait(t *testing.T) {
+	t.Parallel()
+
+	var data atomic.Pointer[string]
+	data.Store(test_utils.StringPointer("value0"))
+	loadDelay := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			time.Sleep(time.Duration(loadDelay.Load()))
+			return data.Load(), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	// not cached entry is loaded
+	value, err := c.InvalidateAndWait(0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "value0", *value)
+
+	// updated data are returned and cached
+	data.Store(test_utils.StringPointer("value1"))
+	assert.Equal(t, "value0", *c.Get(0))
+	value, err = c.InvalidateAndWait(0, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", *value)
+	assert.Equal(t, "value1", *c.Get(0))
+
+	// slow reload times out, but finishes in the background
+	data.Store(test_utils.StringPointer("value2"))
+	loadDelay.Store(int64(200 * time.Millisecond))
+	value, err = c.InvalidateAndWait(0, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Nil(t, value)
+	assert.Eventually(t, func() bool {
+		return *c.Get(0) == "value2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func testCacheReadOnly(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID%2 == 1 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	r := c.ReadOnly()
+
+	// view reads data of the underlying cache
+	value, ok := r.Peek(0)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+	assert.False(t, r.IsCached(0))
+
+	assert.Equal(t, "value_0", *r.Get(0))
+	assert.Nil(t, r.Get(1))
+	assert.Equal(t, 2, r.Len())
+	assert.True(t, r.IsCached(1))
+	value, ok = r.Peek(0)
+	assert.True(t, ok)
+	assert.Equal(t, "value_0", *value)
+	_, ok = r.Peek(1)
+	assert.False(t, ok)
+
+	c.Remove(0)
+	assert.False(t, r.IsCached(0))
+	assert.Equal(t, 1, r.Len())
+
+	// view does not expose mutators
+	viewType := reflect.TypeOf(r)
+	for _, mutator := range []string{"Remove", "Invalidate", "InvalidateAndWait", "Set", "Touch", "SetTimeouts"} {
+		_, exists := reflect.TypeOf(c).MethodByName(mutator)
+		assert.True(t, exists, mutator)
+		_, exists = viewType.MethodByName(mutator)
+		assert.False(t, exists, mutator)
+	}
+}
+
+func testCacheShouldNegativeCache(t *testing.T) {
+	t.Parallel()
+
+	loads := map[int]int{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads[ID]++
+			return nil, ErrNotFound
+		},
+		ShouldNegativeCache: func(ID int) bool {
+			return ID != 1
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, c.Get(0))
+		assert.Nil(t, c.Get(1))
+	}
+
+	// not found entry is negative cached
+	assert.Equal(t, 1, loads[0])
+	assert.True(t, c.IsCached(0))
+
+	// designated entry is loaded on every read
+	assert.Equal(t, 3, loads[1])
+	assert.False(t, c.IsCached(1))
+}
+
+func testCacheNegativeCompaction(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.NegativeCompaction = 500 * time.Millisecond
+	var failing atomic.Bool
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if failing.Load() {
+				return nil, errors.New("load failed")
+			}
+			if ID < 100 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	for i := 0; i <= 100; i++ {
+		_ = c.Get(i)
+	}
+	assert.Equal(t, 101, c.Len())
+
+	// not found entry accessed after its load is kept
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, c.Get(0))
+
+	// entry whose reload failed is kept as well
+	failing.Store(true)
+	c.automaticReload(1)
+	failing.Store(false)
+
+	// idle not found entries are removed long before NotFoundTTL
+	assert.Eventually(t, func() bool {
+		return c.Len() == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, c.IsCached(0))
+	assert.True(t, c.IsCached(1))
+	assert.True(t, c.IsCached(100))
+	assert.Equal(t, uint64(98), c.Stats().CompactedEntries)
+}
+
+func testCachePostLoad(t *testing.T) {
+	t.Parallel()
+
+	loads := 0
+	errInvalid := errors.New("invalid value")
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads++
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			for _, ID := range IDs {
+				loads++
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value_" + strconv.Itoa(ID))})
+			}
+			return
+		},
+		PostLoad: func(ID int, value *string, err error) (*string, error) {
+			if ID == 1 {
+				return nil, errInvalid
+			}
+			if value != nil {
+				value = test_utils.StringPointer(*value + "_checked")
+			}
+			return value, err
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	// value is replaced
+	assert.Equal(t, "value_0_checked", *c.Get(0))
+
+	// rejected value is cached as error (for ErrorTTL)
+	assert.Nil(t, c.Get(1))
+	assert.Nil(t, c.Get(1))
+	assert.Equal(t, 2, loads)
+	assert.True(t, c.IsCached(1))
+
+	// hook applies for batch loads as well
+	values := c.GetMultiple([]int{2, 3})
+	assert.Equal(t, "value_2_checked", *values[2])
+	assert.Equal(t, "value_3_checked", *values[3])
+}
+
+func testCacheGetWithMeta(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+
+	assert.Nil(t, err)
+
+	// first read loads the entry
+	before := time.Now().Truncate(time.Millisecond)
+	value, meta := c.GetWithMeta(0)
+	assert.Equal(t, "value", *value)
+	assert.False(t, meta.FromCache)
+	assert.True(t, meta.Accessed)
+	assert.False(t, meta.LoadedAt.Before(before))
+	assert.Equal(t, meta.LoadedAt.Add(cacheTestTimeouts.ReloadInterval), meta.NextReload)
+	loadedAt := meta.LoadedAt
+
+	// cache hit keeps load time
+	time.Sleep(10 * time.Millisecond)
+	value, meta = c.GetWithMeta(0)
+	assert.Equal(t, "value", *value)
+	assert.True(t, meta.FromCache)
+	assert.Equal(t, loadedAt, meta.LoadedAt)
+
+	// reload updates load time
+	c.Invalidate(0)
+	value, meta = c.GetWithMeta(0)
+	assert.Equal(t, "value", *value)
+	assert.False(t, meta.FromCache)
+	assert.True(t, meta.LoadedAt.After(loadedAt))
+}
+
+func testCacheEvents(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		EventsBuffer:    4,
+	})
+
+	assert.Nil(t, err)
+
+	// load -> reload -> evict
+	_ = c.Get(0)
+	c.Invalidate(0)
+	_ = c.Get(0)
+	c.Remove(0)
+
+	expected := []EventType{EventLoad, EventInvalidate, EventReload, EventEvict}
+	var previous time.Time
+	for _, eventType := range expected {
+		event := <-c.Events()
+		assert.Equal(t, eventType, event.Type)
+		assert.Equal(t, 0, event.Key)
+		assert.False(t, event.Time.Before(previous))
+		previous = event.Time
+	}
+
+	// events over buffer are dropped without blocking
+	for i := 1; i <= 6; i++ {
+		_ = c.Get(i)
+	}
+	assert.Len(t, c.Events(), 4)
+	assert.Equal(t, uint64(2), c.Stats().DroppedEvents)
+
+	// events are disabled by default
+	c, err = NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+	_ = c.Get(0)
+	assert.Nil(t, c.Events())
+}
+
+func BenchmarkCacheGetParallel(b *testing.B) {
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     zerolog.Nop(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	maxID := 100
+	for id := 0; id < maxID; id++ {
+		_ = c.Get(id)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id := rand.Intn(maxID)
+		for pb.Next() {
+			_ = c.Get(id)
+			id = (id + 1) % maxID
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+}
+
+// BenchmarkCacheMapParallel compares lookup of entries in map guarded by RWMutex
+// (used by the cache) with `sync.Map` under parallel reads with given share of
+// writes (misses and expirations storing or deleting keys).
+func BenchmarkCacheMapParallel(b *testing.B) {
+	const maxID = 100
+	entry := &cachedEntry[string]{}
+
+	for _, writePercent := range []int{0, 1, 10} {
+		b.Run("rwmutex_map/writes_"+strconv.Itoa(writePercent), func(b *testing.B) {
+			var mu sync.RWMutex
+			data := make(map[int]*cachedEntry[string], maxID)
+			for id := range maxID {
+				data[id] = entry
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				id := rand.Intn(maxID)
+				for i := 0; pb.Next(); i++ {
+					id = (id + 1) % maxID
+					if i%100 < writePercent {
+						mu.Lock()
+						delete(data, id)
+						data[id] = entry
+						mu.Unlock()
+						continue
+					}
+
+					mu.RLock()
+					_ = data[id]
+					mu.RUnlock()
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+		})
+
+		b.Run("sync_map/writes_"+strconv.Itoa(writePercent), func(b *testing.B) {
+			var data sync.Map
+			for id := range maxID {
+				data.Store(id, entry)
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				id := rand.Intn(maxID)
+				for i := 0; pb.Next(); i++ {
+					id = (id + 1) % maxID
+					if i%100 < writePercent {
+						data.Delete(id)
+						data.Store(id, entry)
+						continue
+					}
+
+					_, _ = data.Load(id)
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+		})
+	}
+}
+
+func BenchmarkCacheGetCold(b *testing.B) {
+	value := "value"
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     zerolog.Nop(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return &value, nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// every read is a miss, removed entries are reused
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c.Get(i)
+		c.Remove(i)
+	}
+}
+
+type testClock struct {
+	now atomic.Int64 // nanoseconds
+}
+
+func (tc *testClock) Now() time.Time {
+	return time.Unix(0, tc.now.Load())
+}
+
+func (tc *testClock) Advance(d time.Duration) {
+	tc.now.Add(int64(d))
+}
+
+func testCacheClock(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// entry is not reloaded before reload interval passes
+	clock.Advance(cacheTestTimeouts.ReloadInterval - time.Millisecond)
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// entry is reloaded after reload interval without waiting
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	_, meta := c.GetWithMeta(0)
+	assert.Equal(t, clock.Now().Truncate(time.Millisecond), meta.LoadedAt)
+}
+
+func testCacheGetStale(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+	loadCounter := atomic.Int64{}
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if loadCounter.Add(1) > 1 {
+				<-release
+				return test_utils.StringPointer("reloaded"), nil
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+
+	assert.Nil(t, err)
+
+	// cold entry is not loaded
+	value, ok := c.GetStale(0)
+	assert.Nil(t, value)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), loadCounter.Load())
+	assert.False(t, c.IsCached(0))
+
+	assert.Equal(t, "value", *c.Get(0))
+
+	// expired entry is returned immediately and reloaded in the background
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	value, ok = c.GetStale(0)
+	assert.True(t, ok)
+	assert.Equal(t, "value", *value)
+	assert.Eventually(t, func() bool {
+		return loadCounter.Load() == 2
+	}, time.Second, time.Millisecond)
+
+	// reads do not wait for the reload in progress
+	value, ok = c.GetStale(0)
+	assert.True(t, ok)
+	assert.Equal(t, "value", *value)
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		value, _ := c.GetStale(0)
+		return *value == "reloaded"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), loadCounter.Load())
+}
+
+func testCacheDuplicateMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := test_utils.Metrics("duplicate_metrics")
+	newCache := func(name string) error {
+		_, err := NewCache(Params[int, string]{
+			Context:         context.Background(),
+			Log:             test_utils.Logger(),
+			MetricsRegistry: registry,
+			Name:            name,
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		})
+		return err
+	}
+
+	// only one of caches with the same name registers its metrics
+	var created atomic.Int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := newCache("test_cache1")
+			if err == nil {
+				created.Add(1)
+				return
+			}
+			assert.ErrorIs(t, err, ErrMetricsRegistered)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), created.Load())
+
+	assert.Nil(t, newCache("test_cache2"))
+}
+
+func testCacheReloadAt(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+	start := clock.Now()
+	loads := map[int]int{}
+	var mu sync.Mutex
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			mu.Lock()
+			loads[ID]++
+			mu.Unlock()
+			return test_utils.StringPointer(strconv.Itoa(ID)), nil
+		},
+		// value specifies number of seconds after start when it should be reloaded
+		ReloadAt: func(ID int, value *string) time.Time {
+			seconds, _ := strconv.Atoi(*value)
+			if seconds == 0 {
+				return time.Time{}
+			}
+			return start.Add(time.Duration(seconds) * time.Second)
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	getAll := func() map[int]int {
+		for ID := 0; ID <= 2; ID++ {
+			_ = c.Get(ID)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(loads)
+	}
+
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1}, getAll())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 1}, getAll())
+
+	clock.Advance(time.Second)
+	// reload time in the past reloads on every read
+	assert.Equal(t, map[int]int{0: 1, 1: 3, 2: 2}, getAll())
+
+	// zero time falls back to reload interval
+	clock.Advance(cacheTestTimeouts.ReloadInterval - 2*time.Second)
+	assert.Equal(t, map[int]int{0: 2, 1: 4, 2: 3}, getAll())
+}
+
+func testCacheInFlight(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			<-release
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			<-release
+			for _, ID := range IDs {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+			}
+			return
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, c.InFlight())
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = c.Get(0)
+	}()
+	go func() {
+		defer wg.Done()
+		_ = c.GetMultiple([]int{1, 2})
+	}()
+
+	// keys are listed while their loads are blocked
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]int{0, 1, 2}, slices.Sorted(slices.Values(c.InFlight())))
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	wg.Wait()
+	assert.Empty(t, c.InFlight())
+}
+
+func testCacheWatcherLen(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadAllEntries,
+	})
+	assert.Nil(t, err)
+
+	keys := 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				ID := rand.Intn(keys)
+				if rand.Intn(3) == 0 {
+					c.Remove(ID)
+				} else {
+					_ = c.Get(ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// watchers track cached entries (a few may leak because of races with removal)
+	tolerance := keys / 10
+	assert.InDelta(t, c.Len(), c.TTLWatcherLen(), float64(tolerance))
+	assert.InDelta(t, c.Len(), c.ReloadWatcherLen(), float64(tolerance))
+
+	for ID := 0; ID < keys; ID++ {
+		c.Remove(ID)
+	}
+	assert.Equal(t, 0, c.TTLWatcherLen())
+	assert.Equal(t, 0, c.ReloadWatcherLen())
+}
+
+func TestWatcherPushedAgain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := newWatcher[int]()
+	expired := w.popper(ctx, time.Millisecond)
+
+	w.Push(1, 0)
+	assert.Equal(t, 1, <-expired)
+	assert.Equal(t, 0, w.Len())
+
+	// previous generation of item pushed again expires without removing the new one
+	w.Push(1, time.Hour)
+	w.prison.Push(watchedItem[int]{ID: 1, generation: 1}, 0)
+	w.Push(2, 0)
+	assert.Equal(t, 2, <-expired)
+	assert.Equal(t, 1, w.Len())
+
+	w.Drop(1)
+	w.Drop(1)
+	assert.Equal(t, 0, w.Len())
+}
+
+func testCacheErrorRetry(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+	loadCounter := atomic.Int64{}
+
+	timeouts := cacheTestTimeouts
+	timeouts.ErrorRetryInterval = 100 * time.Millisecond
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if loadCounter.Add(1) <= 3 {
+				return nil, errors.New("load failed")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Get(0))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// no retry before retry interval passes
+	clock.Advance(50 * time.Millisecond)
+	assert.Nil(t, c.Get(0))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// failed loads are retried by reads every retry interval
+	for _, expectedLoads := range []int64{2, 3} {
+		clock.Advance(50 * time.Millisecond)
+		assert.Nil(t, c.Get(0))
+		assert.Nil(t, c.Get(0))
+		assert.Equal(t, expectedLoads, loadCounter.Load())
+
+		clock.Advance(50 * time.Millisecond)
+		assert.Nil(t, c.Get(0))
+		assert.Equal(t, expectedLoads, loadCounter.Load())
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, int64(4), loadCounter.Load())
+
+	// successful load resolves error state
+	clock.Advance(timeouts.ErrorRetryInterval)
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, int64(4), loadCounter.Load())
+}
+
+func testCacheValidKey(t *testing.T) {
+	t.Parallel()
+
+	var loadedIDs []int
+	var mu sync.Mutex
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			mu.Lock()
+			loadedIDs = append(loadedIDs, ID)
+			mu.Unlock()
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			mu.Lock()
+			loadedIDs = append(loadedIDs, IDs...)
+			mu.Unlock()
+			for _, ID := range IDs {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+			}
+			return
+		},
+		ValidKey: func(ID int) bool {
+			return ID >= 0
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// invalid keys are neither loaded nor cached
+	assert.Nil(t, c.Get(-1))
+	assert.False(t, c.IsCached(-1))
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value")}, c.GetMultiple([]int{-2, 1}))
+	assert.False(t, c.IsCached(-2))
+
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, []int{1, 0}, loadedIDs)
+}
+
+func testCacheTopKeys(t *testing.T) {
+	t.Parallel()
+
+	errGone := errors.New("gone")
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 1 {
+				return nil, errors.New("load failed")
+			}
+			if ID == 2 {
+				return nil, errGone
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		ClassifyError: func(err error) ErrorClass {
+			if errors.Is(err, errGone) {
+				return ErrorClassNotFound
+			}
+			return DefaultClassifyError(err)
+		},
+		TopKeys:         20,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// hot keys are read among many keys read once
+	hotReads := map[int]int{0: 300, 1: 200, 2: 100}
+	for i := 0; i < 300; i++ {
+		for ID, reads := range hotReads {
+			if i < reads {
+				_ = c.Get(ID)
+			}
+		}
+		_ = c.Get(1000 + i)
+	}
+
+	top := c.TopKeys(3)
+	assert.Equal(t, 3, len(top))
+	for i, ID := range []int{0, 1, 2} {
+		assert.Equal(t, ID, top[i].Key)
+		assert.GreaterOrEqual(t, top[i].Count, uint64(hotReads[ID]))
+		assert.LessOrEqual(t, top[i].Count-top[i].Error, uint64(hotReads[ID]))
+	}
+
+	// hits, misses and errors are counted for tracked keys
+	c.Invalidate(1)
+	_ = c.Get(1)
+	top = c.TopKeys(3)
+	assert.Equal(t, uint64(1), top[0].Misses)
+	assert.Equal(t, uint64(299), top[0].Hits)
+	assert.Equal(t, uint64(1), top[1].Errors)
+	assert.Equal(t, uint64(0), top[0].Errors)
+	// errors classified as not found are not failures
+	errorLoads := c.Stats().ErrorLoads
+	c.Invalidate(2)
+	_ = c.Get(2)
+	assert.Equal(t, uint64(0), c.TopKeys(3)[2].Errors)
+	assert.Equal(t, errorLoads, c.Stats().ErrorLoads)
+
+	assert.Equal(t, 20, len(c.TopKeys(100)))
+	assert.Nil(t, c.TopKeys(0))
+	assert.Nil(t, c.TopKeys(-1))
+}
+
+func testCacheRetainValueOnNotFound(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			if ID == 0 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("deleted"), ErrNotFound
+		},
+		RetainValueOnNotFound: true,
+		Timeouts:              cacheTestTimeouts,
+		AutomaticReload:       AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// tombstone is cached and served without reloading
+	assert.Equal(t, "deleted", *c.Get(1))
+	assert.Equal(t, "deleted", *c.Get(1))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// not found without value stays nil
+	assert.Nil(t, c.Get(0))
+	assert.True(t, c.IsCached(0))
+	assert.Equal(t, int64(2), loadCounter.Load())
+}
+
+func testCacheWaitForKey(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 0 {
+				return nil, ErrNotFound
+			}
+			<-release
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// waiting is cancelled while the load is in progress
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	value, err := c.WaitForKey(ctx, 1)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// slow load in progress is waited for
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	value, err = c.WaitForKey(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", *value)
+
+	value, err = c.WaitForKey(context.Background(), 0)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testCacheTryGet(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 0 {
+				return nil, ErrNotFound
+			}
+			<-release
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// cold key is loaded in the background
+	value, status := c.TryGet(1)
+	assert.Nil(t, value)
+	assert.Equal(t, StatusLoading, status)
+	value, status = c.TryGet(1)
+	assert.Nil(t, value)
+	assert.Equal(t, StatusLoading, status)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		_, status = c.TryGet(1)
+		return status == StatusReady
+	}, time.Second, 10*time.Millisecond)
+	value, status = c.TryGet(1)
+	assert.Equal(t, "value", *value)
+	assert.Equal(t, StatusReady, status)
+
+	// not found entry is absent once loaded
+	_, _ = c.TryGet(0)
+	assert.Eventually(t, func() bool {
+		_, status = c.TryGet(0)
+		return status == StatusAbsent
+	}, time.Second, 10*time.Millisecond)
+}
+
+func testCachePause(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: Timeouts{
+			TTL:            5 * time.Second,
+			NotFoundTTL:    time.Second,
+			ReloadInterval: 300 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadAllEntries,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value", *c.Get(1))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	c.Pause()
+	assert.True(t, c.Paused())
+
+	// nothing is loaded while paused (automatic reload would be due meanwhile)
+	assert.Nil(t, c.Get(2))
+	assert.False(t, c.IsCached(2))
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, "value", *c.Get(1))
+	assert.Equal(t, map[int]*string{1: test_utils.StringPointer("value")}, c.GetMultiple([]int{1, 2}))
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	// postponed reload catches up after resume
+	c.Resume()
+	assert.False(t, c.Paused())
+	assert.Eventually(t, func() bool {
+		return loadCounter.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "value", *c.Get(2))
+	assert.Equal(t, int64(3), loadCounter.Load())
+}
+
+func testCachePermanentError(t *testing.T) {
+	t.Parallel()
+
+	errBadRequest := errors.New("bad request")
+	loadCounter := atomic.Int64{}
+
+	timeouts := cacheTestTimeouts
+	timeouts.PermanentErrorTTL = 0
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loadCounter.Add(1)
+			switch ID {
+			case 0:
+				return nil, ErrNotFound
+			case 1:
+				return nil, fmt.Errorf("invalid ID: %w", errBadRequest)
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		ClassifyError: func(err error) ErrorClass {
+			if errors.Is(err, errBadRequest) {
+				return ErrorClassPermanent
+			}
+			return DefaultClassifyError(err)
+		},
+		PermanentErrorTTL: func(err error) (time.Duration, bool) {
+			if errors.Is(err, errBadRequest) {
+				return 300 * time.Millisecond, true
+			}
+			return 0, false
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// error is cached for its own TTL and returned as loaded
+	value, err := c.GetWithError(1)
+	assert.Nil(t, value)
+	assert.EqualError(t, err, "invalid ID: bad request")
+	assert.ErrorIs(t, err, errBadRequest)
+	value, err = c.GetWithError(1)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, errBadRequest)
+	assert.Equal(t, int64(1), loadCounter.Load())
+
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(1)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	value, err = c.GetWithError(0)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	value, err = c.GetWithError(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", *value)
+}
+
+func testCacheSkipCancelledLoads(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			// first load waits for cancellation by the caller, fourth load times out
+			switch loadCounter.Add(1) {
+			case 1:
+				close(started)
+				<-reqCtx.Done()
+				return nil, fmt.Errorf("load: %w", reqCtx.Err())
+			case 4:
+				return nil, context.DeadlineExceeded
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		SkipCancelledLoads: true,
+		Timeouts:           cacheTestTimeouts,
+		AutomaticReload:    AutomaticReloadDisabled,
+		Clock:              clock,
+	})
+	assert.Nil(t, err)
+
+	// load cancelled by the caller is not cached as error entry
+	done := make(chan *string)
+	go func() {
+		done <- c.Get(1)
+	}()
+	<-started
+	cancel()
+	assert.Nil(t, <-done)
+	assert.False(t, c.IsCached(1))
+
+	assert.Equal(t, "value", *c.Get(1))
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	// expired entry stays expired after cancelled reload
+	assert.Equal(t, "value", *c.Get(2))
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	assert.Equal(t, "value", *c.Get(2))
+	assert.Equal(t, int64(4), loadCounter.Load())
+	assert.Equal(t, "value", *c.Get(2))
+	assert.Equal(t, int64(5), loadCounter.Load())
+}
+
+func testCacheNormalizeKey(t *testing.T) {
+	t.Parallel()
+
+	var loadedIDs []string
+	var mu sync.Mutex
+
+	c, err := NewCache(Params[string, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID string) (entry *string, err error) {
+			mu.Lock()
+			loadedIDs = append(loadedIDs, ID)
+			mu.Unlock()
+			return test_utils.StringPointer("value " + ID), nil
+		},
+		NormalizeKey: func(ID string) string {
+			return strings.ToLower(strings.TrimSpace(ID))
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value foo", *c.Get("Foo"))
+	assert.Equal(t, "value foo", *c.Get("foo"))
+	assert.Equal(t, "value foo", *c.Get(" FOO "))
+	assert.Equal(t, []string{"foo"}, loadedIDs)
+	assert.Equal(t, 1, c.Len())
+	assert.True(t, c.IsCached("fOo"))
+
+	assert.Equal(t, map[string]*string{"foo": test_utils.StringPointer("value foo")}, c.GetMultiple([]string{"FOO"}))
+
+	assert.Nil(t, c.Set("Bar", test_utils.StringPointer("bar")))
+	assert.Equal(t, "bar", *c.Get("bar"))
+
+	c.Remove("FOO")
+	assert.False(t, c.IsCached("foo"))
+	assert.Equal(t, []string{"foo"}, loadedIDs)
+}
+
+func testCacheOnHealthChange(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	errLoad := errors.New("load error")
+	results := []error{nil, errLoad, errLoad, nil, nil, errLoad}
+	loadCounter := atomic.Int64{}
+
+	var changes []bool
+	var mu sync.Mutex
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if err = results[loadCounter.Add(1)-1]; err != nil {
+				return nil, err
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		OnHealthChange: func(ID int, healthy bool) {
+			mu.Lock()
+			changes = append(changes, healthy)
+			mu.Unlock()
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	for range results {
+		assert.Equal(t, "value", *c.Get(0))
+		clock.Advance(cacheTestTimeouts.ReloadInterval)
+	}
+	assert.Equal(t, int64(len(results)), loadCounter.Load())
+
+	// only transitions are reported
+	assert.Equal(t, []bool{false, true, false}, changes)
+}
+
+func testCachePreloadResults(t *testing.T) {
+	t.Parallel()
+
+	errLoad := errors.New("load error")
+	preloadChan := make(chan LoadedEntry[int, string], 4)
+	preloadResults := make(chan PreloadResult[int, string], 4)
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("loaded"), nil
+		},
+		PreloadChan:     preloadChan,
+		PreloadResults:  preloadResults,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "loaded", *c.Get(0))
+
+	// error entry does not replace cached value, failures are reported with error
+	preloadChan <- LoadedEntry[int, string]{ID: 0, Err: errLoad}
+	preloadChan <- LoadedEntry[int, string]{ID: 0, Value: test_utils.StringPointer("preloaded")}
+	preloadChan <- LoadedEntry[int, string]{ID: 1, Value: test_utils.StringPointer("preloaded")}
+	preloadChan <- LoadedEntry[int, string]{ID: 2, Err: errLoad}
+	close(preloadChan)
+
+	var results []PreloadResult[int, string]
+	for result := range preloadResults {
+		results = append(results, result)
+	}
+	assert.Equal(t, []PreloadResult[int, string]{
+		{Entry: LoadedEntry[int, string]{ID: 0, Err: errLoad}, Status: PreloadFailed, Err: errLoad},
+		{Entry: LoadedEntry[int, string]{ID: 0, Value: test_utils.StringPointer("preloaded")}, Status: PreloadSkipped},
+		{Entry: LoadedEntry[int, string]{ID: 1, Value: test_utils.StringPointer("preloaded")}, Status: PreloadStored},
+		{Entry: LoadedEntry[int, string]{ID: 2, Err: errLoad}, Status: PreloadFailed, Err: errLoad},
+	}, results)
+
+	assert.Equal(t, "loaded", *c.Get(0))
+	assert.Equal(t, "preloaded", *c.Get(1))
+}
+
+func testCacheHookPanics(t *testing.T) {
+	t.Parallel()
+
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			switch ID {
+			case 0:
+				// first automatic reload panics
+				if loadCounter.Add(1) == 2 {
+					panic("load panic")
+				}
+			case 1:
+				panic("load panic")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		ClassifyError: func(err error) ErrorClass {
+			panic("classify panic")
+		},
+		OnHealthChange: func(ID int, healthy bool) {
+			panic("health panic")
+		},
+		NormalizeKey: func(ID int) int {
+			if ID == 3 {
+				panic("normalize panic")
+			}
+			return ID
+		},
+		ValidKey: func(ID int) bool {
+			if ID == 4 {
+				panic("valid key panic")
+			}
+			return true
+		},
+		ReloadAt: func(ID int, value *string) time.Time {
+			panic("reload at panic")
+		},
+		Timeouts: Timeouts{
+			TTL:            5 * time.Second,
+			NotFoundTTL:    time.Second,
+			ErrorTTL:       time.Second,
+			ReloadInterval: 300 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadAllEntries,
+	})
+	assert.Nil(t, err)
+
+	// panicking loader does not leave the entry locked
+	assert.Nil(t, c.Get(1))
+	assert.Nil(t, c.Get(1))
+	value, err := c.GetWithError(1)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, ErrCallbackPanic)
+
+	// key which cannot be normalized is used as is, key which cannot be validated
+	// is rejected
+	assert.Equal(t, "value", *c.Get(3))
+	assert.True(t, c.IsCached(3))
+	assert.Nil(t, c.Get(4))
+	assert.False(t, c.IsCached(4))
+
+	// automatic reloads continue after panic in reload watcher
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Eventually(t, func() bool {
+		return loadCounter.Load() >= 3
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "value", *c.Get(0))
+}
+
+func testCacheMaxStaleness(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	errBackend := errors.New("backend down")
+	loadCounter := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			// backend goes down after first load
+			if loadCounter.Add(1) > 1 {
+				return nil, errBackend
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			for _, ID := range IDs {
+				loadCounter.Add(1)
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Err: errBackend})
+			}
+			return
+		},
+		MaxStaleness:    5 * time.Second,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	value, err := c.GetWithError(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", *value)
+
+	// batch read of cached value is a hit
+	stats := c.Stats()
+	assert.Equal(t, map[int]*string{0: test_utils.StringPointer("value")}, c.GetMultiple([]int{0}))
+	assert.Equal(t, stats.Reads+1, c.Stats().Reads)
+	assert.Equal(t, stats.Hits+1, c.Stats().Hits)
+
+	// stale value is served while it is not too old
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	value, err = c.GetWithError(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", *value)
+	assert.Equal(t, int64(2), loadCounter.Load())
+
+	// too old value is not served anymore
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	value, err = c.GetWithError(0)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, errBackend)
+	assert.Nil(t, c.Get(0))
+	assert.Equal(t, int64(3), loadCounter.Load())
+
+	// neither by batch read (entry is not reloaded before its retry)
+	stats = c.Stats()
+	assert.Empty(t, c.GetMultiple([]int{0}))
+	assert.Equal(t, int64(3), loadCounter.Load())
+	assert.Equal(t, stats.Hits+1, c.Stats().Hits)
+
+	// nor when its batch reload fails
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	stats = c.Stats()
+	assert.Empty(t, c.GetMultiple([]int{0}))
+	assert.Equal(t, int64(4), loadCounter.Load())
+	assert.Equal(t, stats.Misses+1, c.Stats().Misses)
+
+	// value stays cached
+	value, cached := c.Peek(0)
+	assert.True(t, cached)
+	assert.Equal(t, "value", *value)
+}
+
+func testCacheCollectors(t *testing.T) {
+	t.Parallel()
+
+	newParams := func(name string) Params[int, string] {
+		return Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    name,
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		}
+	}
+
+	// cache without metrics has no collectors
+	c, err := NewCache(newParams("collectors_none"))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Collectors())
+
+	// collectors of cache with cadre registry can be registered elsewhere
+	params := newParams("collectors_cadre")
+	params.MetricsRegistry = test_utils.Metrics("collectors_cadre")
+	c, err = NewCache(params)
+	assert.Nil(t, err)
+	registry := prometheus.NewRegistry()
+	for _, collector := range c.Collectors() {
+		assert.Nil(t, registry.Register(collector))
+	}
+	_ = c.Get(0)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.ReadsCount))
+	count, err := testutil.GatherAndCount(registry)
+	assert.Nil(t, err)
+	assert.Equal(t, len(c.Collectors()), count)
+
+	// metrics registered into plain registerer
+	registerer := prometheus.NewRegistry()
+	params = newParams("collectors_plain")
+	params.MetricsRegisterer = registerer
+	c, err = NewCache(params)
+	assert.Nil(t, err)
+	_ = c.Get(0)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.ReadsCount))
+	count, err = testutil.GatherAndCount(registerer, "lazy_cache_reads_count")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = NewCache(params)
+	assert.ErrorIs(t, err, ErrMetricsRegistered)
+
+	// both registries cannot be set
+	params.MetricsRegistry = test_utils.Metrics("collectors_both")
+	_, err = NewCache(params)
+	assert.ErrorIs(t, err, ErrInvalidParams)
+}
+
+func testCacheGetFairness(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	var loads atomic.Int64
+	var release chan struct{}
+	var reloadAt atomic.Int64 // reload time returned for loaded values (0 if not set)
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			load := loads.Add(1)
+			if release != nil {
+				<-release
+			}
+			return test_utils.StringPointer(fmt.Sprintf("value_%d", load)), nil
+		},
+		ReloadAt: func(ID int, value *string) time.Time {
+			if at := reloadAt.Load(); at != 0 {
+				return time.UnixMilli(at)
+			}
+			return time.Time{}
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	routines := 200
+	getExpired := func() []string {
+		release = make(chan struct{})
+		defer func() {
+			release = nil
+		}()
+
+		values := make([]string, routines)
+		var started sync.WaitGroup
+		var wg sync.WaitGroup
+		for i := 0; i < routines; i++ {
+			started.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				started.Done()
+				values[i] = *c.Get(0)
+			}()
+		}
+		// let all routines wait for the load
+		started.Wait()
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		return values
+	}
+
+	assert.Equal(t, "value_1", *c.Get(0))
+
+	// all readers of expired entry get the value of one load
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	for _, value := range getExpired() {
+		assert.Equal(t, "value_2", value)
+	}
+	assert.Equal(t, int64(2), loads.Load())
+
+	// the same holds when loaded value expires right away
+	reloadAt.Store(clock.Now().UnixMilli())
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	for _, value := range getExpired() {
+		assert.Equal(t, "value_3", value)
+	}
+	assert.Equal(t, int64(3), loads.Load())
+	assert.Equal(t, uint64(2*(routines-1)), c.Stats().DedupedLoads)
+}
+
+func testCachePin(t *testing.T) {
+	t.Parallel()
+
+	var loads sync.Map // ID -> *atomic.Int64
+	loadCount := func(ID int) int64 {
+		counter, _ := loads.LoadOrStore(ID, &atomic.Int64{})
+		return counter.(*atomic.Int64).Load()
+	}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			counter, _ := loads.LoadOrStore(ID, &atomic.Int64{})
+			counter.(*atomic.Int64).Add(1)
+			return test_utils.StringPointer("value_" + strconv.Itoa(ID)), nil
+		},
+		Timeouts: Timeouts{
+			TTL:            800 * time.Millisecond,
+			NotFoundTTL:    400 * time.Millisecond,
+			ReloadInterval: 300 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadAccessedEntries,
+		MaxEntries:      2,
+		PinnedKeys:      []int{0},
+	})
+	assert.Nil(t, err)
+	assert.True(t, c.Pinned(0))
+	assert.False(t, c.Pinned(1))
+
+	assert.Equal(t, "value_0", *c.Get(0))
+	assert.Equal(t, "value_1", *c.Get(1))
+
+	// pinned entry is not evicted although it is least recently accessed
+	time.Sleep(2 * time.Millisecond)
+	_ = c.Get(1)
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, "value_2", *c.Get(2))
+	assert.True(t, c.IsCached(0))
+	assert.False(t, c.IsCached(1))
+
+	// pinned entry outlives its TTL and keeps reloading (not pinned one expires)
+	time.Sleep(2 * time.Second)
+	assert.True(t, c.IsCached(0))
+	assert.False(t, c.IsCached(2))
+	assert.GreaterOrEqual(t, loadCount(0), int64(4))
+
+	// unpinned entry expires
+	c.Unpin(0)
+	assert.False(t, c.Pinned(0))
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(0)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func testCacheFallbacks(t *testing.T) {
+	t.Parallel()
+
+	var primaryLoads, replicaLoads, defaultLoads atomic.Int64
+	var healthChanges atomic.Int64
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		// primary fails for all keys
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			primaryLoads.Add(1)
+			return nil, errors.New("primary unavailable")
+		},
+		Fallbacks: []LoadOneFunc[int, string]{
+			// replica knows only even keys and key 3 does not exist
+			func(ID int) (entry *string, err error) {
+				replicaLoads.Add(1)
+				if ID == 3 {
+					return nil, ErrNotFound
+				}
+				if ID%2 == 1 {
+					return nil, errors.New("replica lagging")
+				}
+				return test_utils.StringPointer("replica_" + strconv.Itoa(ID)), nil
+			},
+			func(ID int) (entry *string, err error) {
+				defaultLoads.Add(1)
+				if ID == 5 {
+					return nil, errors.New("no default")
+				}
+				return test_utils.StringPointer("default"), nil
+			},
+		},
+		OnHealthChange: func(ID int, healthy bool) {
+			healthChanges.Add(1)
+		},
+		MetricsRegisterer: prometheus.NewRegistry(),
+		Timeouts:          cacheTestTimeouts,
+		AutomaticReload:   AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	// value of the fallback is cached without error outcome
+	value, err := c.GetWithError(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "replica_0", *value)
+	value, meta := c.GetWithMeta(0)
+	assert.Equal(t, "replica_0", *value)
+	assert.True(t, meta.FromCache)
+	assert.Nil(t, meta.Err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.ErrorLoadCount))
+	assert.Equal(t, int64(0), healthChanges.Load())
+	assert.Equal(t, int64(1), primaryLoads.Load())
+	assert.Equal(t, int64(1), replicaLoads.Load())
+	assert.Equal(t, int64(0), defaultLoads.Load())
+
+	// the last fallback is used when others fail
+	assert.Equal(t, "default", *c.Get(1))
+	assert.Equal(t, int64(1), defaultLoads.Load())
+
+	// not found stops the chain
+	_, err = c.GetWithError(3)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int64(1), defaultLoads.Load())
+
+	// error of the last loader is recorded when all fail
+	_, err = c.GetWithError(5)
+	assert.EqualError(t, err, "no default")
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.ErrorLoadCount))
+	assert.Equal(t, int64(1), healthChanges.Load())
+}
+
+func testCacheReloadChanges(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	var value atomic.Pointer[string]
+	value.Store(test_utils.StringPointer("value"))
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			// every load returns new pointer
+			return test_utils.StringPointer(*value.Load()), nil
+		},
+		Equal: func(old, new *string) bool {
+			return *old == *new
+		},
+		MetricsRegisterer: prometheus.NewRegistry(),
+		Timeouts:          cacheTestTimeouts,
+		AutomaticReload:   AutomaticReloadDisabled,
+		Clock:             clock,
+	})
+	assert.Nil(t, err)
+
+	// first load is not a reload
+	assert.Equal(t, "value", *c.Get(0))
+	assert.Equal(t, uint64(0), c.Stats().UnchangedReloads)
+
+	// identical values are counted as unchanged
+	for i := 1; i <= 3; i++ {
+		clock.Advance(cacheTestTimeouts.ReloadInterval)
+		assert.Equal(t, "value", *c.Get(0))
+		assert.Equal(t, uint64(i), c.Stats().UnchangedReloads)
+	}
+	assert.Equal(t, float64(3), testutil.ToFloat64(c.metrics.UnchangedReloadCount))
+
+	// different value is counted as changed
+	value.Store(test_utils.StringPointer("changed"))
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	assert.Equal(t, "changed", *c.Get(0))
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.ChangedReloads)
+	assert.Equal(t, uint64(3), stats.UnchangedReloads)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.ChangedReloadCount))
+
+	// writes are not reloads
+	assert.Nil(t, c.Set(0, test_utils.StringPointer("set")))
+	assert.Equal(t, stats, c.Stats())
+}
+
+func testCacheInsertAfterLoad(t *testing.T) {
+	t.Parallel()
+
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	releaseTryGet := make(chan struct{})
+	tryGetLoads := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 0 {
+				close(loading)
+				<-release
+				return nil, errors.New("storage unavailable")
+			}
+			if ID == 3 {
+				tryGetLoads.Add(1)
+				<-releaseTryGet
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: Timeouts{
+			TTL:            7 * time.Second,
+			NotFoundTTL:    5 * time.Second,
+			ReloadInterval: 3 * time.Second,
+			// errors are not cached
+			ErrorTTL: 0,
+		},
+		AutomaticReload: AutomaticReloadDisabled,
+		InsertAfterLoad: true,
+	})
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, c.Get(0))
+	}()
+
+	// entry being loaded is not visible
+	<-loading
+	assert.Equal(t, []int{0}, c.InFlight())
+	assert.Equal(t, 0, c.Len())
+	assert.False(t, c.IsCached(0))
+
+	// failed load is not cached
+	close(release)
+	<-done
+	assert.Equal(t, 0, c.Len())
+	assert.False(t, c.IsCached(0))
+
+	// successful load is cached
+	value, meta := c.GetWithMeta(1)
+	assert.Equal(t, "value", *value)
+	assert.False(t, meta.FromCache)
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, 1, c.TTLWatcherLen())
+	value, meta = c.GetWithMeta(1)
+	assert.Equal(t, "value", *value)
+	assert.True(t, meta.FromCache)
+
+	// background load of TryGet inserts the entry when it finishes
+	value, status := c.TryGet(2)
+	assert.Nil(t, value)
+	assert.Equal(t, StatusLoading, status)
+	assert.Eventually(t, func() bool {
+		_, status := c.TryGet(2)
+		return status == StatusReady
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, c.Len())
+
+	// burst of TryGet starts only one load of the key
+	for range 10 {
+		_, status = c.TryGet(3)
+		assert.Equal(t, StatusLoading, status)
+	}
+	close(releaseTryGet)
+	assert.Eventually(t, func() bool {
+		_, status := c.TryGet(3)
+		return status == StatusReady
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), tryGetLoads.Load())
+}
+
+func testCacheLoadHooks(t *testing.T) {
+	t.Parallel()
+
+	type spanKey struct{}
+	type traceKey struct{}
+
+	// recorder of hook calls (span is started for every load and ended by end hook)
+	var mu sync.Mutex
+	started := map[int]int{}
+	ended := map[int]int{}
+	traces := map[string]int{} // trace of context passed to start hook -> number of loads
+	onLoadStart := func(ctx context.Context, ID int) context.Context {
+		mu.Lock()
+		defer mu.Unlock()
+
+		started[ID]++
+		trace, _ := ctx.Value(traceKey{}).(string)
+		traces[trace]++
+		if ID == 13 {
+			panic("tracer failed")
+		}
+		return context.WithValue(ctx, spanKey{}, ID)
+	}
+	onLoadEnd := func(ctx context.Context, ID int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		span, _ := ctx.Value(spanKey{}).(int)
+		if ID == 13 {
+			// context of the read is used when start hook panics
+			span = ID
+		}
+		assert.Equal(t, ID, span)
+		ended[ID]++
+	}
+	balanced := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return maps.Equal(started, ended)
+	}
+
+	clock := &testClock{}
+	clock.now.Store(time.Now().UnixNano())
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) []LoadedEntry[int, string] {
+			// the last ID is not found
+			entries := make([]LoadedEntry[int, string], 0, len(IDs))
+			for _, ID := range IDs[:len(IDs)-1] {
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+			}
+			return entries
+		},
+		OnLoadStart:     onLoadStart,
+		OnLoadEnd:       onLoadEnd,
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		Clock:           clock,
+	})
+	assert.Nil(t, err)
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "request")
+
+	// cold load
+	assert.Equal(t, "value", *c.GetWithContext(ctx, 0))
+	// cached value is not loaded
+	assert.Equal(t, "value", *c.GetWithContext(ctx, 0))
+	// expired entry
+	clock.Advance(cacheTestTimeouts.ReloadInterval)
+	assert.Equal(t, "value", *c.GetWithContext(ctx, 0))
+	// synchronous reload
+	_, err = c.InvalidateAndWait(0, time.Second)
+	assert.Nil(t, err)
+	// batch load
+	assert.Len(t, c.GetMultiple([]int{1, 2, 3}), 2)
+	// panicking start hook does not break the load
+	assert.Equal(t, "value", *c.GetWithContext(ctx, 13))
+
+	assert.True(t, balanced())
+	assert.Equal(t, map[int]int{0: 3, 1: 1, 2: 1, 3: 1, 13: 1}, started)
+	assert.Equal(t, map[string]int{"request": 3, "": 4}, traces)
+
+	// automatic reload
+	c, err = NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache2",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		OnLoadStart: onLoadStart,
+		OnLoadEnd:   onLoadEnd,
+		Timeouts: Timeouts{
+			TTL:            800 * time.Millisecond,
+			NotFoundTTL:    400 * time.Millisecond,
+			ReloadInterval: 300 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadAllEntries,
+	})
+	assert.Nil(t, err)
+
+	_ = c.Get(100)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return started[100] >= 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, balanced, time.Second, time.Millisecond)
+}
+
+func testCacheClone(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		Name string
+		Tags []string
+	}
+
+	newCache := func(clone func(value *record) *record) *Cache[int, record] {
+		c, err := NewCache(Params[int, record]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *record, err error) {
+				return &record{Name: "name", Tags: []string{"tag"}}, nil
+			},
+			LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, record]) {
+				for _, ID := range IDs {
+					entries = append(entries, LoadedEntry[int, record]{ID: ID, Value: &record{Name: "name", Tags: []string{"tag"}}})
+				}
+				return
+			},
+			Clone:           clone,
+			Timeouts:        cacheTestTimeouts,
+			AutomaticReload: AutomaticReloadDisabled,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	c := newCache(func(value *record) *record {
+		return &record{Name: value.Name, Tags: slices.Clone(value.Tags)}
+	})
+
+	// mutation of returned value does not affect cached value
+	value := c.Get(0)
+	value.Name = "mutated"
+	value.Tags[0] = "mutated"
+	assert.Equal(t, &record{Name: "name", Tags: []string{"tag"}}, c.Get(0))
+	assert.NotSame(t, c.Get(0), c.Get(0))
+	peeked, _ := c.Peek(0)
+	assert.Equal(t, "name", peeked.Name)
+
+	// values returned by batch read are cloned as well (loaded and cached ones)
+	for range 2 {
+		values := c.GetMultiple([]int{0, 1})
+		values[0].Name = "mutated"
+		values[1].Tags[0] = "mutated"
+	}
+	assert.Equal(t, &record{Name: "name", Tags: []string{"tag"}}, c.Get(0))
+	assert.Equal(t, &record{Name: "name", Tags: []string{"tag"}}, c.Get(1))
+
+	// without clone the value is shared
+	c = newCache(nil)
+	value = c.Get(0)
+	value.Name = "mutated"
+	assert.Equal(t, "mutated", c.Get(0).Name)
+}
+
+func testCacheMaxBackgroundLoads(t *testing.T) {
+	t.Parallel()
+
+	const limit = 2
+	release := make(chan struct{})
+	running := atomic.Int64{}
+	maxRunning := atomic.Int64{}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+
+			<-release
+			return test_utils.StringPointer("value" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:           cacheTestTimeouts,
+		AutomaticReload:    AutomaticReloadDisabled,
+		MaxBackgroundLoads: limit,
+	})
+	assert.Nil(t, err)
+
+	// burst of background loads
+	for ID := range 20 {
+		_, status := c.TryGet(ID)
+		assert.Equal(t, StatusLoading, status)
+	}
+
+	assert.Eventually(t, func() bool {
+		stats := c.Stats()
+		return stats.RunningBackgroundLoads == limit && stats.QueuedBackgroundLoads == 20-limit
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(limit), running.Load())
+
+	// queued loads are run once routines are free
+	close(release)
+	assert.Eventually(t, func() bool {
+		stats := c.Stats()
+		return stats.RunningBackgroundLoads == 0 && stats.QueuedBackgroundLoads == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.LessOrEqual(t, maxRunning.Load(), int64(limit))
+
+	for ID := range 20 {
+		value, status := c.TryGet(ID)
+		assert.Equal(t, StatusReady, status)
+		assert.Equal(t, "value"+strconv.Itoa(ID), *value)
+	}
+}
+
+func testCacheGraceEntries(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	var version atomic.Int64
+	var blocked atomic.Bool
+	release := make(chan struct{})
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			if blocked.Load() {
+				<-release
+			}
+			return test_utils.StringPointer(fmt.Sprintf("value%d_v%d", ID, version.Load())), nil
+		},
+		Timeouts: Timeouts{
+			TTL:            400 * time.Millisecond,
+			NotFoundTTL:    200 * time.Millisecond,
+			ErrorTTL:       200 * time.Millisecond,
+			ReloadInterval: 200 * time.Millisecond,
+		},
+		AutomaticReload: AutomaticReloadDisabled,
+		GraceEntries:    1,
+	})
+	assert.Nil(t, err)
+
+	// ring keeps only the most recently expired entry (0)
+	assert.Equal(t, "value1_v0", *c.Get(1))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "value0_v0", *c.Get(0))
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(0) && !c.IsCached(1)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), loads.Load())
+
+	// just expired key is served from grace ring without waiting for its load
+	version.Store(1)
+	blocked.Store(true)
+	start := time.Now()
+	assert.Equal(t, "value0_v0", *c.Get(0))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().GraceHits)
+	assert.True(t, c.IsCached(0))
+
+	// the entry is revalidated in the background
+	assert.Eventually(t, func() bool {
+		return loads.Load() == 3
+	}, time.Second, 10*time.Millisecond)
+	blocked.Store(false)
+	close(release)
+	assert.Equal(t, "value0_v1", *c.Get(0))
+	assert.Equal(t, int64(3), loads.Load())
+
+	// key dropped from the ring is loaded cold
+	assert.Equal(t, "value1_v1", *c.Get(1))
+	assert.Equal(t, int64(4), loads.Load())
+	assert.Equal(t, uint64(1), c.Stats().GraceHits)
+
+	// explicitly removed entries are not kept
+	c.Remove(0)
+	assert.Equal(t, "value0_v1", *c.Get(0))
+	assert.Equal(t, int64(5), loads.Load())
+	assert.Equal(t, uint64(1), c.Stats().GraceHits)
+}
+
+func testCacheMetricsSnapshot(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			switch ID {
+			case 0:
+				return nil, ErrNotFound
+			case 1:
+				return nil, errors.New("load failed")
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	snapshot := c.MetricsSnapshot()
+	assert.Equal(t, float64(0), snapshot["items_count"])
+	assert.Equal(t, float64(0), snapshot["reads_count"])
+
+	_ = c.Get(0)
+	_ = c.Get(1)
+	_ = c.Get(2)
+	_ = c.Get(2)
+	_ = c.Get(2)
+	_, _ = c.TryGet(2)
+
+	snapshot = c.MetricsSnapshot()
+	assert.Equal(t, float64(3), snapshot["items_count"])
+	assert.Equal(t, float64(6), snapshot["reads_count"])
+	assert.Equal(t, float64(2), snapshot["hits"])
+	assert.Equal(t, float64(3), snapshot["misses"])
+	assert.Equal(t, float64(3), snapshot["lazy_loads"])
+	assert.Equal(t, float64(0), snapshot["automatic_loads"])
+	assert.Equal(t, float64(1), snapshot["error_loads"])
+	assert.Contains(t, snapshot, "memory_usage")
+
+	c.Remove(2)
+	assert.Equal(t, float64(2), c.MetricsSnapshot()["items_count"])
+}
+
+func testCacheAccessCount(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(decay bool) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer("value"), nil
+			},
+			Timeouts:          cacheTestTimeouts,
+			AutomaticReload:   AutomaticReloadDisabled,
+			DecayAccessCounts: decay,
+		})
+		assert.Nil(t, err)
+		return c
+	}
+	accessCount := func(c *Cache[int, string], ID int) uint64 {
+		for _, info := range c.Dump() {
+			if info.Key == ID {
+				return info.AccessCount
+			}
+		}
+		return 0
+	}
+	reload := func(c *Cache[int, string], ID int) {
+		_, err := c.InvalidateAndWait(ID, time.Second)
+		assert.Nil(t, err)
+	}
+
+	// reads since last load are counted (the read which loaded the entry is not)
+	c := newCache(false)
+	for range 4 {
+		_ = c.Get(0)
+	}
+	assert.Equal(t, uint64(3), accessCount(c, 0))
+	_, meta := c.GetWithMeta(0)
+	assert.Equal(t, uint64(4), meta.AccessCount)
+	_, _ = c.TryGet(0)
+	assert.Equal(t, uint64(4), accessCount(c, 0))
+
+	// reload clears the count
+	reload(c, 0)
+	assert.Equal(t, uint64(0), accessCount(c, 0))
+	_ = c.Get(0)
+	assert.Equal(t, uint64(1), accessCount(c, 0))
+
+	// reloads halve decaying counts
+	c = newCache(true)
+	for range 9 {
+		_ = c.Get(0)
+	}
+	assert.Equal(t, uint64(8), accessCount(c, 0))
+	reload(c, 0)
+	assert.Equal(t, uint64(4), accessCount(c, 0))
+	_ = c.Get(0)
+	_ = c.Get(0)
+	reload(c, 0)
+	assert.Equal(t, uint64(3), accessCount(c, 0))
+	reload(c, 0)
+	reload(c, 0)
+	assert.Equal(t, uint64(0), accessCount(c, 0))
+}
+
+func testCacheFatalError(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			if ID == 13 {
+				return nil, fmt.Errorf("%w: invalid credentials", ErrFatal)
+			}
+			return test_utils.StringPointer("value" + strconv.Itoa(ID)), nil
+		},
+		Timeouts:          cacheTestTimeouts,
+		AutomaticReload:   AutomaticReloadDisabled,
+		MetricsRegisterer: prometheus.NewRegistry(),
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "value0", *c.Get(0))
+	assert.Nil(t, c.FatalError())
+	assert.Equal(t, float64(0), testutil.ToFloat64(c.metrics.LoadsDisabled))
+
+	// fatal error disables loading
+	_, err = c.GetWithError(13)
+	assert.ErrorIs(t, err, ErrFatal)
+	assert.ErrorIs(t, c.FatalError(), ErrFatal)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.LoadsDisabled))
+	assert.Equal(t, float64(1), c.MetricsSnapshot()["loads_disabled"])
+	assert.Equal(t, int64(2), loads.Load())
+
+	// cold reads return the fatal error without calling the loader
+	assert.Nil(t, c.Get(1))
+	value, err := c.GetWithError(1)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, ErrFatal)
+	_, status := c.TryGet(2)
+	assert.Equal(t, StatusAbsent, status)
+	assert.False(t, c.IsCached(1))
+
+	// cached entries are served, but not reloaded
+	assert.Equal(t, "value0", *c.Get(0))
+	value, err = c.InvalidateAndWait(0, time.Second)
+	assert.Equal(t, "value0", *value)
+	assert.ErrorIs(t, err, ErrFatal)
+	_, err = c.GetWithError(13)
+	assert.ErrorIs(t, err, ErrFatal)
+	assert.Equal(t, int64(2), loads.Load())
+}
+
+func testCacheOnTTLAssigned(t *testing.T) {
+	t.Parallel()
+
+	type assignment struct {
+		ID             int
+		ttl            time.Duration
+		reloadInterval time.Duration
+	}
+	var mu sync.Mutex
+	var assignments []assignment
+
+	timeouts := cacheTestTimeouts
+	timeouts.Randomizer = 0.2
+
+	preloadChan := make(chan LoadedEntry[int, string], 1)
+	preloadChan <- LoadedEntry[int, string]{ID: 100, Value: test_utils.StringPointer("preloaded")}
+	close(preloadChan)
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		PreloadChan:     preloadChan,
+		OnTTLAssigned: func(ID int, ttl, reloadInterval time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			assignments = append(assignments, assignment{ID: ID, ttl: ttl, reloadInterval: reloadInterval})
+		},
+	})
+	assert.Nil(t, err)
+
+	// cold loads, reloads and sets
+	for ID := range 50 {
+		_ = c.Get(ID)
+	}
+	for ID := range 10 {
+		_, err = c.InvalidateAndWait(ID, time.Second)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, c.Set(200, test_utils.StringPointer("set")))
+	assert.Eventually(t, func() bool {
+		return c.IsCached(100)
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Len(t, assignments, 62)
+	IDs := map[int]int{}
+	ttls := map[time.Duration]struct{}{}
+	for _, a := range assignments {
+		IDs[a.ID]++
+		ttls[a.ttl] = struct{}{}
+		assert.GreaterOrEqual(t, a.ttl, 5600*time.Millisecond)
+		assert.LessOrEqual(t, a.ttl, 8400*time.Millisecond)
+		assert.GreaterOrEqual(t, a.reloadInterval, 2400*time.Millisecond)
+		assert.LessOrEqual(t, a.reloadInterval, 3600*time.Millisecond)
+	}
+	assert.Equal(t, 2, IDs[0])
+	assert.Equal(t, 1, IDs[10])
+	assert.Equal(t, 1, IDs[100])
+	assert.Equal(t, 1, IDs[200])
+	// durations are randomized
+	assert.Greater(t, len(ttls), 1)
+}
+
+type notFoundReasonError struct {
+	reason string
+}
+
+func (e notFoundReasonError) Error() string {
+	return "not found: " + e.reason
+}
+
+func (e notFoundReasonError) Unwrap() error {
+	return ErrNotFound
+}
+
+func testCacheNotFoundReason(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			switch ID {
+			case 0:
+				return nil, ErrNotFound
+			case 1:
+				return nil, notFoundReasonError{reason: "deleted"}
+			case 2:
+				return nil, fmt.Errorf("access denied: %w", ErrNotFound)
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+	})
+	assert.Nil(t, err)
+
+	for range 2 {
+		_, err = c.GetWithError(0)
+		assert.Equal(t, ErrNotFound, err)
+
+		// reason survives caching of the not found entry
+		_, err = c.GetWithError(1)
+		assert.ErrorIs(t, err, ErrNotFound)
+		var reasonErr notFoundReasonError
+		assert.ErrorAs(t, err, &reasonErr)
+		assert.Equal(t, "deleted", reasonErr.reason)
+
+		_, err = c.GetWithError(2)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.EqualError(t, err, "access denied: not found")
+	}
+	assert.Equal(t, int64(3), loads.Load())
+
+	_, meta := c.GetWithMeta(1)
+	assert.Equal(t, notFoundReasonError{reason: "deleted"}, meta.Err)
+}
+
+func testCacheCoalesceColdLoads(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "test_cache1",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:          cacheTestTimeouts,
+		AutomaticReload:   AutomaticReloadDisabled,
+		InsertAfterLoad:   true,
+		CoalesceColdLoads: time.Second,
+	})
+	assert.Nil(t, err)
+
+	// burst of reads of one cold key
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 1000 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			value := c.Get(0)
+			if assert.NotNil(t, value) {
+				assert.Equal(t, "value", *value)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), loads.Load())
+	assert.Equal(t, 1, c.Len())
+}
+
+func testCacheConfigAccessors(t *testing.T) {
+	t.Parallel()
+
+	timeouts := cacheTestTimeouts
+	timeouts.Randomizer = 0.1
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "config_cache",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        timeouts,
+		AutomaticReload: AutomaticReloadAccessedEntries,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "config_cache", c.Name())
+	assert.Equal(t, AutomaticReloadAccessedEntries, c.AutomaticReloadMode())
+	assert.Equal(t, timeouts, c.Timeouts())
+
+	readOnly := c.ReadOnly()
+	assert.Equal(t, "config_cache", readOnly.Name())
+	assert.Equal(t, AutomaticReloadAccessedEntries, readOnly.AutomaticReloadMode())
+	assert.Equal(t, timeouts, readOnly.Timeouts())
+
+	// timeouts changed at runtime are returned
+	timeouts.TTL = 10 * time.Second
+	assert.Nil(t, c.SetTimeouts(timeouts))
+	assert.Equal(t, timeouts, c.Timeouts())
+}
+
+func testCacheSlowLoadThreshold(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	slow := func(ID int) {
+		if ID == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     zerolog.New(zerolog.SyncWriter(buf)),
+		Name:    "slow_load_threshold",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			slow(ID)
+			return test_utils.StringPointer("value"), nil
+		},
+		LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+			for _, ID := range IDs {
+				slow(ID)
+				entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+			}
+			return entries
+		},
+		Timeouts:          cacheTestTimeouts,
+		SlowLoadThreshold: 20 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+
+	// fast load is not logged
+	assert.Equal(t, "value", *c.Get(0))
+	assert.NotContains(t, buf.String(), "slow load")
+
+	assert.Equal(t, "value", *c.Get(1))
+	assert.Contains(t, buf.String(), `"level":"warn","cache":"slow_load_threshold","kind":"one"`)
+	assert.Contains(t, buf.String(), `"key":1,"message":"slow load"`)
+
+	// batch load is logged with number of keys
+	assert.Len(t, c.GetMultiple([]int{2, 3, 1}), 3)
+	c.Remove(1)
+	assert.Len(t, c.GetMultiple([]int{4, 1}), 2)
+	assert.Contains(t, buf.String(), `"kind":"multiple"`)
+	assert.Contains(t, buf.String(), `"keys":2,"message":"slow load"`)
+	assert.Equal(t, 2, strings.Count(buf.String(), "slow load"))
+}
+
+func testCacheInvalidateWithTTL(t *testing.T) {
+	t.Parallel()
+
+	var ttls []time.Duration
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "invalidate_with_ttl",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: cacheTestTimeouts,
+		OnTTLAssigned: func(ID int, ttl, reloadInterval time.Duration) {
+			ttls = append(ttls, ttl)
+		},
+	})
+	assert.Nil(t, err)
+
+	// not cached entry is not affected
+	c.InvalidateWithTTL(1, time.Second)
+	assert.False(t, c.IsCached(1))
+
+	assert.Equal(t, "value", *c.Get(1))
+	c.InvalidateWithTTL(1, 2*time.Second)
+	assert.Equal(t, "value", *c.Get(1))
+	// override is used only once
+	c.Invalidate(1)
+	assert.Equal(t, "value", *c.Get(1))
+
+	assert.Equal(t, []time.Duration{cacheTestTimeouts.TTL, 2 * time.Second, cacheTestTimeouts.TTL}, ttls)
+}
+
+func testCacheHealth(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "health",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if failing.Load() {
+				return nil, errors.New("backend unavailable")
+			}
+			if ID < 0 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:     cacheTestTimeouts,
+		HealthWindow: 4,
+	})
+	assert.Nil(t, err)
+
+	// cache without loads is healthy
+	assert.Equal(t, HealthStatus{Healthy: true}, c.Health())
+
+	// not found entries are successful loads
+	ID := 0
+	_ = c.Get(-1)
+	assert.Equal(t, HealthStatus{Healthy: true, Loads: 1}, c.Health())
+
+	failing.Store(true)
+	for range 3 {
+		ID++
+		assert.Nil(t, c.Get(ID))
+	}
+	assert.Equal(t, HealthStatus{Loads: 4, Failures: 3, ErrorRate: 0.75}, c.Health())
+	assert.False(t, c.ReadOnly().Health().Healthy)
+
+	// cache recovers when successes push failures out of the window
+	failing.Store(false)
+	ID++
+	_ = c.Get(ID)
+	assert.Equal(t, HealthStatus{Loads: 4, Failures: 3, ErrorRate: 0.75}, c.Health())
+	ID++
+	_ = c.Get(ID)
+	assert.Equal(t, HealthStatus{Healthy: true, Loads: 4, Failures: 2, ErrorRate: 0.5}, c.Health())
+	for range 2 {
+		ID++
+		_ = c.Get(ID)
+	}
+	assert.Equal(t, HealthStatus{Healthy: true, Loads: 4}, c.Health())
+
+	// reading cached entries does not load them
+	_ = c.Get(ID)
+	assert.Equal(t, 4, c.Health().Loads)
+}
+
+func testCacheExpireAt(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	expirations := map[int]time.Time{
+		1: now.Add(300 * time.Millisecond),
+		2: now.Add(600 * time.Millisecond),
+		4: now.Add(-time.Second),
+	}
+
+	var mu sync.Mutex
+	reloadIntervals := make(map[int]time.Duration)
+	ttls := make(map[int]time.Duration)
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "expire_at",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: cacheTestTimeouts,
+		ExpireAt: func(ID int, value *string) (time.Time, bool) {
+			at, exists := expirations[ID]
+			return at, exists
+		},
+		OnTTLAssigned: func(ID int, ttl, reloadInterval time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			ttls[ID] = ttl
+			reloadIntervals[ID] = reloadInterval
+		},
+	})
+	assert.Nil(t, err)
+
+	for ID := 1; ID <= 4; ID++ {
+		assert.Equal(t, "value", *c.Get(ID))
+	}
+
+	mu.Lock()
+	// entries are reloaded before their absolute expiration
+	assert.InDelta(t, 300*time.Millisecond, ttls[1], float64(50*time.Millisecond))
+	assert.InDelta(t, 270*time.Millisecond, reloadIntervals[1], float64(50*time.Millisecond))
+	assert.InDelta(t, 600*time.Millisecond, ttls[2], float64(50*time.Millisecond))
+	assert.InDelta(t, 540*time.Millisecond, reloadIntervals[2], float64(50*time.Millisecond))
+	// default timeouts are used without absolute expiration
+	assert.Equal(t, cacheTestTimeouts.TTL, ttls[3])
+	assert.Equal(t, cacheTestTimeouts.ReloadInterval, reloadIntervals[3])
+	mu.Unlock()
+
+	// expired value is not cached
+	assert.False(t, c.IsCached(4))
+
+	// entries expire at their absolute times
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(1)
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, c.IsCached(2))
+	assert.Eventually(t, func() bool {
+		return !c.IsCached(2)
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, c.IsCached(3))
+}
+
+func testCacheWriteJSON(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	c, err := NewCache(Params[int, record]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "write_json",
+		LoadOneFunc: func(ID int) (entry *record, err error) {
+			if ID < 0 {
+				return nil, ErrNotFound
+			}
+			return &record{Name: fmt.Sprintf("record%d", ID), Count: ID}, nil
+		},
+		Timeouts: cacheTestTimeouts,
+	})
+	assert.Nil(t, err)
+
+	// empty cache is written as empty object
+	buf := &bytes.Buffer{}
+	assert.Nil(t, c.WriteJSON(buf, nil))
+	assert.Equal(t, "{}", buf.String())
+
+	for _, ID := range []int{1, 2, 3, -1} {
+		_ = c.Get(ID)
+	}
+
+	buf.Reset()
+	assert.Nil(t, c.WriteJSON(buf, nil))
+	var dumped map[string]record
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &dumped))
+	assert.Equal(t, map[string]record{
+		"1": {Name: "record1", Count: 1},
+		"2": {Name: "record2", Count: 2},
+		"3": {Name: "record3", Count: 3},
+	}, dumped)
+
+	// keys are converted by given function
+	buf.Reset()
+	assert.Nil(t, c.WriteJSON(buf, func(ID int) string {
+		return "key" + strconv.Itoa(ID)
+	}))
+	dumped = nil
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &dumped))
+	assert.Len(t, dumped, 3)
+	assert.Equal(t, record{Name: "record2", Count: 2}, dumped["key2"])
+}
+
+func testCacheLazyFirstLoad(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int64
+	release := make(chan struct{})
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "lazy_first_load",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			loads.Add(1)
+			<-release
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:      cacheTestTimeouts,
+		LazyFirstLoad: true,
+	})
+	assert.Nil(t, err)
+
+	// reads do not wait for the first load (which blocks until released)
+	assert.Nil(t, c.Get(1))
+	assert.Nil(t, c.Get(1))
+	value, err := c.GetWithError(1)
+	assert.Nil(t, value)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Eventually(t, func() bool {
+		return loads.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		value := c.Get(1)
+		return value != nil && *value == "value"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), loads.Load())
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(3), stats.Misses)
+
+	// reloads of cached entries are not affected
+	c.Invalidate(1)
+	assert.Equal(t, "value", *c.Get(1))
+	assert.Equal(t, int64(2), loads.Load())
+}
+
+func testCacheKeysSorted(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "keys_sorted",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID < 0 {
+				return nil, ErrNotFound
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts: cacheTestTimeouts,
+	})
+	assert.Nil(t, err)
+
+	less := func(a, b int) bool {
+		return a < b
+	}
+	assert.Empty(t, c.KeysSorted(less))
+
+	for _, ID := range rand.Perm(100) {
+		_ = c.Get(ID - 10)
+	}
+
+	keys := c.KeysSorted(less)
+	assert.Len(t, keys, 100)
+	assert.True(t, slices.IsSorted(keys))
+	assert.Equal(t, -10, keys[0])
+	assert.Equal(t, 89, keys[99])
+
+	// order is given by the comparator
+	keys = c.ReadOnly().KeysSorted(func(a, b int) bool {
+		return a > b
+	})
+	assert.Equal(t, 89, keys[0])
+	assert.Equal(t, -10, keys[99])
+}
+
+func testCacheLoadLimiter(t *testing.T) {
+	t.Parallel()
+
+	var running, maxRunning atomic.Int64
+	load := func() {
+		current := running.Add(1)
+		for {
+			observed := maxRunning.Load()
+			if current <= observed || maxRunning.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+	}
+
+	// limiter is shared by both caches
+	limiter := NewLoadLimiter(2)
+	newCache := func(name string) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    name,
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				load()
+				return test_utils.StringPointer("value"), nil
+			},
+			LoadBatchFunc: func(IDs []int) (entries []LoadedEntry[int, string]) {
+				load()
+				for _, ID := range IDs {
+					entries = append(entries, LoadedEntry[int, string]{ID: ID, Value: test_utils.StringPointer("value")})
+				}
+				return entries
+			},
+			Timeouts:          cacheTestTimeouts,
+			LoadLimiter:       limiter,
+			MetricsRegisterer: prometheus.NewRegistry(),
+		})
+		assert.Nil(t, err)
+		return c
+	}
+	c1 := newCache("load_limiter1")
+	c2 := newCache("load_limiter2")
+
+	wg := sync.WaitGroup{}
+	for i := range 20 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "value", *c1.Get(i))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "value", *c2.Get(i))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Len(t, c1.GetMultiple([]int{100 + i, 200 + i}), 2)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(2), maxRunning.Load())
+	assert.Equal(t, 0, limiter.InUse())
+	assert.Equal(t, 2, limiter.Size())
+
+	stats := c1.Stats()
+	assert.Equal(t, uint64(0), stats.AcquiredLoadSlots)
+	assert.Greater(t, stats.LoadSlotsWait, time.Duration(0))
+	assert.Equal(t, float64(0), testutil.ToFloat64(c1.metrics.AcquiredLoadSlots))
+	assert.Greater(t, testutil.ToFloat64(c1.metrics.LoadSlotsWait), float64(0))
+
+	// cache with own limit
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "load_limiter_own",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			load()
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:           cacheTestTimeouts,
+		MaxConcurrentLoads: 1,
+	})
+	assert.Nil(t, err)
+
+	maxRunning.Store(0)
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "value", *c.Get(i))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), maxRunning.Load())
+}
+
+func testCacheGetWithArgs(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var loadedArgs []any
+	c, err := NewCache(Params[int, string]{
+		Context: context.Background(),
+		Log:     test_utils.Logger(),
+		Name:    "get_with_args",
+		LoadOneWithArgsFunc: func(ID int, args any) (entry *string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			loadedArgs = append(loadedArgs, args)
+
+			locale, _ := args.(string)
+			return test_utils.StringPointer(fmt.Sprintf("value%d_%s", ID, locale)), nil
+		},
+		Timeouts: cacheTestTimeouts,
+	})
+	assert.Nil(t, err)
+
+	// args are passed to load of not cached entry
+	assert.Equal(t, "value1_cs", *c.GetWithArgs(1, "cs"))
+	// args are ignored by reads of cached entry
+	assert.Equal(t, "value1_cs", *c.GetWithArgs(1, "en"))
+	assert.Equal(t, "value1_cs", *c.Get(1))
+	// reads without args load with nil args
+	assert.Equal(t, "value2_", *c.Get(2))
+	// args are passed to reload of expired entry
+	c.Invalidate(1)
+	assert.Equal(t, "value1_de", *c.ReadOnly().GetWithArgs(1, "de"))
+
+	mu.Lock()
+	assert.Equal(t, []any{"cs", nil, "de"}, loadedArgs)
+	mu.Unlock()
+}
+
+func testCacheCompact(t *testing.T) {
+	t.Parallel()
+
+	newCache := func(name string, ratio float64) *Cache[int, string] {
+		c, err := NewCache(Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    name,
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return test_utils.StringPointer(strconv.Itoa(ID)), nil
+			},
+			Timeouts:           cacheTestTimeouts,
+			MapCompactionRatio: ratio,
+			MetricsRegisterer:  prometheus.NewRegistry(),
+		})
+		assert.Nil(t, err)
+		return c
+	}
+
+	// map is rebuilt only explicitly without ratio
+	c := newCache("compact", 0)
+	for ID := range 3000 {
+		_ = c.Get(ID)
+	}
+	for ID := 10; ID < 3000; ID++ {
+		c.Remove(ID)
+	}
+	assert.Equal(t, uint64(0), c.Stats().MapCompactions)
+
+	c.Compact()
+	assert.Equal(t, uint64(1), c.Stats().MapCompactions)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.metrics.MapCompactions))
+	assert.Equal(t, 10, c.Len())
+	for ID := range 10 {
+		assert.True(t, c.IsCached(ID))
+		assert.Equal(t, strconv.Itoa(ID), *c.Get(ID))
+	}
+
+	// sparse map is rebuilt automatically
+	c = newCache("compact_ratio", 1)
+	for ID := range 2000 {
+		_ = c.Get(ID)
+	}
+	for ID := range 999 {
+		c.Remove(ID)
+	}
+	assert.Equal(t, uint64(0), c.Stats().MapCompactions)
+	// more removed entries than cached ones
+	for ID := 999; ID < 1500; ID++ {
+		c.Remove(ID)
+	}
+	assert.Eventually(t, func() bool {
+		return c.Stats().MapCompactions == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 500, c.Len())
+	assert.Equal(t, "1999", *c.Get(1999))
+}
+
+func testCacheClosed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	limiter := NewLoadLimiter(1)
+
+	c, err := NewCache(Params[int, string]{
+		Context: ctx,
+		Log:     test_utils.Logger(),
+		Name:    "closed",
+		LoadOneFunc: func(ID int) (entry *string, err error) {
+			if ID == 0 {
+				<-release
+			}
+			return test_utils.StringPointer("value"), nil
+		},
+		Timeouts:        cacheTestTimeouts,
+		AutomaticReload: AutomaticReloadDisabled,
+		LoadLimiter:     limiter,
+	})
+	assert.Nil(t, err)
+	defer close(release)
+
+	// load waiting for slot of the limiter fails when the cache is closed
+	go func() {
+		_ = c.Get(0)
+	}()
+	assert.Eventually(t, func() bool {
+		return limiter.InUse() == 1
+	}, time.Second, time.Millisecond)
+	done := make(chan error)
+	go func() {
+		_, err := c.GetWithError(1)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	err = <-done
+	assert.ErrorIs(t, err, ErrCacheClosed)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.ErrorIs(t, c.Set(2, test_utils.StringPointer("value")), ErrCacheClosed)
+	assert.False(t, c.IsCached(2))
+	_, err = c.WaitForKey(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrCacheClosed)
+	_, err = c.InvalidateAndWait(2, time.Second)
+	assert.ErrorIs(t, err, ErrCacheClosed)
+}
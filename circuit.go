@@ -0,0 +1,242 @@
+package lazy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitBreaker configures circuit breaker around loaders. After `FailureThreshold`
+// consecutive failed loads (transient errors, see `ClassifyError`) the circuit opens
+// and no loads are performed for `OpenDuration`: cached values are served (even
+// expired ones) and not cached entries are read as nil. Then one probe load is
+// allowed. When the probe succeeds the circuit closes, otherwise it opens again.
+// Automatic reloads are not performed while the circuit is open, they are postponed
+// until the circuit half-opens.
+type CircuitBreaker struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	// PerKey enables separate circuit for each entry (one cache-wide circuit is used
+	// by default). State of circuits is kept only for entries with failed loads.
+	PerKey bool
+}
+
+func (cb *CircuitBreaker) check() error {
+	if cb.FailureThreshold <= 0 {
+		return fmt.Errorf("%w: circuit breaker FailureThreshold must be positive", ErrInvalidParams)
+	}
+
+	if cb.OpenDuration <= 0 {
+		return fmt.Errorf("%w: circuit breaker OpenDuration must be positive", ErrInvalidParams)
+	}
+
+	return nil
+}
+
+type circuit struct {
+	failures atomic.Int64
+	openedAt atomic.Int64 // timestamp when circuit opened in milliseconds (0 if closed)
+	probing  atomic.Bool  // true when probe load is running
+}
+
+func (ci *circuit) allow(nowMillis int64, openDuration int64) bool {
+	openedAt := ci.openedAt.Load()
+	if openedAt == 0 {
+		return true
+	}
+
+	if nowMillis < openedAt+openDuration {
+		return false
+	}
+
+	// half-open circuit allows only one probe
+	return ci.probing.CompareAndSwap(false, true)
+}
+
+// openFor returns how long the circuit stays open (0 when it is closed or
+// half-open)
+func (ci *circuit) openFor(nowMillis int64, openDuration int64) time.Duration {
+	openedAt := ci.openedAt.Load()
+	if openedAt == 0 || nowMillis >= openedAt+openDuration {
+		return 0
+	}
+
+	return time.Duration(openedAt+openDuration-nowMillis) * time.Millisecond
+}
+
+// report reports load result and returns true when circuit is closed
+func (ci *circuit) report(failed bool, nowMillis int64, threshold int64) bool {
+	if !failed {
+		ci.failures.Store(0)
+		ci.openedAt.Store(0)
+		ci.probing.Store(false)
+		return true
+	}
+
+	failures := ci.failures.Add(1)
+	if failures >= threshold || ci.probing.Load() {
+		ci.openedAt.Store(nowMillis)
+		ci.probing.Store(false)
+	}
+
+	return false
+}
+
+type circuits[K comparable] struct {
+	threshold    int64
+	openDuration int64 // milliseconds
+	global       *circuit
+	// per key circuits (only when per key circuits are enabled)
+	mu     sync.Mutex
+	perKey map[K]*circuit
+}
+
+func newCircuits[K comparable](config *CircuitBreaker) *circuits[K] {
+	cs := &circuits[K]{
+		threshold:    int64(config.FailureThreshold),
+		openDuration: config.OpenDuration.Milliseconds(),
+	}
+
+	if config.PerKey {
+		cs.perKey = make(map[K]*circuit)
+	} else {
+		cs.global = &circuit{}
+	}
+
+	return cs
+}
+
+func (cs *circuits[K]) allow(ID K, nowMillis int64) bool {
+	if cs.global != nil {
+		return cs.global.allow(nowMillis, cs.openDuration)
+	}
+
+	cs.mu.Lock()
+	ci, exists := cs.perKey[ID]
+	cs.mu.Unlock()
+
+	if !exists {
+		return true
+	}
+
+	return ci.allow(nowMillis, cs.openDuration)
+}
+
+func (cs *circuits[K]) openFor(ID K, nowMillis int64) time.Duration {
+	if cs.global != nil {
+		return cs.global.openFor(nowMillis, cs.openDuration)
+	}
+
+	cs.mu.Lock()
+	ci, exists := cs.perKey[ID]
+	cs.mu.Unlock()
+
+	if !exists {
+		return 0
+	}
+
+	return ci.openFor(nowMillis, cs.openDuration)
+}
+
+func (cs *circuits[K]) report(ID K, failed bool, nowMillis int64) {
+	if cs.global != nil {
+		cs.global.report(failed, nowMillis, cs.threshold)
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	ci, exists := cs.perKey[ID]
+	if !exists {
+		if !failed {
+			return
+		}
+
+		ci = &circuit{}
+		cs.perKey[ID] = ci
+	}
+
+	if ci.report(failed, nowMillis, cs.threshold) {
+		delete(cs.perKey, ID)
+	}
+}
+
+// loadAllowed returns false when the cache is paused, loading is disabled by fatal
+// loader error or when circuit breaker does not allow to load the entry (skipped
+// load is counted)
+func (c *Cache[K, T]) loadAllowed(ID K, nowMillis int64) bool {
+	if c.paused.Load() || c.fatalErr.Load() != nil {
+		return false
+	}
+
+	if c.circuits == nil || c.circuits.allow(ID, nowMillis) {
+		return true
+	}
+
+	c.stats.circuitOpenSkips.Add(1)
+	if c.metrics != nil {
+		c.metrics.CircuitOpenCount.Inc()
+	}
+
+	return false
+}
+
+// reloadAllowed returns false when the cache is paused, loading is disabled by fatal
+// loader error or when circuit breaker does not allow automatic reload of the
+// entry. The reload is then rescheduled after the cache is resumed or after the
+// circuit half-opens (reloads disabled by fatal error are not rescheduled).
+func (c *Cache[K, T]) reloadAllowed(ID K, nowMillis int64) bool {
+	// loads are disabled for good
+	if c.fatalErr.Load() != nil {
+		return false
+	}
+
+	// reload is postponed until the cache is resumed
+	if c.paused.Load() {
+		c.pausedReloads.add(ID)
+		// the cache could be resumed meanwhile
+		if !c.paused.Load() {
+			c.reloadWatcher.Push(ID, 0)
+		}
+		return false
+	}
+
+	if c.circuits == nil || c.circuits.allow(ID, nowMillis) {
+		return true
+	}
+
+	retryAfter := c.circuits.openFor(ID, nowMillis)
+	// other routine is probing half-open circuit
+	if retryAfter < minAutomaticReloadDuration {
+		retryAfter = minAutomaticReloadDuration
+	}
+	c.reloadWatcher.Push(ID, retryAfter)
+
+	c.stats.circuitSkippedReloads.Add(1)
+	if c.metrics != nil {
+		c.metrics.CircuitSkippedReloadCount.Inc()
+	}
+
+	return false
+}
+
+// reportLoad reports result of entry load to circuit breaker (and key statistics
+// and health)
+func (c *Cache[K, T]) reportLoad(ID K, err error, nowMillis int64) {
+	c.trackLoad(ID, err)
+
+	failed := false
+	if err != nil {
+		failed = c.errorClass(err) == ErrorClassTransient
+	}
+
+	c.health.add(failed)
+
+	if c.circuits == nil {
+		return
+	}
+
+	c.circuits.report(ID, failed, nowMillis)
+}
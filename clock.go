@@ -0,0 +1,23 @@
+package lazy
+
+import "time"
+
+// Clock provides current time to the cache (it can be replaced e.g. by a fake
+// clock in tests). Decisions made on access of entries (reload, expiration checks,
+// statistics) use the clock. Expirations and automatic reloads scheduled by single
+// scheduler (see `Params.SingleScheduler`) follow the clock too, separate background
+// watchers of TTL and automatic reloads schedule in real time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// nowMillis returns current time of cache clock in milliseconds
+func (c *Cache[K, T]) nowMillis() int64 {
+	return c.clock.Now().UnixMilli()
+}
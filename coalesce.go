@@ -0,0 +1,81 @@
+package lazy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// detachedLoads tracks loads of entries which are not inserted into cache before
+// the load (see `Params.InsertAfterLoad`), so concurrent reads can wait for them
+// (see `Params.CoalesceColdLoads`)
+type detachedLoads[K comparable] struct {
+	mu    sync.Mutex
+	loads map[K]chan struct{} // closed when the load finishes
+}
+
+// start registers load of the entry and returns true, channel of the load already
+// in progress is returned otherwise
+func (d *detachedLoads[K]) start(ID K) (chan struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if done, loading := d.loads[ID]; loading {
+		return done, false
+	}
+
+	if d.loads == nil {
+		d.loads = make(map[K]chan struct{})
+	}
+	done := make(chan struct{})
+	d.loads[ID] = done
+
+	return done, true
+}
+
+func (d *detachedLoads[K]) finish(ID K, done chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.loads, ID)
+	close(done)
+}
+
+// waitForDetachedLoad waits (up to `CoalesceColdLoads`) for load of the entry
+// started by other routine and returns the entry cached by it (its reference has
+// to be released by the caller). When no other routine loads the entry, load of
+// this routine is registered and returned finish has to be called after the
+// entry is inserted into cache. Both are nil when the wait did not end with
+// cached entry (the entry is loaded independently then).
+func (c *Cache[K, T]) waitForDetachedLoad(ctx context.Context, ID K) (entry *cachedEntry[T], finish func()) {
+	done, started := c.detachedLoads.start(ID)
+	if started {
+		// other load could finish after the caller found the entry not cached
+		if entry, exists := c.acquireEntry(ID); exists {
+			c.detachedLoads.finish(ID, done)
+			return entry, nil
+		}
+
+		return nil, func() {
+			c.detachedLoads.finish(ID, done)
+		}
+	}
+
+	timer := time.NewTimer(c.coalesceColdLoads)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, nil
+	}
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return nil, nil
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,38 @@
+package lazy
+
+import "encoding/json"
+
+// Codec serializes keys and values of cache entries (e.g. to share them between
+// cache instances).
+type Codec[K comparable, T any] interface {
+	EncodeKey(ID K) ([]byte, error)
+	DecodeKey(data []byte) (K, error)
+	EncodeValue(value *T) ([]byte, error)
+	DecodeValue(data []byte) (*T, error)
+}
+
+// JSONCodec serializes keys and values using `encoding/json`.
+type JSONCodec[K comparable, T any] struct{}
+
+func (JSONCodec[K, T]) EncodeKey(ID K) ([]byte, error) {
+	return json.Marshal(ID)
+}
+
+func (JSONCodec[K, T]) DecodeKey(data []byte) (ID K, err error) {
+	err = json.Unmarshal(data, &ID)
+	return
+}
+
+func (JSONCodec[K, T]) EncodeValue(value *T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[K, T]) DecodeValue(data []byte) (value *T, err error) {
+	value = new(T)
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
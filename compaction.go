@@ -0,0 +1,120 @@
+package lazy
+
+import "time"
+
+func (c *Cache[K, T]) startNegativeCompaction(interval time.Duration) {
+	for {
+		timer := time.NewTimer(interval)
+
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+
+		case <-timer.C:
+			c.compactNegativeEntries()
+		}
+	}
+}
+
+// isIdleNegative returns true for not found entry which was not accessed since
+// its last load (read which triggered the load does not count). Entries whose
+// last load failed are not negative, even though they have no value.
+func (c *Cache[K, T]) isIdleNegative(entry *cachedEntry[T]) bool {
+	lastLoaded := entry.lastLoaded.Load()
+	if lastLoaded == 0 || entry.value.Load() != nil || entry.lastAccess.Load() > lastLoaded {
+		return false
+	}
+
+	// not found error can carry a reason
+	err := entry.loadErr()
+	return err == nil || c.errorClass(err) == ErrorClassNotFound
+}
+
+// compactNegativeEntries removes idle not found entries from cache
+func (c *Cache[K, T]) compactNegativeEntries() {
+	// collect candidates using read lock
+	c.mu.RLock()
+	var candidates []K
+	for ID, entry := range c.data {
+		if c.isIdleNegative(entry) && !c.pinned.has(ID) {
+			candidates = append(candidates, ID)
+		}
+	}
+	c.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	// entries could be reloaded or accessed meanwhile, so they are checked again
+	compacted := make([]K, 0, len(candidates))
+	c.mu.Lock()
+	for _, ID := range candidates {
+		entry, exists := c.data[ID]
+		if !exists || !c.isIdleNegative(entry) || c.pinned.has(ID) {
+			continue
+		}
+
+		c.deleteEntry(ID, entry)
+		compacted = append(compacted, ID)
+	}
+	c.mu.Unlock()
+
+	for _, ID := range compacted {
+		c.ttlWatcher.Drop(ID)
+		c.reloadWatcher.Drop(ID)
+		c.emitEvent(EventEvict, ID)
+	}
+
+	c.stats.compactedEntries.Add(uint64(len(compacted)))
+	if c.metrics != nil {
+		c.metrics.ItemsCount.Sub(float64(len(compacted)))
+	}
+}
+
+// minMapCompactionDeletions is minimal number of deleted entries which triggers
+// automatic rebuild of the map (see `Params.MapCompactionRatio`)
+const minMapCompactionDeletions = 1000
+
+// countMapDeletion counts entry deleted from the map and schedules its rebuild
+// when the map is sparse. The map is rebuilt by other routine, because callers
+// can iterate over it. Cache has to be locked.
+func (c *Cache[K, T]) countMapDeletion() {
+	c.mapDeletions++
+
+	if c.mapCompactionRatio == 0 || c.mapDeletions < minMapCompactionDeletions {
+		return
+	}
+
+	if float64(c.mapDeletions) <= c.mapCompactionRatio*float64(len(c.data)) {
+		return
+	}
+
+	if c.mapRebuilding.CompareAndSwap(false, true) {
+		go c.Compact()
+	}
+}
+
+// Compact rebuilds the map of cached entries, so memory of removed entries is
+// released (Go maps keep their size after entries are deleted). The cache is
+// locked during the rebuild, which takes time proportional to number of cached
+// entries. Unlike negative compaction (see `Timeouts.NegativeCompaction`), no
+// entries are removed. See `Params.MapCompactionRatio` for automatic rebuilds.
+func (c *Cache[K, T]) Compact() {
+	c.mu.Lock()
+	data := make(map[K]*cachedEntry[T], len(c.data))
+	for ID, entry := range c.data {
+		data[ID] = entry
+	}
+	c.data = data
+	c.mapDeletions = 0
+	c.mu.Unlock()
+
+	c.mapRebuilding.Store(false)
+
+	c.stats.mapCompactions.Add(1)
+	if c.metrics != nil {
+		c.metrics.MapCompactions.Inc()
+	}
+}
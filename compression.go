@@ -0,0 +1,30 @@
+package lazy
+
+// Compressible is implemented by values which can be cached in compressed form
+// (see `Params.Compress`). `Compress` is called on loaded value and returns its
+// compressed form, which is cached. `Decompress` is called on the cached form on
+// every read and returns the original value. Neither of them may modify the
+// receiver (cached value is shared by concurrent readers).
+type Compressible[T any] interface {
+	Compress() *T
+	Decompress() *T
+}
+
+// compress returns compressed form of the value (when compression is enabled)
+func (c *Cache[K, T]) compress(value *T) *T {
+	if !c.compressValues || value == nil {
+		return value
+	}
+
+	return any(value).(Compressible[T]).Compress()
+}
+
+// decompress returns original value from cached (compressed) form (when
+// compression is enabled)
+func (c *Cache[K, T]) decompress(value *T) *T {
+	if !c.compressValues || value == nil {
+		return value
+	}
+
+	return any(value).(Compressible[T]).Decompress()
+}
@@ -0,0 +1,227 @@
+package lazy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/moderntv/lazy-cache/internal/invalidation"
+)
+
+// Distribution shares results of entry loads between cache instances over NATS.
+// The instance which (re)loads an entry publishes loaded value and other instances
+// apply it to their cached entry instead of reloading it from data storage.
+//
+// Consistency tradeoff: instances can serve different values until the message is
+// delivered and NATS delivers messages at most once, so an instance which misses
+// a message reloads the entry itself when its data expire. Messages with results
+// older than the last load of the entry are ignored, so an entry never goes back
+// to an older value (load time in the future, e.g. due to clock skew between
+// instances, is clamped to local time). Only entries already cached by the
+// receiving instance are updated (entries which are being loaded at the moment are
+// skipped as well). As with automatic reload, received result prolongs TTL only
+// of entries accessed by the receiving instance. Instance stops receiving results
+// when its cache context is done.
+// Failed loads (except not found) are not distributed. Entries removed by
+// `Cache.RemoveAndBroadcast` are removed by other instances as well.
+type Distribution[K comparable, T any] struct {
+	Connection *nats.Conn
+	// Subject used for distribution messages. All instances of the same cache
+	// must use the same subject.
+	Subject string
+	// Codec serializes keys and values (JSONCodec is used when not set).
+	Codec Codec[K, T]
+}
+
+func (d *Distribution[K, T]) check() error {
+	if d.Connection == nil {
+		return fmt.Errorf("%w: distribution NATS connection must be set", ErrInvalidParams)
+	}
+
+	if d.Subject == "" {
+		return fmt.Errorf("%w: distribution subject must be set", ErrInvalidParams)
+	}
+
+	return nil
+}
+
+type distributedEntry struct {
+	Instance string `json:"instance"`
+	Key      []byte `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+	NotFound bool   `json:"notFound,omitempty"`
+	Removed  bool   `json:"removed,omitempty"` // entry was removed, other instances remove it too
+	LoadedAt int64  `json:"loadedAt"`          // timestamp of load in milliseconds
+}
+
+type distributor[K comparable, T any] struct {
+	helper   *invalidation.NatsHelper
+	subject  string
+	codec    Codec[K, T]
+	instance string
+}
+
+func (c *Cache[K, T]) startDistribution(distribution *Distribution[K, T]) {
+	codec := distribution.Codec
+	if codec == nil {
+		codec = JSONCodec[K, T]{}
+	}
+
+	c.distributor = &distributor[K, T]{
+		helper:   invalidation.NewNatsHelper(c.log, distribution.Connection, ""),
+		subject:  distribution.Subject,
+		codec:    codec,
+		instance: strconv.FormatUint(rand.Uint64(), 36),
+	}
+
+	c.distributor.helper.SubscribeData(c.ctx, distribution.Subject, c.receiveDistributedEntry)
+}
+
+// distributeLoadedEntry publishes result of entry load to other cache instances
+func (c *Cache[K, T]) distributeLoadedEntry(ID K, value *T, err error, loadedAt int64) {
+	if c.distributor == nil {
+		return
+	}
+
+	notFound := errors.Is(err, ErrNotFound)
+	if err != nil && !notFound {
+		return
+	}
+
+	key, err := c.distributor.codec.EncodeKey(ID)
+	if err != nil {
+		c.log.Warn().Err(err).Msg("cannot encode distributed entry key")
+		return
+	}
+
+	msg := distributedEntry{
+		Instance: c.distributor.instance,
+		Key:      key,
+		NotFound: notFound,
+		LoadedAt: loadedAt,
+	}
+
+	if !notFound {
+		msg.Value, err = c.distributor.codec.EncodeValue(value)
+		if err != nil {
+			c.log.Warn().Err(err).Msg("cannot encode distributed entry value")
+			return
+		}
+	}
+
+	err = c.publishDistributedEntry(msg)
+	if err != nil {
+		c.log.Warn().Err(err).Msg("cannot publish distributed entry")
+	}
+}
+
+func (c *Cache[K, T]) publishDistributedEntry(msg distributedEntry) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot marshal distributed entry: %w", err)
+	}
+
+	return c.distributor.helper.PublishData(c.distributor.subject, data)
+}
+
+// RemoveAndBroadcast removes the entry (see `Remove`) and publishes the removal to
+// other cache instances, which remove the entry as well. It requires `Distribution`
+// (`ErrDistributionDisabled` is returned otherwise). The entry is removed locally
+// even when publishing fails. Delivery to other instances is not guaranteed (NATS
+// delivers messages at most once).
+func (c *Cache[K, T]) RemoveAndBroadcast(ID K) error {
+	ID = c.normalizeKey(ID)
+	c.Remove(ID)
+
+	if c.distributor == nil {
+		return ErrDistributionDisabled
+	}
+
+	key, err := c.distributor.codec.EncodeKey(ID)
+	if err != nil {
+		return fmt.Errorf("cannot encode removed entry key: %w", err)
+	}
+
+	return c.publishDistributedEntry(distributedEntry{
+		Instance: c.distributor.instance,
+		Key:      key,
+		Removed:  true,
+		LoadedAt: c.nowMillis(),
+	})
+}
+
+// receiveDistributedEntry applies entry loaded by other cache instance
+func (c *Cache[K, T]) receiveDistributedEntry(data []byte) {
+	var msg distributedEntry
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		c.log.Warn().Err(err).Msg("cannot unmarshal distributed entry")
+		return
+	}
+
+	if msg.Instance == c.distributor.instance {
+		return
+	}
+
+	ID, err := c.distributor.codec.DecodeKey(msg.Key)
+	if err != nil {
+		c.log.Warn().Err(err).Msg("cannot decode distributed entry key")
+		return
+	}
+
+	if msg.Removed {
+		c.Remove(ID)
+		return
+	}
+
+	var value *T
+	var loadErr error
+	if msg.NotFound {
+		loadErr = ErrNotFound
+	} else {
+		value, err = c.distributor.codec.DecodeValue(msg.Value)
+		if err != nil {
+			c.log.Warn().Err(err).Msg("cannot decode distributed entry value")
+			return
+		}
+	}
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return
+	}
+	defer c.releaseEntry(entry)
+
+	// entry is being loaded by this instance
+	if !entry.mu.TryLock() {
+		return
+	}
+
+	// clock of other instance can be ahead, TTL must not be extended by the skew
+	loadedAt := min(msg.LoadedAt, c.nowMillis())
+
+	// do not apply older results
+	if entry.lastLoaded.Load() >= loadedAt {
+		entry.mu.Unlock()
+		return
+	}
+
+	// the result does not count as local access of the entry
+	accessed := entry.accessed.Load()
+	ttl := entry.set(value, loadErr, loadedAt, c.entryOptions(ID), false)
+	if accessed {
+		entry.accessed.Store(true)
+	} else if c.automaticReloadType != AutomaticReloadAllEntriesKeepAlive {
+		ttl = -1 // do not prolong TTL for not accessed entries
+	}
+	entry.expireAfter(loadedAt, ttl)
+
+	entry.mu.Unlock()
+
+	c.setEntryWatchers(ID, ttl, entry, loadedAt)
+	c.reportHealth(ID, entry)
+}
@@ -0,0 +1,141 @@
+package lazy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// EntryInfo describes lifecycle state of a cached entry (without its value).
+type EntryInfo[K comparable] struct {
+	Key K
+	// HasValue is false for not found entries and entries which are being loaded
+	// for the first time.
+	HasValue bool
+	// Accessed is true when entry data were accessed since last (re)load.
+	Accessed bool
+	// AccessCount is number of reads of the entry by `Get` (and its variants) since
+	// last (re)load (see `Params.DecayAccessCounts`).
+	AccessCount uint64
+	// NextReload is time when entry data expire and are reloaded on next access.
+	// Zero for entries which are being loaded for the first time.
+	NextReload time.Time
+	// ReloadInterval is effective (randomized) reload interval chosen by last (re)load.
+	ReloadInterval time.Duration
+	// ExpiresAt is time when entry is removed from cache by TTL expiration.
+	// Zero for entries which are being loaded for the first time.
+	ExpiresAt time.Time
+	// Err is error of the last load (see `EntryMeta.Err`).
+	Err error
+}
+
+// Dump returns lifecycle state of all cached entries. Entries are read under the
+// cache lock and state of every entry is read under its own lock, so the returned
+// snapshot is consistent: value, error, reload and expiration times of an entry
+// come from the same load (loads in progress are waited for, without holding the
+// cache lock). Values are not included, so the result is cheap and safe to log.
+func (c *Cache[K, T]) Dump() []EntryInfo[K] {
+	c.mu.RLock()
+	IDs := make([]K, 0, len(c.data))
+	entries := make([]*cachedEntry[T], 0, len(c.data))
+	for ID, entry := range c.data {
+		entry.refs.Add(1)
+		IDs = append(IDs, ID)
+		entries = append(entries, entry)
+	}
+	c.mu.RUnlock()
+
+	infos := make([]EntryInfo[K], 0, len(entries))
+	for i, entry := range entries {
+		entry.mu.Lock()
+		infos = append(infos, EntryInfo[K]{
+			Key:            IDs[i],
+			HasValue:       entry.value.Load() != nil,
+			Accessed:       entry.accessed.Load(),
+			AccessCount:    entry.accessCount.Load(),
+			NextReload:     millisToTime(entry.nextReload.Load()),
+			ReloadInterval: time.Duration(entry.reloadAfter.Load()) * time.Millisecond,
+			ExpiresAt:      millisToTime(entry.expiresAt.Load()),
+			Err:            entry.loadErr(),
+		})
+		entry.mu.Unlock()
+		c.releaseEntry(entry)
+	}
+
+	return infos
+}
+
+// WriteJSON writes values of cached entries to w as one JSON object keyed by
+// keyString of their keys (`fmt.Sprint` is used when nil), e.g. for debug
+// endpoints. Values are encoded by `encoding/json` (so `T` can customize its
+// representation). Not found entries and entries without value are skipped.
+// Keys are read under the cache lock, values are read afterwards without it,
+// so entries removed meanwhile are skipped too. Keys with the same string
+// representation produce duplicate keys in the object.
+func (c *Cache[K, T]) WriteJSON(w io.Writer, keyString func(ID K) string) error {
+	if keyString == nil {
+		keyString = func(ID K) string {
+			return fmt.Sprint(ID)
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	first := true
+
+	err := buf.WriteByte('{')
+	if err != nil {
+		return err
+	}
+
+	for ID, value := range c.All() {
+		key, err := json.Marshal(keyString(ID))
+		if err != nil {
+			return fmt.Errorf("encoding key %v: %w", ID, err)
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("encoding value of %v: %w", ID, err)
+		}
+
+		if !first {
+			err = buf.WriteByte(',')
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+
+		_, err = buf.Write(key)
+		if err != nil {
+			return err
+		}
+		err = buf.WriteByte(':')
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = buf.WriteByte('}')
+	if err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
+
+// millisToTime converts timestamp in milliseconds to time (zero timestamp is
+// converted to zero time).
+func millisToTime(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(millis)
+}
@@ -1,48 +1,231 @@
 package lazy
 
 import (
-	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/moderntv/lazy-cache/internal/memsize"
 	"github.com/moderntv/lazy-cache/internal/utils"
 )
 
 type cachedEntry[T any] struct {
-	nextReload atomic.Int64      // timestamp of next reload in milliseconds
-	accessed   atomic.Bool       // true if entry data was accessed since last (re)load
-	value      atomic.Pointer[T] // nil when not found
-	mu         sync.Mutex
+	nextReload    atomic.Int64          // timestamp of next reload in milliseconds
+	accessed      atomic.Bool           // true if entry data was accessed since last (re)load
+	accessCount   atomic.Uint64         // number of reads since last (re)load (halved by load when decaying)
+	value         atomic.Pointer[T]     // nil when not found
+	notFoundSince atomic.Int64          // timestamp of first not found reload of present value in milliseconds (0 if none)
+	expiresAt     atomic.Int64          // timestamp of TTL expiration in milliseconds (0 if not scheduled yet)
+	reloadAfter   atomic.Int64          // effective (randomized) reload interval used by last set in milliseconds
+	lastLoaded    atomic.Int64          // timestamp of last successful (or not found) load in milliseconds
+	failures      atomic.Int64          // number of consecutive failed (transient error) loads
+	lastAccess    atomic.Int64          // timestamp of last access in milliseconds (only when access is tracked)
+	err           atomic.Pointer[error] // error of last load if it failed or not found error with a reason (nil otherwise)
+	unhealthy     atomic.Bool           // true if last load failed (transient error)
+	healthChange  atomic.Int32          // health transition of last set not reported yet (see `reportHealth`)
+	loads         atomic.Uint64         // number of finished loads (see `loadedMeanwhile`)
+	ttlOverride   atomic.Int64          // TTL of the next successful load in milliseconds (0 if not set, see `Cache.InvalidateWithTTL`)
+	refs          atomic.Int32          // number of references (see `newEntry`)
+	removed       atomic.Bool           // true once the entry was deleted from cache map (under the cache lock)
+	mu            sync.Mutex
+}
+
+// reset clears entry before reuse. Entry must not be referenced by anyone.
+func (e *cachedEntry[T]) reset() {
+	e.nextReload.Store(0)
+	e.accessed.Store(false)
+	e.accessCount.Store(0)
+	e.value.Store(nil)
+	e.notFoundSince.Store(0)
+	e.expiresAt.Store(0)
+	e.reloadAfter.Store(0)
+	e.lastLoaded.Store(0)
+	e.failures.Store(0)
+	e.lastAccess.Store(0)
+	e.err.Store(nil)
+	e.unhealthy.Store(false)
+	e.healthChange.Store(healthUnchanged)
+	e.loads.Store(0)
+	e.ttlOverride.Store(0)
+	e.removed.Store(false)
+}
+
+// expireAtReloadPercent is part of remaining lifetime of entries with absolute
+// expiration (see `Params.ExpireAt`) after which they are reloaded
+const expireAtReloadPercent = 90
+
+// entryOptions configures how loaded data are set into entries
+type entryOptions[T any] struct {
+	timeouts            *Timeouts
+	classifyError       ClassifyErrorFunc                 // DefaultClassifyError when nil
+	shouldNegativeCache func() bool                       // not found entries are always cached when nil
+	reloadAt            func(value *T) time.Time          // reload interval is used when nil
+	compress            func(value *T) *T                 // values are stored as loaded when nil
+	stored              func(e *cachedEntry[T], value *T) // called when value changes (nil when cleared)
+	// absolute expiration of the value (TTL and reload interval are used when nil or not ok)
+	expireAt func(value *T) (time.Time, bool)
+	// value returned together with not found error is kept instead of clearing it
+	retainValueOnNotFound bool
+	// TTL of permanent error entries (`Timeouts.PermanentErrorTTL` is used when nil or not ok)
+	permanentErrorTTL func(err error) (ttl time.Duration, ok bool)
+	// loads failed because of cancelled context are not cached
+	skipCancelled bool
+	// access count is halved by load instead of clearing it
+	decayAccessCount bool
+	// called with TTL (negative when not changed) and reload interval assigned by set
+	ttlAssigned func(ttl, reloadInterval time.Duration)
+	// called with cached (possibly compressed) and reloaded value when reload
+	// replaces the value (nil when not reloading or not needed)
+	reloaded func(old, new *T)
 }
 
 // set sets value and nextReload (when it make sense) and returns new TTL
 // If TTL has negative value, it should be ignored (was not affected by this set)
-func (e *cachedEntry[T]) set(value *T, err error, nowMillis int64, timeouts *Timeouts, init bool) (ttl time.Duration) {
+func (e *cachedEntry[T]) set(value *T, err error, nowMillis int64, opts *entryOptions[T], init bool) (ttl time.Duration) {
 	ttl = -1
 
+	timeouts := opts.timeouts
+
+	var graceEnd int64 // timestamp when not found grace period ends (0 if not in grace period)
+	var reloadAt int64 // timestamp of reload derived from loaded value (0 if not set)
+	failed := false    // true when load failed with transient error
+
+	// nil value means not found (even without error), so a cached zero value
+	// (non-nil pointer) can never be confused with not found entry
+	if err == nil && value == nil {
+		err = ErrNotFound
+	}
+
+	// cancelled load leaves the entry as it was (new entry is not cached, expired
+	// entry stays expired), so the next read loads it again
+	if opts.skipCancelled && isCancellation(err) {
+		if init {
+			return 0
+		}
+		return
+	}
+
 	if err != nil {
-		// skip any error except NotFound
-		if !errors.Is(err, ErrNotFound) {
+		errClass := DefaultClassifyError(err)
+		if opts.classifyError != nil {
+			errClass = opts.classifyError(err)
+		}
+
+		// permanent errors are cached like not found records (for their own TTL)
+		if errClass == ErrorClassPermanent {
+			permanentErrorTTL := timeouts.PermanentErrorTTL
+			if opts.permanentErrorTTL != nil {
+				if errTTL, ok := opts.permanentErrorTTL(err); ok {
+					permanentErrorTTL = errTTL
+				}
+			}
+			ttl = utils.RandomizeDuration(permanentErrorTTL, timeouts.Randomizer)
+			e.setErr(err)
+			if e.value.Load() != nil {
+				e.value.Store(nil)
+				if opts.stored != nil {
+					opts.stored(e, nil)
+				}
+			}
+
+			goto end
+		}
+
+		// skip any other error except NotFound
+		if errClass != ErrorClassNotFound {
+			failed = true
+
 			// in case of first load, set error TTL
 			if init {
 				ttl = utils.RandomizeDuration(timeouts.ErrorTTL, timeouts.Randomizer)
 			}
+			e.setErr(err)
 
 			goto end
 		}
 
+		// when previously present record is not found during reload, keep serving its
+		// value until grace period passes (prevents flickering on transient not found)
+		if !init && timeouts.NotFoundGrace > 0 && e.value.Load() != nil {
+			notFoundSince := e.notFoundSince.Load()
+			if notFoundSince == 0 {
+				notFoundSince = nowMillis
+				e.notFoundSince.Store(notFoundSince)
+			}
+
+			if nowMillis < notFoundSince+timeouts.NotFoundGrace.Milliseconds() {
+				graceEnd = notFoundSince + timeouts.NotFoundGrace.Milliseconds()
+				goto end
+			}
+		}
+
 		// when record is not found, we want to keep this information in cache for desired time
+		// (unless the entry should not be negative cached, then it is removed)
 		ttl = utils.RandomizeDuration(timeouts.NotFoundTTL, timeouts.Randomizer)
-		if e.value.Load() != nil {
+		if opts.shouldNegativeCache != nil && !opts.shouldNegativeCache() {
+			ttl = 0
+		}
+		if opts.reloaded != nil && !init {
+			var retained *T
+			if opts.retainValueOnNotFound {
+				retained = value
+			}
+			opts.reloaded(e.value.Load(), retained)
+		}
+		// value returned together with not found (e.g. tombstone) is kept when requested
+		if opts.retainValueOnNotFound && value != nil {
+			e.storeValue(value, opts)
+		} else if e.value.Load() != nil {
 			e.value.Store(nil)
+			if opts.stored != nil {
+				opts.stored(e, nil)
+			}
+		}
+		if e.notFoundSince.Load() != 0 {
+			e.notFoundSince.Store(0)
 		}
+		// error describing why the record was not found (e.g. wrapping `ErrNotFound`
+		// with a reason) is kept, bare `ErrNotFound` carries no information
+		if err == ErrNotFound {
+			e.setErr(nil)
+		} else {
+			e.setErr(err)
+		}
+		e.lastLoaded.Store(nowMillis)
 
 		goto end
 	}
 
 	ttl = utils.RandomizeDuration(timeouts.TTL, timeouts.Randomizer)
-	e.value.Store(value)
+	// one-shot override is used only by the first successful load after it was set
+	if override := e.ttlOverride.Swap(0); override > 0 {
+		ttl = time.Duration(override) * time.Millisecond
+	}
+	if opts.reloadAt != nil {
+		if at := opts.reloadAt(value); !at.IsZero() {
+			reloadAt = max(at.UnixMilli(), nowMillis)
+		}
+	}
+	if opts.expireAt != nil {
+		if at, ok := opts.expireAt(value); ok {
+			remaining := max(at.UnixMilli()-nowMillis, 0)
+			ttl = time.Duration(remaining) * time.Millisecond
+			// reload before the expiration, so reads do not miss the entry
+			expireReloadAt := nowMillis + remaining*expireAtReloadPercent/100
+			if reloadAt == 0 || expireReloadAt < reloadAt {
+				reloadAt = expireReloadAt
+			}
+		}
+	}
+	if opts.reloaded != nil && !init {
+		opts.reloaded(e.value.Load(), value)
+	}
+	e.storeValue(value, opts)
+	if e.notFoundSince.Load() != 0 {
+		e.notFoundSince.Store(0)
+	}
+	e.setErr(nil)
+	e.lastLoaded.Store(nowMillis)
 
 	// set `accessed` and `nextReload` every time and AFTER value is stored
 	// (if they are set before `value`, cache can in some circumstances read old value
@@ -51,11 +234,133 @@ end:
 	if e.accessed.Load() {
 		e.accessed.Store(false)
 	}
-	e.nextReload.Store(nowMillis + utils.RandomizeDuration(timeouts.ReloadInterval, timeouts.Randomizer).Milliseconds())
+	// reads counted concurrently can be lost, access count is approximate
+	if accessCount := e.accessCount.Load(); opts.decayAccessCount {
+		e.accessCount.Store(accessCount / 2)
+	} else if accessCount != 0 {
+		e.accessCount.Store(0)
+	}
+
+	reloadInterval := timeouts.ReloadInterval
+	if failed {
+		// entry in error state (without value) is retried sooner
+		if timeouts.ErrorRetryInterval > 0 && e.value.Load() == nil {
+			reloadInterval = timeouts.ErrorRetryInterval
+		}
+
+		failures := e.failures.Add(1)
+		if timeouts.MaxErrorBackoff > 0 {
+			reloadInterval = errorBackoff(reloadInterval, failures, timeouts.MaxErrorBackoff)
+		}
+	} else if e.failures.Load() != 0 {
+		e.failures.Store(0)
+	}
+
+	// transition is reported after the entry is unlocked
+	if e.unhealthy.Load() != failed {
+		e.unhealthy.Store(failed)
+		if failed {
+			e.healthChange.Store(healthFailing)
+		} else {
+			e.healthChange.Store(healthRecovered)
+		}
+	}
+
+	nextReload := nowMillis + utils.RandomizeDuration(reloadInterval, timeouts.Randomizer).Milliseconds()
+	// reload time derived from the value replaces reload interval
+	if reloadAt > 0 {
+		nextReload = reloadAt
+	}
+	// reload right after grace period passes
+	if graceEnd > 0 && nextReload > graceEnd {
+		nextReload = graceEnd
+	}
+	// entry with zero TTL is being removed, reads should load it until then
+	if ttl == 0 {
+		nextReload = nowMillis
+	}
+	e.reloadAfter.Store(nextReload - nowMillis)
+	e.nextReload.Store(nextReload)
+	e.loads.Add(1)
+
+	if opts.ttlAssigned != nil {
+		opts.ttlAssigned(ttl, time.Duration(nextReload-nowMillis)*time.Millisecond)
+	}
 
 	return
 }
 
+// loadedMeanwhile returns true if the entry was loaded by other routine since
+// `loads` were read or its value is valid at the time. Routines waiting for the
+// entry mutex use it to read the value of the load they waited for instead of
+// loading the entry again (even when the value expires right away), so only one
+// load happens however many routines wait.
+func (e *cachedEntry[T]) loadedMeanwhile(loads uint64, nowMillis int64) bool {
+	return e.loads.Load() != loads || nowMillis < e.nextReload.Load()
+}
+
+// expireAfter stores time of TTL expiration of the entry (negative TTL keeps the
+// current one). Loads store it before the entry is unlocked, so it is read
+// together with loaded data under the entry mutex (see `Cache.Dump`).
+func (e *cachedEntry[T]) expireAfter(nowMillis int64, ttl time.Duration) {
+	if ttl >= 0 {
+		e.expiresAt.Store(nowMillis + ttl.Milliseconds())
+	}
+}
+
+// storeValue stores loaded value (reported to `stored` hook and compressed
+// according to options)
+func (e *cachedEntry[T]) storeValue(value *T, opts *entryOptions[T]) {
+	if opts.stored != nil {
+		opts.stored(e, value)
+	}
+	if opts.compress != nil {
+		value = opts.compress(value)
+	}
+
+	e.value.Store(value)
+}
+
+// setErr stores load error of the entry (nil clears it)
+func (e *cachedEntry[T]) setErr(err error) {
+	if err == nil {
+		if e.err.Load() != nil {
+			e.err.Store(nil)
+		}
+		return
+	}
+
+	e.err.Store(&err)
+}
+
+// loadErr returns stored load error of the entry
+func (e *cachedEntry[T]) loadErr() error {
+	err := e.err.Load()
+	if err == nil {
+		return nil
+	}
+
+	return *err
+}
+
+// errorBackoff returns reload interval doubled for each failure (capped by maxBackoff,
+// reload interval is never shortened)
+func errorBackoff(reloadInterval time.Duration, failures int64, maxBackoff time.Duration) time.Duration {
+	if reloadInterval >= maxBackoff {
+		return reloadInterval
+	}
+
+	for i := int64(0); i < failures && reloadInterval < maxBackoff; i++ {
+		reloadInterval *= 2
+	}
+
+	if reloadInterval > maxBackoff {
+		reloadInterval = maxBackoff
+	}
+
+	return reloadInterval
+}
+
 func (e *cachedEntry[T]) get() *T {
 	if !e.accessed.Load() {
 		e.accessed.Store(true)
@@ -64,11 +369,11 @@ func (e *cachedEntry[T]) get() *T {
 	return e.value.Load()
 }
 
-// func (e *cachedEntry[T]) memSize() uint64 {
-// 	value := e.value.Load()
-// 	if value == nil {
-// 		return 0
-// 	}
+func (e *cachedEntry[T]) memSize() uint64 {
+	value := e.value.Load()
+	if value == nil {
+		return 0
+	}
 
-// 	return memsize.Entry(value)
-// }
+	return memsize.Entry(value)
+}
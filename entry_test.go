@@ -32,6 +32,8 @@ var entryTestTimeouts = Timeouts{
 	Randomizer:     0,
 }
 
+var entryTestOptions = entryOptions[string]{timeouts: &entryTestTimeouts}
+
 // Test entry set without error
 func TestEntrySetFirstTime(t *testing.T) {
 	var nowMillis int64 = 1700000000
@@ -69,13 +71,29 @@ func TestEntrySetFirstTime(t *testing.T) {
 			expectedNextReload: nowMillis + entryTestTimeouts.ReloadInterval.Milliseconds(),
 			expectedAccessed:   false,
 		},
+		"success_zero_value": {
+			loadedValue:        test_utils.StringPointer(""),
+			err:                nil,
+			expectedTTL:        entryTestTimeouts.TTL,
+			expectedValue:      test_utils.StringPointer(""),
+			expectedNextReload: nowMillis + entryTestTimeouts.ReloadInterval.Milliseconds(),
+			expectedAccessed:   false,
+		},
+		"nil_without_error": {
+			loadedValue:        nil,
+			err:                nil,
+			expectedTTL:        entryTestTimeouts.NotFoundTTL,
+			expectedValue:      nil,
+			expectedNextReload: nowMillis + entryTestTimeouts.ReloadInterval.Milliseconds(),
+			expectedAccessed:   false,
+		},
 	}
 
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			e := &cachedEntry[string]{}
 
-			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestTimeouts, true)
+			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestOptions, true)
 			assert.Equal(t, tc.expectedTTL, ttl)
 			assert.Equal(t, tc.expectedValue, e.value.Load())
 			assert.Equal(t, tc.expectedNextReload, e.nextReload.Load())
@@ -119,10 +137,10 @@ func TestEntryReloadAfterError(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			// entry was first loaded 500ms ago
 			e := &cachedEntry[string]{}
-			e.set(test_utils.StringPointer("invalidValue"), errors.New("other error"), nowMillis-500, &entryTestTimeouts, true)
+			e.set(test_utils.StringPointer("invalidValue"), errors.New("other error"), nowMillis-500, &entryTestOptions, true)
 			e.accessed.Store(true)
 
-			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestTimeouts, false)
+			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestOptions, false)
 			assert.Equal(t, tc.expectedTTL, ttl, "incorrect TTL")
 			assert.Equal(t, tc.expectedValue, e.value.Load(), "incorrect value")
 			assert.Equal(t, tc.expectedNextReload, e.nextReload.Load(), "incorrect next reload")
@@ -166,10 +184,10 @@ func TestEntryReloadAfterNotFound(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			// entry was first loaded 500ms ago
 			e := &cachedEntry[string]{}
-			e.set(test_utils.StringPointer("invalidValue"), ErrNotFound, nowMillis-500, &entryTestTimeouts, true)
+			e.set(test_utils.StringPointer("invalidValue"), ErrNotFound, nowMillis-500, &entryTestOptions, true)
 			e.accessed.Store(true)
 
-			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestTimeouts, false)
+			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestOptions, false)
 			assert.Equal(t, tc.expectedTTL, ttl, "incorrect TTL")
 			assert.Equal(t, tc.expectedValue, e.value.Load(), "incorrect value")
 			assert.Equal(t, tc.expectedNextReload, e.nextReload.Load(), "incorrect next reload")
@@ -213,10 +231,10 @@ func TestEntryReloadAfterSuccess(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			// entry was first loaded 500ms ago
 			e := &cachedEntry[string]{}
-			e.set(test_utils.StringPointer("value0"), nil, nowMillis-500, &entryTestTimeouts, true)
+			e.set(test_utils.StringPointer("value0"), nil, nowMillis-500, &entryTestOptions, true)
 			e.accessed.Store(true)
 
-			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestTimeouts, false)
+			ttl := e.set(tc.loadedValue, tc.err, nowMillis, &entryTestOptions, false)
 			assert.Equal(t, tc.expectedTTL, ttl, "incorrect TTL")
 			assert.Equal(t, tc.expectedValue, e.value.Load(), "incorrect value")
 			assert.Equal(t, tc.expectedNextReload, e.nextReload.Load(), "incorrect next reload")
@@ -226,6 +244,119 @@ func TestEntryReloadAfterSuccess(t *testing.T) {
 	}
 }
 
+func TestEntryErrorClassification(t *testing.T) {
+	var nowMillis int64 = 1700000000
+
+	errBadRequest := errors.New("bad request")
+	errUnavailable := errors.New("service unavailable")
+	errGone := errors.New("gone")
+
+	timeouts := entryTestTimeouts
+	timeouts.PermanentErrorTTL = 60 * time.Second
+	opts := &entryOptions[string]{
+		timeouts: &timeouts,
+		classifyError: func(err error) ErrorClass {
+			switch {
+			case errors.Is(err, errBadRequest):
+				return ErrorClassPermanent
+			case errors.Is(err, errGone):
+				return ErrorClassNotFound
+			default:
+				return DefaultClassifyError(err)
+			}
+		},
+	}
+
+	tests := map[string]struct {
+		err                error
+		expectedInitTTL    time.Duration
+		expectedReloadTTL  time.Duration
+		expectedReloadedOK bool // value is kept after reload
+	}{
+		"permanent": {
+			err:                errBadRequest,
+			expectedInitTTL:    timeouts.PermanentErrorTTL,
+			expectedReloadTTL:  timeouts.PermanentErrorTTL,
+			expectedReloadedOK: false,
+		},
+		"transient": {
+			err:                errUnavailable,
+			expectedInitTTL:    timeouts.ErrorTTL,
+			expectedReloadTTL:  -1,
+			expectedReloadedOK: true,
+		},
+		"not_found": {
+			err:                errGone,
+			expectedInitTTL:    timeouts.NotFoundTTL,
+			expectedReloadTTL:  timeouts.NotFoundTTL,
+			expectedReloadedOK: false,
+		},
+		"default_not_found": {
+			err:                ErrNotFound,
+			expectedInitTTL:    timeouts.NotFoundTTL,
+			expectedReloadTTL:  timeouts.NotFoundTTL,
+			expectedReloadedOK: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			e := &cachedEntry[string]{}
+			ttl := e.set(nil, tc.err, nowMillis, opts, true)
+			assert.Equal(t, tc.expectedInitTTL, ttl, "incorrect first load TTL")
+			assert.Nil(t, e.value.Load(), "incorrect value")
+
+			e = &cachedEntry[string]{}
+			e.set(test_utils.StringPointer("value0"), nil, nowMillis-500, opts, true)
+			ttl = e.set(nil, tc.err, nowMillis, opts, false)
+			assert.Equal(t, tc.expectedReloadTTL, ttl, "incorrect reload TTL")
+			assert.Equal(t, tc.expectedReloadedOK, e.value.Load() != nil, "incorrect value")
+		})
+	}
+}
+
+func TestEntryReloadNotFoundGrace(t *testing.T) {
+	var nowMillis int64 = 1700000000
+	graceTimeouts := entryTestTimeouts
+	graceTimeouts.NotFoundGrace = 2 * time.Second
+
+	// first load not found has no value to be kept
+	e := &cachedEntry[string]{}
+	ttl := e.set(nil, ErrNotFound, nowMillis, &entryOptions[string]{timeouts: &graceTimeouts}, true)
+	assert.Equal(t, graceTimeouts.NotFoundTTL, ttl, "incorrect TTL")
+	assert.Nil(t, e.value.Load(), "incorrect value")
+
+	// entry was first loaded successfully
+	e = &cachedEntry[string]{}
+	e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryOptions[string]{timeouts: &graceTimeouts}, true)
+
+	// transient not found keeps the value, next reload at the end of grace period
+	ttl = e.set(nil, ErrNotFound, nowMillis+1000, &entryOptions[string]{timeouts: &graceTimeouts}, false)
+	assert.Equal(t, time.Duration(-1), ttl, "incorrect TTL")
+	assert.Equal(t, test_utils.StringPointer("value0"), e.value.Load(), "incorrect value")
+	assert.Equal(t, nowMillis+3000, e.nextReload.Load(), "incorrect next reload")
+
+	// backend recovered
+	ttl = e.set(test_utils.StringPointer("value1"), nil, nowMillis+2000, &entryOptions[string]{timeouts: &graceTimeouts}, false)
+	assert.Equal(t, graceTimeouts.TTL, ttl, "incorrect TTL")
+	assert.Equal(t, test_utils.StringPointer("value1"), e.value.Load(), "incorrect value")
+
+	// grace period starts again
+	ttl = e.set(nil, ErrNotFound, nowMillis+5000, &entryOptions[string]{timeouts: &graceTimeouts}, false)
+	assert.Equal(t, time.Duration(-1), ttl, "incorrect TTL")
+	assert.Equal(t, test_utils.StringPointer("value1"), e.value.Load(), "incorrect value")
+	ttl = e.set(nil, ErrNotFound, nowMillis+6000, &entryOptions[string]{timeouts: &graceTimeouts}, false)
+	assert.Equal(t, time.Duration(-1), ttl, "incorrect TTL")
+	assert.Equal(t, test_utils.StringPointer("value1"), e.value.Load(), "incorrect value")
+	assert.Equal(t, nowMillis+7000, e.nextReload.Load(), "incorrect next reload")
+
+	// grace period passed
+	ttl = e.set(nil, ErrNotFound, nowMillis+7000, &entryOptions[string]{timeouts: &graceTimeouts}, false)
+	assert.Equal(t, graceTimeouts.NotFoundTTL, ttl, "incorrect TTL")
+	assert.Nil(t, e.value.Load(), "incorrect value")
+	assert.Equal(t, nowMillis+7000+graceTimeouts.ReloadInterval.Milliseconds(), e.nextReload.Load(), "incorrect next reload")
+}
+
 func TestEntryRandomizedTTL(t *testing.T) {
 	var nowMillis int64 = 1700000000
 	randomizedTimeouts := entryTestTimeouts
@@ -240,7 +371,7 @@ func TestEntryRandomizedTTL(t *testing.T) {
 	e := &cachedEntry[string]{}
 
 	for i := 0; i < tries; i++ {
-		ttl := e.set(test_utils.StringPointer("value0"), nil, nowMillis, &randomizedTimeouts, false)
+		ttl := e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryOptions[string]{timeouts: &randomizedTimeouts}, false)
 
 		if ttl < entryTestTimeouts.TTL {
 			lessThanReference++
@@ -272,9 +403,10 @@ func TestEntryRandomizedNextReload(t *testing.T) {
 	e := &cachedEntry[string]{}
 
 	for i := 0; i < tries; i++ {
-		_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &randomizedTimeouts, false)
+		_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryOptions[string]{timeouts: &randomizedTimeouts}, false)
 
 		nextReload := e.nextReload.Load()
+		assert.Equal(t, nextReload-nowMillis, e.reloadAfter.Load())
 		if nextReload < referenceNextReload {
 			lessThanReference++
 		} else if nextReload > referenceNextReload {
@@ -289,6 +421,64 @@ func TestEntryRandomizedNextReload(t *testing.T) {
 	assert.Greater(t, greaterThanReference, treshhold)
 }
 
+func TestEntryErrorBackoff(t *testing.T) {
+	var nowMillis int64 = 1700000000
+	backoffTimeouts := entryTestTimeouts
+	backoffTimeouts.MaxErrorBackoff = 20 * time.Second
+	opts := &entryOptions[string]{timeouts: &backoffTimeouts}
+
+	e := &cachedEntry[string]{}
+	e.set(test_utils.StringPointer("value0"), nil, nowMillis, opts, true)
+	assert.Equal(t, int64(3000), e.reloadAfter.Load())
+
+	// reload interval widens with each failure up to the maximum
+	for _, expected := range []int64{6000, 12000, 20000, 20000} {
+		ttl := e.set(nil, errors.New("load failed"), nowMillis, opts, false)
+		assert.Equal(t, time.Duration(-1), ttl, "incorrect TTL")
+		assert.Equal(t, expected, e.reloadAfter.Load(), "incorrect reload interval")
+		assert.Equal(t, test_utils.StringPointer("value0"), e.value.Load(), "incorrect value")
+	}
+
+	// success resets the interval
+	e.set(test_utils.StringPointer("value1"), nil, nowMillis, opts, false)
+	assert.Equal(t, int64(3000), e.reloadAfter.Load())
+	assert.Equal(t, int64(0), e.failures.Load())
+
+	// not found resets the interval as well
+	e.set(nil, errors.New("load failed"), nowMillis, opts, false)
+	assert.Equal(t, int64(6000), e.reloadAfter.Load())
+	e.set(nil, ErrNotFound, nowMillis, opts, false)
+	assert.Equal(t, int64(3000), e.reloadAfter.Load())
+
+	// disabled backoff keeps reload interval
+	e = &cachedEntry[string]{}
+	e.set(nil, errors.New("load failed"), nowMillis, &entryTestOptions, true)
+	e.set(nil, errors.New("load failed"), nowMillis, &entryTestOptions, false)
+	assert.Equal(t, int64(3000), e.reloadAfter.Load())
+}
+
+func TestEntryReset(t *testing.T) {
+	var nowMillis int64 = 1700000000
+
+	e := &cachedEntry[string]{}
+	_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestOptions, true)
+	_ = e.get()
+	e.expiresAt.Store(nowMillis)
+	e.notFoundSince.Store(nowMillis)
+	e.failures.Store(2)
+
+	e.reset()
+
+	assert.Equal(t, int64(0), e.nextReload.Load())
+	assert.False(t, e.accessed.Load())
+	assert.Nil(t, e.value.Load())
+	assert.Equal(t, int64(0), e.notFoundSince.Load())
+	assert.Equal(t, int64(0), e.expiresAt.Load())
+	assert.Equal(t, int64(0), e.reloadAfter.Load())
+	assert.Equal(t, int64(0), e.lastLoaded.Load())
+	assert.Equal(t, int64(0), e.failures.Load())
+}
+
 func TestEntryMutex(t *testing.T) {
 	var nowMillis int64 = 1700000000
 
@@ -304,12 +494,12 @@ func TestEntryMutex(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			for j := 0; j < iterations; j++ {
-				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestTimeouts, false)
+				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestOptions, false)
 
 				_ = e.get()
 
-				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestTimeouts, true)
-				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestTimeouts, true)
+				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestOptions, true)
+				_ = e.set(test_utils.StringPointer("value0"), nil, nowMillis, &entryTestOptions, true)
 
 				_ = e.get()
 				_ = e.get()
@@ -340,9 +530,72 @@ func BenchmarkAtomicWrite(b *testing.B) {
 	}
 }
 
+// Storage benchmarks compare storing small (int64) values by pointer (used by cache)
+// with storing them by value.
+func BenchmarkStorageInt64Pointer(b *testing.B) {
+	v := atomic.Pointer[int64]{}
+
+	b.Run("store", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			value := int64(i)
+			v.Store(&value)
+		}
+	})
+
+	b.Run("load", func(b *testing.B) {
+		// seeded here, so the benchmark can run without "store" one
+		value := int64(1)
+		v.Store(&value)
+
+		for i := 0; i < b.N; i++ {
+			_ = *v.Load()
+		}
+	})
+}
+
+func BenchmarkStorageInt64AtomicValue(b *testing.B) {
+	v := atomic.Value{}
+
+	b.Run("store", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			v.Store(int64(i))
+		}
+	})
+
+	b.Run("load", func(b *testing.B) {
+		// seeded here, so the benchmark can run without "store" one
+		v.Store(int64(1))
+
+		for i := 0; i < b.N; i++ {
+			_ = v.Load().(int64)
+		}
+	})
+}
+
+func BenchmarkStorageInt64Mutex(b *testing.B) {
+	mu := sync.RWMutex{}
+	var v int64
+
+	b.Run("store", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mu.Lock()
+			v = int64(i)
+			mu.Unlock()
+		}
+	})
+
+	b.Run("load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mu.RLock()
+			_ = v
+			mu.RUnlock()
+		}
+	})
+}
+
 func BenchmarkEntryGet(b *testing.B) {
 	e := &cachedEntry[string]{}
-	e.set(test_utils.StringPointer("invalidValue"), nil, 1500000000, &entryTestTimeouts, false)
+	e.set(test_utils.StringPointer("invalidValue"), nil, 1500000000, &entryTestOptions, false)
 
 	for i := 0; i < b.N; i++ {
 		_ = e.get()
@@ -354,7 +607,7 @@ func BenchmarkEntrySetWithoutRandomizer(b *testing.B) {
 	value := test_utils.StringPointer("invalidValue")
 
 	for i := 0; i < b.N; i++ {
-		e.set(value, nil, 1500000000, &entryTestTimeouts, false)
+		e.set(value, nil, 1500000000, &entryTestOptions, false)
 	}
 }
 
@@ -366,6 +619,6 @@ func BenchmarkEntrySetWithRandomizer(b *testing.B) {
 	value := test_utils.StringPointer("invalidValue")
 
 	for i := 0; i < b.N; i++ {
-		e.set(value, nil, 1500000000, &randomizedTimeouts, false)
+		e.set(value, nil, 1500000000, &entryOptions[string]{timeouts: &randomizedTimeouts}, false)
 	}
 }
@@ -1,5 +1,81 @@
 package lazy
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrTimeout     = errors.New("timeout exceeded")
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrCallbackPanic is returned instead of result of user callback (e.g. loader)
+	// which panicked.
+	ErrCallbackPanic = errors.New("cache callback panicked")
+	// ErrPaused is returned by reloads which were skipped, because the cache is
+	// paused (see `Cache.Pause`).
+	ErrPaused = errors.New("cache is paused")
+	// ErrCacheClosed is returned by operations which cannot be performed, because
+	// the cache was closed (its context is done): `Cache.Set`, `Cache.WaitForKey`,
+	// `Cache.InvalidateAndWait`, loads waiting for a slot of `LoadLimiter` (wrapping
+	// error of the context) and `CacheGroup.Cache`.
+	ErrCacheClosed = errors.New("cache is closed")
+	// ErrFatal is returned (wrapped) by loaders when the data storage is broken
+	// beyond transient failures (e.g. invalid configuration). The first such error
+	// disables loading of entries for the rest of the cache lifetime: cached
+	// entries are served as they are (including expired ones, which are not
+	// removed by TTL anymore) and reads of other entries return nil
+	// (`GetWithError` returns the fatal error). Automatic reloads stop as well. See
+	// `Cache.FatalError`.
+	ErrFatal = errors.New("fatal loader error")
+	// ErrMetricsRegistered is returned by `NewCache` when metrics of a cache with
+	// the same name are already registered in `MetricsRegistry`.
+	ErrMetricsRegistered = errors.New("cache metrics already registered")
+	// ErrDistributionDisabled is returned by operations which require
+	// `Distribution`, when it is not configured.
+	ErrDistributionDisabled = errors.New("cache distribution is not configured")
+)
+
+// Validation errors returned by `NewCache` (and `SetTimeouts`). All of them wrap
+// `ErrInvalidParams`, specific problems are described by wrapping errors.
+var (
+	ErrInvalidParams   = errors.New("invalid cache params")
+	ErrContextNil      = fmt.Errorf("%w: context must be set", ErrInvalidParams)
+	ErrNameEmpty       = fmt.Errorf("%w: name must be set", ErrInvalidParams)
+	ErrLoaderNil       = fmt.Errorf("%w: LoadOneFunc must be provided", ErrInvalidParams)
+	ErrInvalidTimeouts = fmt.Errorf("%w: invalid timeouts", ErrInvalidParams)
+)
+
+// ErrorClass specifies how an error returned by loader is cached.
+type ErrorClass int
+
+const (
+	// ErrorClassTransient error is cached for `ErrorTTL` when returned by first load
+	// of the entry. When returned by reload, previous entry data are kept.
+	ErrorClassTransient ErrorClass = iota
+	// ErrorClassPermanent error (e.g. invalid request) is cached like not found entry
+	// for `PermanentErrorTTL`.
+	ErrorClassPermanent
+	// ErrorClassNotFound error is cached as not found entry for `NotFoundTTL`.
+	ErrorClassNotFound
+)
+
+// ClassifyErrorFunc decides how an error returned by loader is cached.
+type ClassifyErrorFunc func(err error) ErrorClass
+
+// isCancellation returns true when the error was caused by cancelled (or timed
+// out) context
+func isCancellation(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// DefaultClassifyError classifies `ErrNotFound` (and errors wrapping it) as not found
+// and any other error as transient.
+func DefaultClassifyError(err error) ErrorClass {
+	if errors.Is(err, ErrNotFound) {
+		return ErrorClassNotFound
+	}
+
+	return ErrorClassTransient
+}
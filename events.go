@@ -0,0 +1,46 @@
+package lazy
+
+import "time"
+
+// EventType is type of cache lifecycle event.
+type EventType int
+
+const (
+	// EventLoad is emitted when not cached entry is loaded (or preloaded) into cache.
+	EventLoad EventType = iota
+	// EventReload is emitted when cached entry is reloaded (lazily or automatically).
+	EventReload
+	// EventEvict is emitted when entry is removed from cache (TTL expiration,
+	// eviction, compaction or `Remove`).
+	EventEvict
+	// EventInvalidate is emitted when entry is invalidated.
+	EventInvalidate
+)
+
+// CacheEvent describes lifecycle event of an entry.
+type CacheEvent[K comparable] struct {
+	Type EventType
+	Key  K
+	Time time.Time
+}
+
+// Events returns channel of lifecycle events (nil when events are disabled, see
+// `EventsBuffer` in `Params`). Events are never waited for: when the channel
+// buffer is full (consumer is slow or absent), new events are dropped (see
+// `Stats.DroppedEvents`).
+func (c *Cache[K, T]) Events() <-chan CacheEvent[K] {
+	return c.events
+}
+
+// emitEvent sends lifecycle event without blocking
+func (c *Cache[K, T]) emitEvent(eventType EventType, ID K) {
+	if c.events == nil {
+		return
+	}
+
+	select {
+	case c.events <- CacheEvent[K]{Type: eventType, Key: ID, Time: c.clock.Now()}:
+	default:
+		c.stats.droppedEvents.Add(1)
+	}
+}
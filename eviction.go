@@ -0,0 +1,242 @@
+package lazy
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+// EvictionPolicy specifies which entries are evicted when number of cached entries
+// exceeds `MaxEntries`.
+type EvictionPolicy int
+
+const (
+	// EvictionSampledLRU evicts least recently accessed entry from a sample of
+	// `EvictionSamples` entries (approximation of LRU with constant cost, the
+	// default policy). Multiple entries evicted at once (e.g. by `Cache.Resize`)
+	// are selected as by `EvictionLRU`.
+	EvictionSampledLRU EvictionPolicy = iota
+	// EvictionLRU evicts least recently accessed entry. All entries are scanned
+	// to find it, so every insertion over `MaxEntries` costs time proportional
+	// to number of entries (with the cache locked).
+	EvictionLRU
+	// EvictionRandom evicts random entry.
+	EvictionRandom
+)
+
+// evictionVictim is entry selected for eviction
+type evictionVictim[K comparable, T any] struct {
+	ID         K
+	entry      *cachedEntry[T]
+	lastAccess int64
+}
+
+const defaultEvictionSamples = 5
+
+// Resize changes capacity limits at runtime: `MaxEntries` and memory budget of
+// cached values in bytes (0 disables the limit). When cache exceeds the new limits,
+// entries are evicted immediately according to eviction policy (by last measured
+// memory size of cached values) and later insertions evict entries as well. The
+// budget is separate from `HardMemoryCeiling`, which only rejects insertions.
+// Memory limit can be set only when memory size is measured.
+func (c *Cache[K, T]) Resize(maxEntries int, maxBytes uint64) error {
+	if maxEntries < 0 {
+		return fmt.Errorf("%w: MaxEntries cannot be negative", ErrInvalidParams)
+	}
+
+	if maxBytes > 0 && !c.measureMemsize {
+		return fmt.Errorf("%w: memory limit requires memory size measurement", ErrInvalidParams)
+	}
+
+	if maxEntries > 0 {
+		c.trackAccess.Store(true)
+	}
+	c.maxEntries.Store(int64(maxEntries))
+	c.maxMemoryBytes.Store(maxBytes)
+
+	if c.capacityDryRun {
+		c.countWouldEvict(c.wouldEvict(maxEntries, maxBytes))
+		return nil
+	}
+
+	c.evict(maxEntries, maxBytes)
+
+	return nil
+}
+
+// enforceCapacity evicts entries until number of cached entries does not exceed
+// `MaxEntries` and memory size does not exceed memory budget (see `Resize`)
+func (c *Cache[K, T]) enforceCapacity() {
+	maxEntries := int(c.maxEntries.Load())
+	maxBytes := c.maxMemoryBytes.Load()
+	if maxEntries == 0 && maxBytes == 0 {
+		return
+	}
+
+	overBudget := maxBytes > 0 && c.memSizeValue.Load() > maxBytes
+	c.mu.RLock()
+	overCapacity := (maxEntries > 0 && len(c.data) > maxEntries) || overBudget
+	c.mu.RUnlock()
+
+	if !overCapacity {
+		return
+	}
+
+	// inserted entry would evict one other entry
+	if c.capacityDryRun {
+		c.countWouldEvict(1)
+		return
+	}
+
+	c.evict(maxEntries, maxBytes)
+}
+
+// evict evicts entries until number of cached entries does not exceed maxEntries
+// and memory size of cached values does not exceed maxBytes (0 means no limit)
+func (c *Cache[K, T]) evict(maxEntries int, maxBytes uint64) {
+	c.mu.Lock()
+	victims, memSize := c.evictionVictims(maxEntries, maxBytes)
+	evicted := make([]K, 0, len(victims))
+	for _, victim := range victims {
+		c.deleteEntry(victim.ID, victim.entry)
+		evicted = append(evicted, victim.ID)
+	}
+	if maxBytes > 0 {
+		c.memSizeValue.Store(memSize)
+	}
+	c.mu.Unlock()
+
+	if len(evicted) == 0 {
+		return
+	}
+
+	for _, ID := range evicted {
+		c.ttlWatcher.Drop(ID)
+		c.reloadWatcher.Drop(ID)
+		c.emitEvent(EventEvict, ID)
+	}
+
+	c.stats.evictions.Add(uint64(len(evicted)))
+	if c.metrics != nil {
+		c.metrics.ItemsCount.Sub(float64(len(evicted)))
+		c.metrics.EvictionCount.Add(float64(len(evicted)))
+	}
+}
+
+// wouldEvict returns number of entries which would be evicted by `evict` with
+// the same limits (without evicting them)
+func (c *Cache[K, T]) wouldEvict(maxEntries int, maxBytes uint64) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	victims, _ := c.evictionVictims(maxEntries, maxBytes)
+
+	return len(victims)
+}
+
+// countWouldEvict counts entries which would be evicted without dry run
+func (c *Cache[K, T]) countWouldEvict(count int) {
+	if count == 0 {
+		return
+	}
+
+	c.stats.wouldEvictions.Add(uint64(count))
+	if c.metrics != nil {
+		c.metrics.WouldEvictCount.Add(float64(count))
+	}
+}
+
+// evictionVictims selects entries which have to be evicted, so number of cached
+// entries does not exceed maxEntries and memory size of cached values does not
+// exceed maxBytes (0 means no limit). Remaining memory size is returned as well.
+// Multiple victims are selected in one pass over all entries. Cache has to be
+// locked.
+func (c *Cache[K, T]) evictionVictims(maxEntries int, maxBytes uint64) (victims []evictionVictim[K, T], memSize uint64) {
+	memSize = c.memSizeValue.Load()
+	overLimits := func(evicted int) bool {
+		return (maxEntries > 0 && len(c.data)-evicted > maxEntries) || (maxBytes > 0 && memSize > maxBytes)
+	}
+
+	if len(c.data) == 0 || !overLimits(0) {
+		return nil, memSize
+	}
+
+	// single entry over capacity (e.g. after insert) is found without sorting
+	if maxBytes == 0 && len(c.data)-maxEntries == 1 {
+		ID, entry := c.evictionCandidate()
+		// only pinned entries are left
+		if entry == nil {
+			return nil, memSize
+		}
+		// size has to be read before the entry is released
+		memSize -= min(memSize, entry.memSize())
+		return []evictionVictim[K, T]{{ID: ID, entry: entry}}, memSize
+	}
+
+	candidates := make([]evictionVictim[K, T], 0, len(c.data))
+	for ID, entry := range c.data {
+		if c.pinned.has(ID) {
+			continue
+		}
+		candidates = append(candidates, evictionVictim[K, T]{ID: ID, entry: entry, lastAccess: entry.lastAccess.Load()})
+	}
+
+	if c.evictionPolicy == EvictionRandom {
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+	} else {
+		slices.SortFunc(candidates, func(a, b evictionVictim[K, T]) int {
+			return cmp.Compare(a.lastAccess, b.lastAccess)
+		})
+	}
+
+	evicted := 0
+	for evicted < len(candidates) && overLimits(evicted) {
+		memSize -= min(memSize, candidates[evicted].entry.memSize())
+		evicted++
+	}
+
+	return candidates[:evicted], memSize
+}
+
+// evictionCandidate returns entry which should be evicted according to eviction
+// policy (nil when all entries are pinned). Cache has to be locked.
+func (c *Cache[K, T]) evictionCandidate() (candidateID K, candidate *cachedEntry[T]) {
+	samples := 0
+	for ID, entry := range c.data {
+		if c.pinned.has(ID) {
+			continue
+		}
+
+		// map iteration starts at random position
+		if c.evictionPolicy == EvictionRandom {
+			return ID, entry
+		}
+
+		if candidate == nil || entry.lastAccess.Load() < candidate.lastAccess.Load() {
+			candidateID, candidate = ID, entry
+		}
+
+		samples++
+		if c.evictionPolicy == EvictionSampledLRU && samples >= c.evictionSamples {
+			break
+		}
+	}
+
+	return
+}
+
+// markAccess stores time of entry access (needed only by eviction and negative
+// compaction). Insertion of an entry counts as access.
+func (c *Cache[K, T]) markAccess(entry *cachedEntry[T], nowMillis int64) {
+	if !c.trackAccess.Load() {
+		return
+	}
+
+	// avoid writes to shared memory when possible
+	if entry.lastAccess.Load() < nowMillis {
+		entry.lastAccess.Store(nowMillis)
+	}
+}
@@ -0,0 +1,33 @@
+package lazy
+
+import (
+	"errors"
+)
+
+// checkFatal disables loading when the load error wraps `ErrFatal`
+func (c *Cache[K, T]) checkFatal(err error) {
+	if err == nil || !errors.Is(err, ErrFatal) {
+		return
+	}
+
+	if !c.fatalErr.CompareAndSwap(nil, &err) {
+		return
+	}
+
+	c.log.Error().Err(err).Msg("fatal loader error, loading of entries is disabled")
+
+	if c.metrics != nil {
+		c.metrics.LoadsDisabled.Set(1)
+	}
+}
+
+// FatalError returns the fatal loader error which disabled loading (see
+// `ErrFatal`) or nil when loading is not disabled.
+func (c *Cache[K, T]) FatalError() error {
+	err := c.fatalErr.Load()
+	if err == nil {
+		return nil
+	}
+
+	return *err
+}
@@ -1,8 +1,6 @@
 module github.com/moderntv/lazy-cache
 
-go 1.21.0
-
-toolchain go1.22.0
+go 1.23.0
 
 require (
 	github.com/moderntv/cadre v0.4.6
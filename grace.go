@@ -0,0 +1,145 @@
+package lazy
+
+import (
+	"sync"
+)
+
+// graceEntry is value of an entry expired by TTL kept in grace ring
+type graceEntry[K comparable, T any] struct {
+	ID         K
+	value      *T // stored (possibly compressed) value
+	lastLoaded int64
+}
+
+// graceRing keeps values of the most recently expired entries (see
+// `Params.GraceEntries`). When the ring is full, the oldest entry is replaced.
+type graceRing[K comparable, T any] struct {
+	mu      sync.Mutex
+	entries []graceEntry[K, T]
+	next    int       // position of the next inserted entry
+	index   map[K]int // positions of kept entries
+}
+
+func newGraceRing[K comparable, T any](size int) *graceRing[K, T] {
+	return &graceRing[K, T]{
+		entries: make([]graceEntry[K, T], size),
+		index:   make(map[K]int, size),
+	}
+}
+
+// put keeps value of the expired entry (replacing the oldest one when full)
+func (r *graceRing[K, T]) put(ID K, value *T, lastLoaded int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pos, exists := r.index[ID]
+	if !exists {
+		pos = r.next
+		r.next = (r.next + 1) % len(r.entries)
+
+		if old := r.entries[pos]; old.value != nil {
+			delete(r.index, old.ID)
+		}
+		r.index[ID] = pos
+	}
+
+	r.entries[pos] = graceEntry[K, T]{ID: ID, value: value, lastLoaded: lastLoaded}
+}
+
+// take removes the entry from the ring and returns it
+func (r *graceRing[K, T]) take(ID K) (graceEntry[K, T], bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pos, exists := r.index[ID]
+	if !exists {
+		return graceEntry[K, T]{}, false
+	}
+
+	entry := r.entries[pos]
+	r.entries[pos] = graceEntry[K, T]{}
+	delete(r.index, ID)
+
+	return entry, true
+}
+
+// clear removes all entries from the ring
+func (r *graceRing[K, T]) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clear(r.entries)
+	clear(r.index)
+}
+
+// keepGraceEntry puts value of the entry expired by TTL into grace ring. Cache
+// has to be locked.
+func (c *Cache[K, T]) keepGraceEntry(ID K, entry *cachedEntry[T]) {
+	if c.grace == nil {
+		return
+	}
+
+	value := entry.value.Load()
+	if value == nil {
+		return
+	}
+
+	c.grace.put(ID, value, entry.lastLoaded.Load())
+}
+
+// dropGraceEntry removes the key from grace ring (its value must not be served
+// anymore)
+func (c *Cache[K, T]) dropGraceEntry(ID K) {
+	if c.grace == nil {
+		return
+	}
+
+	c.grace.take(ID)
+}
+
+// restoreGraceEntry fills new entry with the value kept in grace ring and returns
+// true if there was one. The value is restored as expired (it is reloaded by
+// `revalidateGraceEntry`). Cache has to be locked and the entry not stored yet.
+func (c *Cache[K, T]) restoreGraceEntry(ID K, entry *cachedEntry[T]) bool {
+	if c.grace == nil {
+		return false
+	}
+
+	kept, exists := c.grace.take(ID)
+	if !exists {
+		return false
+	}
+
+	entry.value.Store(kept.value)
+	entry.lastLoaded.Store(kept.lastLoaded)
+
+	if c.indexes.Load() != nil {
+		c.reindex(ID, c.decompress(kept.value))
+	}
+
+	return true
+}
+
+// revalidateGraceEntry returns value of entry restored from grace ring and starts
+// its reload in the background. Entry mutex has to be locked and reference of the
+// entry held by the caller. The mutex is unlocked after the reload.
+func (c *Cache[K, T]) revalidateGraceEntry(ID K, entry *cachedEntry[T], nowMillis int64) *T {
+	c.stats.graceHits.Add(1)
+
+	// restored entry expires after ErrorTTL unless the reload succeeds (e.g. when
+	// loading is paused)
+	ttl := c.timeouts.Load().ErrorTTL
+	entry.expiresAt.Store(nowMillis + ttl.Milliseconds())
+	c.ttlWatcher.Push(ID, ttl)
+
+	value := entry.get()
+
+	// reference of the entry is released after the reload
+	entry.refs.Add(1)
+	c.runInBackground(func() {
+		defer c.releaseEntry(entry)
+		_, _ = c.reloadEntry(c.ctx, ID, entry, nowMillis)
+	})
+
+	return value
+}
@@ -0,0 +1,165 @@
+package lazy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// namespaceLabel is metrics label of caches in a group (see `CacheGroup`)
+const namespaceLabel = "namespace"
+
+// GroupParams configures `CacheGroup`.
+type GroupParams[N comparable, K comparable, T any] struct {
+	// Context of the group, caches of all namespaces are stopped when it is done
+	// (or when the group is closed).
+	Context context.Context
+	// Params are used for caches of all namespaces. Name of namespace cache is
+	// `Name` followed by the namespace (e.g. "users_tenant1") and its metrics are
+	// labeled by "namespace" label. `Context`, `LoadOneFunc` and `LoadBatchFunc`
+	// are set by the group, other hooks are shared by all namespaces. `PreloadChan`
+	// and `Distribution` cannot be set.
+	Params Params[K, T]
+	// LoadOneFunc loads an entry of the namespace.
+	LoadOneFunc func(ns N, ID K) (entry *T, err error)
+	// LoadBatchFunc loads entries of the namespace in one batch (optional, see
+	// `Params.LoadBatchFunc`).
+	LoadBatchFunc func(ns N, IDs []K) (entries []LoadedEntry[K, T])
+}
+
+// CacheGroup manages caches of namespaces (e.g. tenants) sharing the same
+// configuration, but not data. Cache of a namespace is created on its first use
+// and it lives until the group is closed.
+type CacheGroup[N comparable, K comparable, T any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	params GroupParams[N, K, T]
+
+	mu     sync.Mutex
+	caches map[N]*Cache[K, T]
+}
+
+// NewCacheGroup validates params and creates group of caches (caches of namespaces
+// are created lazily).
+func NewCacheGroup[N comparable, K comparable, T any](params GroupParams[N, K, T]) (*CacheGroup[N, K, T], error) {
+	if params.Context == nil {
+		return nil, ErrContextNil
+	}
+
+	if params.Params.Name == "" {
+		return nil, ErrNameEmpty
+	}
+
+	if params.LoadOneFunc == nil {
+		return nil, ErrLoaderNil
+	}
+
+	if params.Params.PreloadChan != nil || params.Params.Distribution != nil {
+		return nil, fmt.Errorf("%w: PreloadChan and Distribution cannot be used by cache group", ErrInvalidParams)
+	}
+
+	if _, exists := params.Params.MetricsLabels[namespaceLabel]; exists {
+		return nil, fmt.Errorf("%w: MetricsLabels of cache group cannot contain namespace label", ErrInvalidParams)
+	}
+
+	g := &CacheGroup[N, K, T]{
+		params: params,
+		caches: make(map[N]*Cache[K, T]),
+	}
+
+	g.ctx, g.cancel = context.WithCancel(params.Context)
+
+	// params are validated before any cache is created
+	probe := g.namespaceParams(*new(N))
+	err := probe.check()
+	if err != nil {
+		g.cancel()
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// namespaceParams returns params of cache of the namespace
+func (g *CacheGroup[N, K, T]) namespaceParams(ns N) Params[K, T] {
+	params := g.params.Params
+	params.Context = g.ctx
+	params.Name = fmt.Sprintf("%s_%v", g.params.Params.Name, ns)
+
+	params.LoadOneFunc = func(ID K) (*T, error) {
+		return g.params.LoadOneFunc(ns, ID)
+	}
+
+	if loadBatch := g.params.LoadBatchFunc; loadBatch != nil {
+		params.LoadBatchFunc = func(IDs []K) []LoadedEntry[K, T] {
+			return loadBatch(ns, IDs)
+		}
+	}
+
+	params.MetricsLabels = make(map[string]string, len(g.params.Params.MetricsLabels)+1)
+	for label, value := range g.params.Params.MetricsLabels {
+		params.MetricsLabels[label] = value
+	}
+	params.MetricsLabels[namespaceLabel] = fmt.Sprint(ns)
+
+	return params
+}
+
+// Cache returns cache of the namespace (it is created when it does not exist yet).
+// `ErrCacheClosed` is returned when the group is closed.
+func (g *CacheGroup[N, K, T]) Cache(ns N) (*Cache[K, T], error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.ctx.Err() != nil {
+		return nil, ErrCacheClosed
+	}
+
+	if c, exists := g.caches[ns]; exists {
+		return c, nil
+	}
+
+	c, err := NewCache(g.namespaceParams(ns))
+	if err != nil {
+		return nil, err
+	}
+	g.caches[ns] = c
+
+	return c, nil
+}
+
+// Get returns value of the entry from cache of the namespace (see `Cache.Get`).
+// Nil is returned when the cache of the namespace cannot be created (e.g. the
+// group is closed).
+func (g *CacheGroup[N, K, T]) Get(ns N, ID K) *T {
+	c, err := g.Cache(ns)
+	if err != nil {
+		return nil
+	}
+
+	return c.Get(ID)
+}
+
+// Namespaces returns namespaces whose caches were created (in no particular order).
+func (g *CacheGroup[N, K, T]) Namespaces() []N {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	namespaces := make([]N, 0, len(g.caches))
+	for ns := range g.caches {
+		namespaces = append(namespaces, ns)
+	}
+
+	return namespaces
+}
+
+// Close stops caches of all namespaces (their background routines exit) and
+// drops them, so their data can be garbage collected. Metrics of the caches stay
+// registered. Caches cannot be used after the group is closed.
+func (g *CacheGroup[N, K, T]) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.cancel()
+	clear(g.caches)
+}
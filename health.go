@@ -0,0 +1,113 @@
+package lazy
+
+import (
+	"sync"
+)
+
+// defaults of `Params.HealthWindow` and `Params.HealthMaxErrorRate`
+const (
+	defaultHealthWindow       = 100
+	defaultHealthMaxErrorRate = 0.5
+)
+
+// HealthStatus summarizes outcomes of recent loads (see `Cache.Health`).
+type HealthStatus struct {
+	// Healthy is false when error rate of recent loads exceeds `HealthMaxErrorRate`
+	// (cache without loads is healthy).
+	Healthy bool
+	// Loads is number of recent loads (at most `HealthWindow`).
+	Loads int
+	// Failures is number of recent loads failed by transient error.
+	Failures int
+	// ErrorRate is ratio of failed recent loads (0 without loads).
+	ErrorRate float64
+}
+
+// health transitions of entries (see `cachedEntry.healthChange`)
+const (
+	healthUnchanged int32 = iota
+	healthRecovered
+	healthFailing
+)
+
+// reportHealth calls `OnHealthChange` when health of the entry changed by its last
+// set. It must be called after the entry is unlocked. When the entry is set again
+// before the transition is reported, only its last transition is reported.
+func (c *Cache[K, T]) reportHealth(ID K, entry *cachedEntry[T]) {
+	if c.onHealthChange == nil {
+		return
+	}
+
+	switch entry.healthChange.Swap(healthUnchanged) {
+	case healthRecovered:
+		c.onHealthChange(ID, true)
+	case healthFailing:
+		c.onHealthChange(ID, false)
+	}
+}
+
+// loadOutcomes keeps outcomes of the most recent loads in a ring
+type loadOutcomes struct {
+	mu           sync.Mutex
+	failed       []bool // true for failed loads
+	next         int    // position of the next outcome
+	loads        int
+	failures     int
+	maxErrorRate float64
+}
+
+func (o *loadOutcomes) init(window int, maxErrorRate float64) {
+	if window == 0 {
+		window = defaultHealthWindow
+	}
+	if maxErrorRate == 0 {
+		maxErrorRate = defaultHealthMaxErrorRate
+	}
+
+	o.failed = make([]bool, window)
+	o.maxErrorRate = maxErrorRate
+}
+
+// add adds outcome of a load (replacing the oldest one when the window is full)
+func (o *loadOutcomes) add(failed bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.loads == len(o.failed) {
+		if o.failed[o.next] {
+			o.failures--
+		}
+	} else {
+		o.loads++
+	}
+
+	o.failed[o.next] = failed
+	if failed {
+		o.failures++
+	}
+	o.next = (o.next + 1) % len(o.failed)
+}
+
+func (o *loadOutcomes) status() HealthStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	status := HealthStatus{
+		Healthy:  true,
+		Loads:    o.loads,
+		Failures: o.failures,
+	}
+	if o.loads > 0 {
+		status.ErrorRate = float64(o.failures) / float64(o.loads)
+		status.Healthy = status.ErrorRate <= o.maxErrorRate
+	}
+
+	return status
+}
+
+// Health reports whether recent loads succeed (see `Params.HealthWindow`), e.g.
+// for readiness checks. Not found entries and permanent errors are counted as
+// successful loads (the backend is reachable). It does not trigger any load.
+func (c *Cache[K, T]) Health() HealthStatus {
+	return c.health.status()
+}
@@ -0,0 +1,204 @@
+package lazy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// recoverHook handles potential panic of user callback (it has to be deferred by
+// the caller of the callback), so the callback cannot break the cache (e.g. leave
+// an entry locked) or kill its watchers. The panic is logged and passed to
+// recovered (when set), which can set fallback results of the callback.
+func (c *Cache[K, T]) recoverHook(hook string, recovered func(p any)) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	c.log.Warn().
+		Interface("err", p).
+		Str("hook", hook).
+		Msg("panic occurred in cache callback")
+
+	if recovered != nil {
+		recovered(p)
+	}
+}
+
+// guardHooks wraps user callbacks of the cache by `recoverHook`. Loaders (including
+// fallbacks) and write-through return `ErrCallbackPanic` when they panic, other callbacks fall
+// back to their default behaviour.
+func (c *Cache[K, T]) guardHooks() {
+	if loadOne := c.loadOneFunc; loadOne != nil {
+		c.loadOneFunc = func(ID K) (entry *T, err error) {
+			defer c.recoverHook("LoadOneFunc", func(p any) {
+				entry, err = nil, fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+			})
+			return loadOne(ID)
+		}
+	}
+
+	if loadOneWithArgs := c.loadOneWithArgs; loadOneWithArgs != nil {
+		c.loadOneWithArgs = func(ID K, args any) (entry *T, err error) {
+			defer c.recoverHook("LoadOneWithArgsFunc", func(p any) {
+				entry, err = nil, fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+			})
+			return loadOneWithArgs(ID, args)
+		}
+	}
+
+	for i, fallback := range c.fallbacks {
+		c.fallbacks[i] = func(ID K) (entry *T, err error) {
+			defer c.recoverHook("Fallbacks", func(p any) {
+				entry, err = nil, fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+			})
+			return fallback(ID)
+		}
+	}
+
+	if loadMultiple := c.loadMultipleFunc; loadMultiple != nil {
+		c.loadMultipleFunc = func(IDs []K) (entries []LoadedEntry[K, T]) {
+			defer c.recoverHook("LoadBatchFunc", func(p any) {
+				err := fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+				entries = make([]LoadedEntry[K, T], 0, len(IDs))
+				for _, ID := range IDs {
+					entries = append(entries, LoadedEntry[K, T]{ID: ID, Err: err})
+				}
+			})
+			return loadMultiple(IDs)
+		}
+	}
+
+	if postLoad := c.postLoadFunc; postLoad != nil {
+		c.postLoadFunc = func(ID K, value *T, err error) (postValue *T, postErr error) {
+			defer c.recoverHook("PostLoad", func(p any) {
+				postValue, postErr = nil, fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+			})
+			return postLoad(ID, value, err)
+		}
+	}
+
+	if writeThrough := c.writeThrough; writeThrough != nil {
+		c.writeThrough = func(ID K, value *T) (err error) {
+			defer c.recoverHook("WriteThrough", func(p any) {
+				err = fmt.Errorf("%w: %v", ErrCallbackPanic, p)
+			})
+			return writeThrough(ID, value)
+		}
+	}
+
+	if equal := c.equal; equal != nil {
+		c.equal = func(old, new *T) (same bool) {
+			// values which cannot be compared are considered changed
+			defer c.recoverHook("Equal", func(any) {
+				same = false
+			})
+			return equal(old, new)
+		}
+	}
+
+	if clone := c.clone; clone != nil {
+		c.clone = func(value *T) (cloned *T) {
+			// value which cannot be cloned is returned shared
+			defer c.recoverHook("Clone", func(any) {
+				cloned = value
+			})
+			return clone(value)
+		}
+	}
+
+	if classifyError := c.classifyError; classifyError != nil {
+		c.classifyError = func(err error) (class ErrorClass) {
+			defer c.recoverHook("ClassifyError", func(any) {
+				class = DefaultClassifyError(err)
+			})
+			return classifyError(err)
+		}
+	}
+
+	if permanentErrorTTL := c.permanentErrorTTL; permanentErrorTTL != nil {
+		c.permanentErrorTTL = func(err error) (ttl time.Duration, ok bool) {
+			defer c.recoverHook("PermanentErrorTTL", func(any) {
+				ttl, ok = 0, false
+			})
+			return permanentErrorTTL(err)
+		}
+	}
+
+	if shouldNegativeCache := c.shouldNegativeCache; shouldNegativeCache != nil {
+		c.shouldNegativeCache = func(ID K) (should bool) {
+			defer c.recoverHook("ShouldNegativeCache", func(any) {
+				should = true
+			})
+			return shouldNegativeCache(ID)
+		}
+	}
+
+	if validKey := c.validKey; validKey != nil {
+		c.validKey = func(ID K) (valid bool) {
+			// key which cannot be validated is rejected
+			defer c.recoverHook("ValidKey", func(any) {
+				valid = false
+			})
+			return validKey(ID)
+		}
+	}
+
+	if normalize := c.normalize; normalize != nil {
+		c.normalize = func(ID K) (normalized K) {
+			defer c.recoverHook("NormalizeKey", func(any) {
+				normalized = ID
+			})
+			return normalize(ID)
+		}
+	}
+
+	if onHealthChange := c.onHealthChange; onHealthChange != nil {
+		c.onHealthChange = func(ID K, healthy bool) {
+			defer c.recoverHook("OnHealthChange", nil)
+			onHealthChange(ID, healthy)
+		}
+	}
+
+	if onTTLAssigned := c.onTTLAssigned; onTTLAssigned != nil {
+		c.onTTLAssigned = func(ID K, ttl, reloadInterval time.Duration) {
+			defer c.recoverHook("OnTTLAssigned", nil)
+			onTTLAssigned(ID, ttl, reloadInterval)
+		}
+	}
+
+	if onLoadStart := c.onLoadStart; onLoadStart != nil {
+		c.onLoadStart = func(ctx context.Context, ID K) (loadCtx context.Context) {
+			defer c.recoverHook("OnLoadStart", func(any) {
+				loadCtx = ctx
+			})
+			return onLoadStart(ctx, ID)
+		}
+	}
+
+	if onLoadEnd := c.onLoadEnd; onLoadEnd != nil {
+		c.onLoadEnd = func(ctx context.Context, ID K, err error) {
+			defer c.recoverHook("OnLoadEnd", nil)
+			onLoadEnd(ctx, ID, err)
+		}
+	}
+
+	if reloadAt := c.reloadAt; reloadAt != nil {
+		c.reloadAt = func(ID K, value *T) (at time.Time) {
+			defer c.recoverHook("ReloadAt", func(any) {
+				at = time.Time{}
+			})
+			return reloadAt(ID, value)
+		}
+	}
+
+	if expireAt := c.expireAt; expireAt != nil {
+		c.expireAt = func(ID K, value *T) (at time.Time, ok bool) {
+			defer c.recoverHook("ExpireAt", func(any) {
+				at, ok = time.Time{}, false
+			})
+			return expireAt(ID, value)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package lazy
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// cacheIndex is secondary index of cached values
+type cacheIndex[K comparable, T any] interface {
+	// store updates index by new value of the entry (nil when the value was cleared)
+	store(ID K, value *T)
+	// remove removes the entry from index
+	remove(ID K)
+}
+
+// index maps keys derived from cached values to primary keys
+type index[K2 comparable, K comparable, T any] struct {
+	extract func(value *T) (K2, bool)
+	mu      sync.RWMutex
+	primary map[K2]K // derived key -> primary key
+	derived map[K]K2 // primary key -> derived key
+}
+
+func (idx *index[K2, K, T]) store(ID K, value *T) {
+	var key K2
+	ok := false
+	if value != nil {
+		key, ok = idx.extract(value)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(ID)
+	if ok {
+		idx.primary[key] = ID
+		idx.derived[ID] = key
+	}
+}
+
+func (idx *index[K2, K, T]) remove(ID K) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(ID)
+}
+
+func (idx *index[K2, K, T]) removeLocked(ID K) {
+	key, exists := idx.derived[ID]
+	if !exists {
+		return
+	}
+
+	delete(idx.derived, ID)
+	// derived key may already point to other entry
+	if idx.primary[key] == ID {
+		delete(idx.primary, key)
+	}
+}
+
+func (idx *index[K2, K, T]) lookup(key K2) (ID K, exists bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ID, exists = idx.primary[key]
+	return
+}
+
+// AddIndex adds secondary index of cached values to the cache. The index maps keys
+// derived from values by extract (values for which extract returns false are not
+// indexed) to primary keys of their entries, so the values can be read by
+// `GetByIndex` without caching them twice. Values already cached are indexed
+// immediately. Index is updated whenever value of an entry is (re)loaded or set
+// and when the entry is removed, so it reflects changes of the derived key on next
+// load of the primary entry.
+func AddIndex[K2 comparable, K comparable, T any](c *Cache[K, T], name string, extract func(value *T) (K2, bool)) error {
+	idx := &index[K2, K, T]{
+		// values which cannot be indexed because of panic are not indexed
+		extract: func(value *T) (key K2, ok bool) {
+			defer c.recoverHook("AddIndex", func(any) {
+				ok = false
+			})
+			return extract(value)
+		},
+		primary: make(map[K2]K),
+		derived: make(map[K]K2),
+	}
+
+	c.indexesMu.Lock()
+	indexes := make(map[string]cacheIndex[K, T])
+	if current := c.indexes.Load(); current != nil {
+		if _, exists := (*current)[name]; exists {
+			c.indexesMu.Unlock()
+			return fmt.Errorf("%w: index %q already exists", ErrInvalidParams, name)
+		}
+		maps.Copy(indexes, *current)
+	}
+	indexes[name] = idx
+	c.indexes.Store(&indexes)
+	c.indexesMu.Unlock()
+
+	// index already cached values
+	c.mu.RLock()
+	for ID, entry := range c.data {
+		if value := entry.value.Load(); value != nil {
+			idx.store(ID, c.decompress(value))
+		}
+	}
+	c.mu.RUnlock()
+
+	return nil
+}
+
+// GetByIndex returns value of the entry whose value has given derived key in
+// index added by `AddIndex` (nil when no cached value has the key or the index
+// does not exist). The entry is read by `Get`, so expired entry is reloaded (and
+// nil is returned when its derived key changed).
+func GetByIndex[K2 comparable, K comparable, T any](c *Cache[K, T], name string, key K2) *T {
+	indexes := c.indexes.Load()
+	if indexes == nil {
+		return nil
+	}
+
+	idx, ok := (*indexes)[name].(*index[K2, K, T])
+	if !ok {
+		return nil
+	}
+
+	ID, exists := idx.lookup(key)
+	if !exists {
+		return nil
+	}
+
+	value := c.Get(ID)
+	if value == nil {
+		return nil
+	}
+
+	if valueKey, ok := idx.extract(value); !ok || valueKey != key {
+		return nil
+	}
+
+	return value
+}
+
+// reindex updates all secondary indexes by new value of the entry (nil when the
+// entry has no value)
+func (c *Cache[K, T]) reindex(ID K, value *T) {
+	indexes := c.indexes.Load()
+	if indexes == nil {
+		return
+	}
+
+	for _, idx := range *indexes {
+		idx.store(ID, value)
+	}
+}
+
+// unindex removes the entry from all secondary indexes
+func (c *Cache[K, T]) unindex(ID K) {
+	indexes := c.indexes.Load()
+	if indexes == nil {
+		return
+	}
+
+	for _, idx := range *indexes {
+		idx.remove(ID)
+	}
+}
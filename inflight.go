@@ -0,0 +1,52 @@
+package lazy
+
+import "sync"
+
+// inFlightSet tracks keys which are being loaded by loaders (for diagnostics).
+// Loads of different keys do not contend for a lock.
+type inFlightSet[K comparable] struct {
+	keys sync.Map // number of running loads (int) of the key
+}
+
+func (s *inFlightSet[K]) add(IDs ...K) {
+	for _, ID := range IDs {
+		for {
+			count, loaded := s.keys.LoadOrStore(ID, 1)
+			if !loaded || s.keys.CompareAndSwap(ID, count, count.(int)+1) {
+				break
+			}
+		}
+	}
+}
+
+func (s *inFlightSet[K]) remove(IDs ...K) {
+	for _, ID := range IDs {
+		for {
+			count, exists := s.keys.Load(ID)
+			if !exists {
+				break
+			}
+
+			if count.(int) <= 1 {
+				if s.keys.CompareAndDelete(ID, count) {
+					break
+				}
+			} else if s.keys.CompareAndSwap(ID, count, count.(int)-1) {
+				break
+			}
+		}
+	}
+}
+
+// InFlight returns keys which are currently being loaded by `LoadOneFunc` or
+// `LoadBatchFunc` (in no particular order). It is meant for debugging of stuck
+// loads.
+func (c *Cache[K, T]) InFlight() []K {
+	IDs := make([]K, 0)
+	c.inFlight.keys.Range(func(ID, _ any) bool {
+		IDs = append(IDs, ID.(K))
+		return true
+	})
+
+	return IDs
+}
@@ -1,6 +1,7 @@
 package invalidation
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -52,6 +53,52 @@ func (h *NatsHelper) Publish(subject string, data proto.Message) (err error) {
 	return
 }
 
+// PublishData broadcasts NATS message with raw data.
+// Returns error when message was not broadcasted. Otherwise
+// returns nil.
+func (h *NatsHelper) PublishData(subject string, data []byte) (err error) {
+	err = h.connection.Publish(h.prefix+subject, data)
+	if err != nil {
+		err = fmt.Errorf("cannot publish message: %w", err)
+		return
+	}
+
+	return
+}
+
+// SubscribeData receives raw messages from NATS and with each message
+// calls `cb` function. The subscription is cancelled when ctx is done.
+// When SubscribeData fails, function automatically tries to subscribe again
+// after 31 seconds until it succeeds (or ctx is done).
+func (h *NatsHelper) SubscribeData(ctx context.Context, subject string, cb func([]byte)) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	subs, err := h.connection.Subscribe(h.prefix+subject, func(natsMsg *nats.Msg) {
+		h.log.Trace().
+			Str("subject", subject).
+			Msg("message received")
+		cb(natsMsg.Data)
+	})
+	if err != nil {
+		h.log.Error().
+			Err(err).
+			Str("subject", subject).
+			Msg("cannot subscribe to NATS server")
+		_ = subs.Unsubscribe() // ignore error
+		// try to subscribe again after 31 sec
+		time.AfterFunc(31*time.Second, func() {
+			h.SubscribeData(ctx, subject, cb)
+		})
+		return
+	}
+
+	context.AfterFunc(ctx, func() {
+		_ = subs.Unsubscribe() // ignore error
+	})
+}
+
 // Subscribe receives messages from NATS and with each message
 // calls `cb` function.
 // When Subscribe fails, function automatically tries to subscribe again
@@ -1,6 +1,10 @@
 package lazy
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+
 	cadre_metrics "github.com/moderntv/cadre/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -11,6 +15,13 @@ const (
 	labelName     = "name"
 )
 
+// ErrAlreadyRegistered is returned when metrics with the same name are already
+// registered in the registry.
+var ErrAlreadyRegistered = errors.New("metrics already registered")
+
+// registryMu serializes registrations (registry is not safe for concurrent use)
+var registryMu sync.Mutex
+
 type Metrics struct {
 	ItemsCount                prometheus.Gauge
 	AutomaticLoadCount        prometheus.Counter
@@ -19,97 +30,369 @@ type Metrics struct {
 	ReadsCount                prometheus.Counter
 	ReceivedNatsInvalidations prometheus.Counter
 	MemoryUsage               prometheus.Gauge
+	DedupedLoadCount          prometheus.Counter
+	RejectedInsertionCount    prometheus.Counter
+	CircuitOpenCount          prometheus.Counter
+	CircuitSkippedReloadCount prometheus.Counter
+	PreloadCount              prometheus.Counter
+	EvictionCount             prometheus.Counter
+	ChangedReloadCount        prometheus.Counter
+	UnchangedReloadCount      prometheus.Counter
+	WouldEvictCount           prometheus.Counter
+	AccessedItemsCount        prometheus.Gauge
+	UnaccessedItemsCount      prometheus.Gauge
+	BackgroundLoadsRunning    prometheus.Gauge
+	BackgroundLoadsQueued     prometheus.Gauge
+	LoadsDisabled             prometheus.Gauge
+	AcquiredLoadSlots         prometheus.Gauge
+	LoadSlotsWait             prometheus.Counter
+	MapCompactions            prometheus.Counter
 }
 
 func New(
 	name string,
+	labels map[string]string,
 	registry *cadre_metrics.Registry,
-) (m *Metrics, err error) {
-	itemsCount := registry.NewGauge(prometheus.GaugeOpts{
+) (*Metrics, error) {
+	m := create(name, labels, registry.NewGauge, registry.NewCounter)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	// check before registration, so metrics are not registered partially
+	_, err := registry.Get(metricsPrefix + name + "_items_count")
+	if err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyRegistered, name)
+	}
+
+	err = registry.Register(metricsPrefix+name+"_items_count", m.ItemsCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_automatic_load_count", m.AutomaticLoadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_lazy_load_count", m.LazyLoadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_error_load_count", m.ErrorLoadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_reads_count", m.ReadsCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_received_nats_invalidations", m.ReceivedNatsInvalidations)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_memory_usage", m.MemoryUsage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_deduped_load_count", m.DedupedLoadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_rejected_insertion_count", m.RejectedInsertionCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_circuit_open_count", m.CircuitOpenCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_preload_count", m.PreloadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_eviction_count", m.EvictionCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_circuit_skipped_reload_count", m.CircuitSkippedReloadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_changed_reload_count", m.ChangedReloadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_unchanged_reload_count", m.UnchangedReloadCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_would_evict_count", m.WouldEvictCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_accessed_items_count", m.AccessedItemsCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_unaccessed_items_count", m.UnaccessedItemsCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_background_loads_running", m.BackgroundLoadsRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_background_loads_queued", m.BackgroundLoadsQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_loads_disabled", m.LoadsDisabled)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_load_slots_acquired", m.AcquiredLoadSlots)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_load_slots_wait_seconds", m.LoadSlotsWait)
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.Register(metricsPrefix+name+"_map_compactions", m.MapCompactions)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// NewWithRegisterer creates metrics of the cache (without namespace) and registers
+// them into plain prometheus registerer. Metrics are not registered partially.
+func NewWithRegisterer(name string, labels map[string]string, registerer prometheus.Registerer) (*Metrics, error) {
+	m := create(name, labels, prometheus.NewGauge, prometheus.NewCounter)
+
+	collectors := m.Collectors()
+	for i, collector := range collectors {
+		err := registerer.Register(collector)
+		if err != nil {
+			for _, registered := range collectors[:i] {
+				registerer.Unregister(registered)
+			}
+
+			if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+				err = fmt.Errorf("%w: %s", ErrAlreadyRegistered, name)
+			}
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// create creates metrics of the cache by given constructors (labels are added to
+// constant labels of all metrics)
+func create(
+	name string,
+	labels map[string]string,
+	newGauge func(opts prometheus.GaugeOpts) prometheus.Gauge,
+	newCounter func(opts prometheus.CounterOpts) prometheus.Counter,
+) *Metrics {
+	constLabels := prometheus.Labels{labelName: name}
+	for label, value := range labels {
+		constLabels[label] = value
+	}
+
+	itemsCount := newGauge(prometheus.GaugeOpts{
 		Subsystem:   subSystem,
 		Name:        "items_count",
 		Help:        "Current number of cached items",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	automaticLoadCount := registry.NewCounter(prometheus.CounterOpts{
+	automaticLoadCount := newCounter(prometheus.CounterOpts{
 		Subsystem:   subSystem,
 		Name:        "automatic_loads",
-		Help:        "Total number of automatic item loads (including preloading)",
-		ConstLabels: prometheus.Labels{labelName: name},
+		Help:        "Total number of automatic item reloads (preloading is counted by preloads)",
+		ConstLabels: constLabels,
 	})
 
-	lazyLoadCount := registry.NewCounter(prometheus.CounterOpts{
+	lazyLoadCount := newCounter(prometheus.CounterOpts{
 		Subsystem:   subSystem,
 		Name:        "lazy_loads",
 		Help:        "Total number of lazy item loads (triggered by user request)",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	errorLoadCount := registry.NewCounter(prometheus.CounterOpts{
+	errorLoadCount := newCounter(prometheus.CounterOpts{
 		Subsystem:   subSystem,
 		Name:        "error_loads",
 		Help:        "Count of item loads which ended with an error (except not found)",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	readsCount := registry.NewCounter(prometheus.CounterOpts{
+	readsCount := newCounter(prometheus.CounterOpts{
 		Subsystem:   subSystem,
 		Name:        "reads_count",
 		Help:        "Total number of item  when item was found in cache",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	receivedNatsInvalidations := registry.NewCounter(prometheus.CounterOpts{
+	receivedNatsInvalidations := newCounter(prometheus.CounterOpts{
 		Subsystem:   subSystem,
 		Name:        "received_nats_invalidations",
 		Help:        "Total number of received invalidations",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	memoryUsage := registry.NewGauge(prometheus.GaugeOpts{
+	memoryUsage := newGauge(prometheus.GaugeOpts{
 		Subsystem:   subSystem,
 		Name:        "memory_usage",
 		Help:        "Current memory usage in bytes by cache",
-		ConstLabels: prometheus.Labels{labelName: name},
+		ConstLabels: constLabels,
 	})
 
-	err = registry.Register(metricsPrefix+name+"_items_count", itemsCount)
-	if err != nil {
-		return
-	}
+	dedupedLoadCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "deduped_loads",
+		Help:        "Total number of item loads avoided because item was loaded by other routine meanwhile",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_automatic_load_count", automaticLoadCount)
-	if err != nil {
-		return
-	}
+	rejectedInsertionCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "rejected_insertions",
+		Help:        "Total number of items not inserted because memory usage exceeded hard ceiling",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_lazy_load_count", lazyLoadCount)
-	if err != nil {
-		return
-	}
+	circuitOpenCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "circuit_open_skips",
+		Help:        "Total number of item loads skipped because circuit breaker was open",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_error_load_count", errorLoadCount)
-	if err != nil {
-		return
-	}
+	preloadCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "preloads",
+		Help:        "Total number of items received from preload channel",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_reads_count", readsCount)
-	if err != nil {
-		return
-	}
+	evictionCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "evictions",
+		Help:        "Total number of items evicted because cache reached maximum number of items",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_received_nats_invalidations", receivedNatsInvalidations)
-	if err != nil {
-		return
-	}
+	circuitSkippedReloadCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "circuit_skipped_reloads",
+		Help:        "Total number of automatic item reloads postponed because circuit breaker was open",
+		ConstLabels: constLabels,
+	})
 
-	err = registry.Register(metricsPrefix+name+"_memory_usage", memoryUsage)
-	if err != nil {
-		return
-	}
+	changedReloadCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "changed_reloads",
+		Help:        "Total number of item reloads which changed the value (counted only when Equal is set)",
+		ConstLabels: constLabels,
+	})
+
+	unchangedReloadCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "unchanged_reloads",
+		Help:        "Total number of item reloads which loaded the same value (counted only when Equal is set)",
+		ConstLabels: constLabels,
+	})
+
+	wouldEvictCount := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "would_evictions",
+		Help:        "Total number of items which would be evicted if capacity dry run was disabled",
+		ConstLabels: constLabels,
+	})
+
+	accessedItemsCount := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "accessed_items_count",
+		Help:        "Number of cached items accessed since their last load (measured together with memory usage)",
+		ConstLabels: constLabels,
+	})
+
+	unaccessedItemsCount := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "unaccessed_items_count",
+		Help:        "Number of cached items not accessed since their last load (measured together with memory usage)",
+		ConstLabels: constLabels,
+	})
+
+	backgroundLoadsRunning := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "background_loads_running",
+		Help:        "Number of running background (re)loads",
+		ConstLabels: constLabels,
+	})
 
-	m = &Metrics{
+	backgroundLoadsQueued := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "background_loads_queued",
+		Help:        "Number of background (re)loads waiting for a free slot",
+		ConstLabels: constLabels,
+	})
+
+	loadsDisabled := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "loads_disabled",
+		Help:        "1 when loading of items is disabled by fatal loader error",
+		ConstLabels: constLabels,
+	})
+
+	acquiredLoadSlots := newGauge(prometheus.GaugeOpts{
+		Subsystem:   subSystem,
+		Name:        "load_slots_acquired",
+		Help:        "Number of loader calls holding a slot of load limiter",
+		ConstLabels: constLabels,
+	})
+
+	loadSlotsWait := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "load_slots_wait_seconds",
+		Help:        "Total time loader calls waited for a slot of load limiter",
+		ConstLabels: constLabels,
+	})
+
+	mapCompactions := newCounter(prometheus.CounterOpts{
+		Subsystem:   subSystem,
+		Name:        "map_compactions",
+		Help:        "Number of rebuilds of the map of cached items releasing memory of removed items",
+		ConstLabels: constLabels,
+	})
+
+	return &Metrics{
 		ItemsCount:                itemsCount,
 		AutomaticLoadCount:        automaticLoadCount,
 		LazyLoadCount:             lazyLoadCount,
@@ -117,6 +400,52 @@ func New(
 		ReadsCount:                readsCount,
 		ReceivedNatsInvalidations: receivedNatsInvalidations,
 		MemoryUsage:               memoryUsage,
+		DedupedLoadCount:          dedupedLoadCount,
+		RejectedInsertionCount:    rejectedInsertionCount,
+		CircuitOpenCount:          circuitOpenCount,
+		CircuitSkippedReloadCount: circuitSkippedReloadCount,
+		PreloadCount:              preloadCount,
+		EvictionCount:             evictionCount,
+		ChangedReloadCount:        changedReloadCount,
+		UnchangedReloadCount:      unchangedReloadCount,
+		WouldEvictCount:           wouldEvictCount,
+		AccessedItemsCount:        accessedItemsCount,
+		UnaccessedItemsCount:      unaccessedItemsCount,
+		BackgroundLoadsRunning:    backgroundLoadsRunning,
+		BackgroundLoadsQueued:     backgroundLoadsQueued,
+		LoadsDisabled:             loadsDisabled,
+		AcquiredLoadSlots:         acquiredLoadSlots,
+		LoadSlotsWait:             loadSlotsWait,
+		MapCompactions:            mapCompactions,
+	}
+}
+
+// Collectors returns all collectors of the metrics.
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.ItemsCount,
+		m.AutomaticLoadCount,
+		m.LazyLoadCount,
+		m.ErrorLoadCount,
+		m.ReadsCount,
+		m.ReceivedNatsInvalidations,
+		m.MemoryUsage,
+		m.DedupedLoadCount,
+		m.RejectedInsertionCount,
+		m.CircuitOpenCount,
+		m.CircuitSkippedReloadCount,
+		m.PreloadCount,
+		m.EvictionCount,
+		m.ChangedReloadCount,
+		m.UnchangedReloadCount,
+		m.WouldEvictCount,
+		m.AccessedItemsCount,
+		m.UnaccessedItemsCount,
+		m.BackgroundLoadsRunning,
+		m.BackgroundLoadsQueued,
+		m.LoadsDisabled,
+		m.AcquiredLoadSlots,
+		m.LoadSlotsWait,
+		m.MapCompactions,
 	}
-	return
 }
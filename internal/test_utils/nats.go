@@ -52,3 +52,24 @@ func NatsConnection(t *testing.T) *nats.Conn {
 
 	return connection
 }
+
+// NatsConnections returns `count` independent connections to one NATS server
+// (e.g. to simulate multiple application instances).
+func NatsConnections(t *testing.T, count int) []*nats.Conn {
+	s := natstest.RunRandClientPortServer()
+
+	connections := make([]*nats.Conn, 0, count)
+	for i := 0; i < count; i++ {
+		nc, err := nats.Connect(s.ClientURL(), nats.NoEcho())
+		assert.NoError(t, err)
+		connections = append(connections, nc)
+	}
+
+	t.Cleanup(func() {
+		for _, nc := range connections {
+			nc.Close()
+		}
+		s.Shutdown()
+	})
+	return connections
+}
@@ -5,15 +5,29 @@ import (
 	"time"
 )
 
+// MinRandomizedDuration is the lowest duration RandomizeDuration returns for
+// positive input duration. It prevents randomized durations from collapsing to
+// zero (e.g. immediate reloads in a tight loop) with aggressive randomizer.
+const MinRandomizedDuration = time.Millisecond
+
+// RandomizeDuration returns duration `d` randomly modified by +/- `d` * `randomizer`.
+// For positive `d` the result is never less than MinRandomizedDuration, so it stays
+// positive even when converted to whole milliseconds (as the cache stores times).
+// Zero duration is returned unchanged.
 func RandomizeDuration(d time.Duration, randomizer float64) time.Duration {
-	if randomizer == 0 {
-		return d
+	randomized := d
+	if randomizer != 0 {
+		add := time.Duration(float64(d) * rand.Float64() * randomizer)
+		if rand.Intn(2) == 0 {
+			add = -add
+		}
+		randomized += add
 	}
 
-	add := time.Duration(float64(d) * rand.Float64() * randomizer)
-	if rand.Intn(2) == 0 {
-		add = -add
+	// floor is applied to milliseconds, sub-millisecond duration would be zero
+	if d > 0 && randomized.Milliseconds() < MinRandomizedDuration.Milliseconds() {
+		randomized = MinRandomizedDuration
 	}
 
-	return d + add
+	return randomized
 }
@@ -22,6 +22,23 @@ func TestRandomDuration(t *testing.T) {
 	assert.Greater(t, len(results), treshhold)
 }
 
+func TestRandomDurationPositive(t *testing.T) {
+	tries := 1000000
+
+	for _, d := range []time.Duration{time.Nanosecond, 500 * time.Microsecond, 10 * time.Millisecond, 10 * time.Second} {
+		for i := 0; i < tries; i++ {
+			randomized := RandomizeDuration(d, 1)
+			if randomized.Milliseconds() < 1 || randomized > max(2*d, MinRandomizedDuration) {
+				assert.Fail(t, "randomized duration out of range", "duration: %s, randomized: %s", d, randomized)
+				break
+			}
+		}
+	}
+
+	assert.Equal(t, time.Duration(0), RandomizeDuration(0, 1))
+	assert.Equal(t, MinRandomizedDuration, RandomizeDuration(500*time.Microsecond, 0))
+}
+
 func BenchmarkAtomicWrite(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = RandomizeDuration(10*time.Second, 0.2)
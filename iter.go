@@ -0,0 +1,69 @@
+package lazy
+
+import (
+	"iter"
+	"slices"
+)
+
+// All returns iterator over cached entries with value (not found entries and
+// entries being loaded for the first time are skipped):
+//
+//	for ID, value := range cache.All() { ... }
+//
+// Keys are snapshotted when the iteration starts (the cache is not locked while
+// the loop body runs), entries removed during iteration are skipped. Values are
+// point-in-time and can be stale by the time the loop body runs. Iteration does
+// not trigger loads nor marks entries as accessed.
+func (c *Cache[K, T]) All() iter.Seq2[K, *T] {
+	return func(yield func(K, *T) bool) {
+		c.mu.RLock()
+		IDs := make([]K, 0, len(c.data))
+		for ID := range c.data {
+			IDs = append(IDs, ID)
+		}
+		c.mu.RUnlock()
+
+		for _, ID := range IDs {
+			var value *T
+			c.mu.RLock()
+			entry, exists := c.data[ID]
+			if exists {
+				value = entry.value.Load()
+			}
+			c.mu.RUnlock()
+
+			if value == nil {
+				continue
+			}
+
+			if !yield(ID, c.output(value)) {
+				return
+			}
+		}
+	}
+}
+
+// KeysSorted returns keys of all cached entries (including not found entries and
+// entries being loaded) sorted by less, e.g. for reproducible exports. Keys are
+// snapshotted under the cache lock and sorted afterwards without holding it.
+func (c *Cache[K, T]) KeysSorted(less func(a, b K) bool) []K {
+	c.mu.RLock()
+	IDs := make([]K, 0, len(c.data))
+	for ID := range c.data {
+		IDs = append(IDs, ID)
+	}
+	c.mu.RUnlock()
+
+	slices.SortFunc(IDs, func(a, b K) int {
+		switch {
+		case less(a, b):
+			return -1
+		case less(b, a):
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return IDs
+}
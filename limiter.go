@@ -0,0 +1,122 @@
+package lazy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoadLimiter limits number of concurrent loader calls (see `Params.LoadLimiter`).
+// One limiter can be shared by multiple caches, e.g. caches loading from the same
+// database, so together they do not use more connections than its pool has.
+type LoadLimiter struct {
+	slots chan struct{}
+}
+
+// NewLoadLimiter creates limiter allowing given number of concurrent loader calls
+// (size lower than 1 is treated as 1).
+func NewLoadLimiter(size int) *LoadLimiter {
+	return &LoadLimiter{
+		slots: make(chan struct{}, max(size, 1)),
+	}
+}
+
+// Size returns maximal number of concurrent loader calls.
+func (l *LoadLimiter) Size() int {
+	return cap(l.slots)
+}
+
+// InUse returns number of loader calls currently holding a slot.
+func (l *LoadLimiter) InUse() int {
+	return len(l.slots)
+}
+
+// acquire waits for a free slot (error of the context is returned when it is
+// done first)
+func (l *LoadLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *LoadLimiter) release() {
+	<-l.slots
+}
+
+// acquireLoadSlot waits for a slot of the load limiter (when set). The slot has to
+// be released by `releaseLoadSlot` after the loader call. `ErrCacheClosed` is
+// returned when the cache is stopped during waiting.
+func (c *Cache[K, T]) acquireLoadSlot() error {
+	if c.loadLimiter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	err := c.loadLimiter.acquire(c.ctx)
+	wait := time.Since(start)
+
+	c.stats.loadSlotsWait.Add(uint64(wait))
+	if c.metrics != nil {
+		c.metrics.LoadSlotsWait.Add(wait.Seconds())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCacheClosed, err)
+	}
+
+	c.stats.acquiredLoadSlots.Add(1)
+	if c.metrics != nil {
+		c.metrics.AcquiredLoadSlots.Inc()
+	}
+
+	return nil
+}
+
+func (c *Cache[K, T]) releaseLoadSlot() {
+	if c.loadLimiter == nil {
+		return
+	}
+
+	c.loadLimiter.release()
+
+	c.stats.acquiredLoadSlots.Add(-1)
+	if c.metrics != nil {
+		c.metrics.AcquiredLoadSlots.Dec()
+	}
+}
+
+// limitedLoadOne calls the loader within a slot of the load limiter
+func (c *Cache[K, T]) limitedLoadOne(load LoadOneFunc[K, T], ID K) (*T, error) {
+	err := c.acquireLoadSlot()
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseLoadSlot()
+
+	return load(ID)
+}
+
+// limitedLoadMultiple calls `LoadBatchFunc` within a slot of the load limiter
+// (all entries fail when the slot cannot be acquired)
+func (c *Cache[K, T]) limitedLoadMultiple(IDs []K) []LoadedEntry[K, T] {
+	err := c.acquireLoadSlot()
+	if err != nil {
+		loadedEntries := make([]LoadedEntry[K, T], len(IDs))
+		for i, ID := range IDs {
+			loadedEntries[i] = LoadedEntry[K, T]{ID: ID, Err: err}
+		}
+		return loadedEntries
+	}
+	defer c.releaseLoadSlot()
+
+	return c.loadMultipleFunc(IDs)
+}
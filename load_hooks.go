@@ -0,0 +1,57 @@
+package lazy
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// kinds of loads logged by `logSlowLoad`
+const (
+	loadKindOne      = "one"
+	loadKindMultiple = "multiple"
+)
+
+// startLoad calls `OnLoadStart` hook (when set) and returns context for `endLoad`
+func (c *Cache[K, T]) startLoad(ctx context.Context, ID K) context.Context {
+	if c.onLoadStart == nil {
+		return ctx
+	}
+
+	loadCtx := c.onLoadStart(ctx, ID)
+	if loadCtx == nil {
+		return ctx
+	}
+
+	return loadCtx
+}
+
+// endLoad calls `OnLoadEnd` hook (when set) with context returned by `startLoad`
+func (c *Cache[K, T]) endLoad(ctx context.Context, ID K, err error) {
+	if c.onLoadEnd == nil {
+		return
+	}
+
+	c.onLoadEnd(ctx, ID, err)
+}
+
+// logSlowLoad logs warning when the load started at start took longer than
+// `SlowLoadThreshold` (fields identifying the load are added by fields)
+func (c *Cache[K, T]) logSlowLoad(kind string, start time.Time, fields func(e *zerolog.Event)) {
+	if c.slowLoadThreshold == 0 {
+		return
+	}
+
+	duration := time.Since(start)
+	if duration <= c.slowLoadThreshold {
+		return
+	}
+
+	e := c.log.Warn().
+		Str("kind", kind).
+		Dur("duration", duration).
+		Dur("threshold", c.slowLoadThreshold)
+	fields(e)
+	e.Msg("slow load")
+}
@@ -0,0 +1,57 @@
+package lazy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/moderntv/lazy-cache/internal/memsize"
+)
+
+// minMemsizeShard is minimal number of values measured by one routine (smaller
+// caches are measured by one routine)
+const minMemsizeShard = 1000
+
+// measureValues returns memory size of the values. Values are split into shards
+// measured concurrently by up to `MemsizeWorkers` routines. Panic of any routine
+// is propagated to the caller (after all routines finish).
+func (c *Cache[K, T]) measureValues(values []*T) (size uint64) {
+	workers := min(c.memsizeWorkers, len(values)/minMemsizeShard)
+	if workers <= 1 {
+		for _, value := range values {
+			size += memsize.Entry(value)
+		}
+		return size
+	}
+
+	shard := (len(values) + workers - 1) / workers
+
+	var total atomic.Uint64
+	var recovered atomic.Value
+	var wg sync.WaitGroup
+	for start := 0; start < len(values); start += shard {
+		part := values[start:min(start+shard, len(values))]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					recovered.Store(err)
+				}
+			}()
+
+			var partSize uint64
+			for _, value := range part {
+				partSize += memsize.Entry(value)
+			}
+			total.Add(partSize)
+		}()
+	}
+	wg.Wait()
+
+	if err := recovered.Load(); err != nil {
+		panic(err)
+	}
+
+	return total.Load()
+}
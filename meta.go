@@ -0,0 +1,64 @@
+package lazy
+
+import "time"
+
+// EntryMeta describes state of an entry returned by `GetWithMeta`.
+type EntryMeta struct {
+	// LoadedAt is time of last successful (or not found) load of the entry (zero
+	// if the entry was never loaded successfully).
+	LoadedAt time.Time
+	// NextReload is time when the entry data expire and it should be reloaded.
+	NextReload time.Time
+	// Accessed is true when the entry was accessed since its last load.
+	Accessed bool
+	// AccessCount is number of reads of the entry since its last load (see
+	// `EntryInfo.AccessCount`).
+	AccessCount uint64
+	// FromCache is true when the value was served from cache (it was not loaded
+	// by this call).
+	FromCache bool
+	// Err is error of the last load when it failed (the entry may still have
+	// value from previous load). For not found entries it is the error returned by
+	// the loader (e.g. `ErrNotFound` wrapped with a reason), but it is nil when
+	// the loader returned bare `ErrNotFound` (or nil value).
+	Err error
+}
+
+// GetWithMeta returns value of the entry (the same way as `Get` including loads)
+// together with its metadata.
+func (c *Cache[K, T]) GetWithMeta(ID K) (value *T, meta EntryMeta) {
+	value = c.output(c.get(c.ctx, ID, &meta))
+
+	return
+}
+
+// GetWithError returns value of the entry (the same way as `Get` including loads)
+// or the error which caused the entry to have no value (error is nil when value
+// is returned). Errors of permanent error entries (see `ErrorClassPermanent`) are
+// returned as returned by the loader for the whole time they are cached. Error of
+// the last load is returned also for values not served because of `MaxStaleness`.
+// Not found errors are returned as returned by the loader (e.g. wrapping
+// `ErrNotFound` with a reason of absence), for the whole time they are cached.
+// `ErrNotFound` is returned when the entry has no value without such error.
+func (c *Cache[K, T]) GetWithError(ID K) (*T, error) {
+	value, meta := c.GetWithMeta(ID)
+	if value != nil {
+		return value, nil
+	}
+	if meta.Err != nil {
+		return nil, meta.Err
+	}
+
+	return nil, ErrNotFound
+}
+
+func entryMeta[T any](entry *cachedEntry[T], fromCache bool) EntryMeta {
+	return EntryMeta{
+		LoadedAt:    millisToTime(entry.lastLoaded.Load()),
+		NextReload:  millisToTime(entry.nextReload.Load()),
+		Accessed:    entry.accessed.Load(),
+		AccessCount: entry.accessCount.Load(),
+		FromCache:   fromCache,
+		Err:         entry.loadErr(),
+	}
+}
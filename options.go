@@ -0,0 +1,100 @@
+package lazy
+
+import (
+	"context"
+
+	cadre_metrics "github.com/moderntv/cadre/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// Option configures cache created by `New`.
+type Option[K comparable, T any] func(p *Params[K, T])
+
+// New creates cache with given name and loader (both required) configured by
+// options. Options are applied in order (later ones override earlier ones). Cache
+// context defaults to `context.Background()`, other params default to zero values
+// of `Params` fields (`Timeouts` have to be set by `WithTimeouts`). Params are
+// validated the same way as by `NewCache`.
+func New[K comparable, T any](name string, loader LoadOneFunc[K, T], opts ...Option[K, T]) (*Cache[K, T], error) {
+	params := Params[K, T]{
+		Context:     context.Background(),
+		Name:        name,
+		LoadOneFunc: loader,
+	}
+
+	for _, opt := range opts {
+		opt(&params)
+	}
+
+	return NewCache(params)
+}
+
+// WithContext sets context of the cache (see `Params.Context`).
+func WithContext[K comparable, T any](ctx context.Context) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.Context = ctx
+	}
+}
+
+// WithLog sets logger of the cache (see `Params.Log`).
+func WithLog[K comparable, T any](log zerolog.Logger) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.Log = log
+	}
+}
+
+// WithMetrics enables metrics of the cache (see `Params.MetricsRegistry`).
+func WithMetrics[K comparable, T any](registry *cadre_metrics.Registry) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.MetricsRegistry = registry
+	}
+}
+
+// WithMetricsRegisterer enables metrics of the cache registered into plain
+// prometheus registerer (see `Params.MetricsRegisterer`).
+func WithMetricsRegisterer[K comparable, T any](registerer prometheus.Registerer) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.MetricsRegisterer = registerer
+	}
+}
+
+// WithTimeouts sets timeouts of the cache (see `Params.Timeouts`).
+func WithTimeouts[K comparable, T any](timeouts Timeouts) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.Timeouts = timeouts
+	}
+}
+
+// WithAutomaticReload sets type of automatic reload (see `Params.AutomaticReload`).
+func WithAutomaticReload[K comparable, T any](automaticReload AutomaticReload) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.AutomaticReload = automaticReload
+	}
+}
+
+// WithPreloadChan enables preloading of entries (see `Params.PreloadChan`).
+func WithPreloadChan[K comparable, T any](preloadChan <-chan LoadedEntry[K, T]) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.PreloadChan = preloadChan
+	}
+}
+
+// WithLoadBatch sets batch loader (see `Params.LoadBatchFunc`).
+func WithLoadBatch[K comparable, T any](loader LoadBatchFunc[K, T]) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.LoadBatchFunc = loader
+	}
+}
+
+// WithPinnedKeys pins given keys (see `Params.PinnedKeys`).
+func WithPinnedKeys[K comparable, T any](IDs ...K) Option[K, T] {
+	return func(p *Params[K, T]) {
+		p.PinnedKeys = append(p.PinnedKeys, IDs...)
+	}
+}
+
+// WithParams modifies any other params of the cache.
+func WithParams[K comparable, T any](modify func(p *Params[K, T])) Option[K, T] {
+	return Option[K, T](modify)
+}
@@ -0,0 +1,61 @@
+package lazy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func TestNew(t *testing.T) {
+	loader := func(ID int) (entry *string, err error) {
+		return test_utils.StringPointer("value"), nil
+	}
+
+	t.Run("options", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		timeouts := cacheTestTimeouts
+		timeouts.TTL = 10 * time.Second
+
+		c, err := New("test_cache1", loader,
+			WithContext[int, string](ctx),
+			WithLog[int, string](test_utils.Logger()),
+			WithTimeouts[int, string](cacheTestTimeouts),
+			// later options override earlier ones
+			WithTimeouts[int, string](timeouts),
+			WithAutomaticReload[int, string](AutomaticReloadAllEntries),
+			WithParams(func(p *Params[int, string]) {
+				p.AutomaticReload = AutomaticReloadDisabled
+				p.MaxEntries = 10
+			}),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, ctx, c.ctx)
+		assert.Equal(t, "test_cache1", c.name)
+		assert.Equal(t, timeouts, *c.timeouts.Load())
+		assert.Equal(t, AutomaticReloadDisabled, c.automaticReloadType)
+		assert.Equal(t, int64(10), c.maxEntries.Load())
+		assert.Equal(t, "value", *c.Get(1))
+	})
+
+	t.Run("required", func(t *testing.T) {
+		withTimeouts := WithTimeouts[int, string](cacheTestTimeouts)
+
+		_, err := New("", loader, withTimeouts)
+		assert.ErrorIs(t, err, ErrNameEmpty)
+
+		_, err = New[int, string]("test_cache1", nil, withTimeouts)
+		assert.ErrorIs(t, err, ErrLoaderNil)
+
+		_, err = New("test_cache1", loader, withTimeouts, WithContext[int, string](nil))
+		assert.ErrorIs(t, err, ErrContextNil)
+
+		_, err = New("test_cache1", loader)
+		assert.ErrorIs(t, err, ErrInvalidTimeouts)
+	})
+}
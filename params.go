@@ -2,9 +2,11 @@ package lazy
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
 	cadre_metrics "github.com/moderntv/cadre/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
 
@@ -14,6 +16,20 @@ const (
 	AutomaticReloadDisabled AutomaticReload = iota
 	AutomaticReloadAccessedEntries
 	AutomaticReloadAllEntries
+	// AutomaticReloadAllEntriesKeepAlive reloads all entries and each successful
+	// reload prolongs TTL even when the entry was not accessed, so entries are never
+	// removed while they can be loaded. Memory of the cache is then never reclaimed
+	// (use only for bounded data sets, e.g. reference data).
+	AutomaticReloadAllEntriesKeepAlive
+)
+
+type WriteThroughPolicy int
+
+const (
+	// WriteThroughStrict does not update cache when write-through fails
+	WriteThroughStrict WriteThroughPolicy = iota
+	// WriteThroughBestEffort updates cache even when write-through fails
+	WriteThroughBestEffort
 )
 
 type LoadedEntry[K comparable, T any] struct {
@@ -22,38 +38,419 @@ type LoadedEntry[K comparable, T any] struct {
 	Err   error
 }
 
+// PreloadStatus is outcome of preloading of an entry.
+type PreloadStatus int
+
+const (
+	// PreloadStored means the entry was stored into cache.
+	PreloadStored PreloadStatus = iota
+	// PreloadSkipped means the entry was not stored, because it was already cached.
+	PreloadSkipped
+	// PreloadFailed means the entry was preloaded with an error (other than not
+	// found), so it has no value in cache and the producer may retry it.
+	PreloadFailed
+)
+
+// PreloadResult reports outcome of preloading of an entry (see `PreloadResults`
+// in `Params`). Failed entry is reported with its error, so the producer can
+// retry it.
+type PreloadResult[K comparable, T any] struct {
+	Entry  LoadedEntry[K, T]
+	Status PreloadStatus
+	// Err is error of the entry when its preloading failed (see `PreloadFailed`).
+	Err error
+}
+
+// LoadOneFunc loads entry by its ID. Not found entry should be reported by `ErrNotFound`
+// error (or an error wrapping it). Nil entry without error is treated as not found too.
+// Entry with zero value must be returned as a non-nil pointer.
 type LoadOneFunc[K comparable, T any] func(ID K) (entry *T, err error)
+
+// LoadOneWithArgsFunc loads entry by its ID as `LoadOneFunc` with request-scoped
+// arguments passed to `Cache.GetWithArgs` (nil for other loads).
+type LoadOneWithArgsFunc[K comparable, T any] func(ID K, args any) (entry *T, err error)
+
+// LoadBatchFunc loads multiple entries by their IDs in one batch (see
+// `Params.LoadBatchFunc`).
+type LoadBatchFunc[K comparable, T any] func(IDs []K) (entries []LoadedEntry[K, T])
+
+// LoadMultipleFunc is original form of batch loader, whose loaded values are
+// wrapped in one more pointer (nil `Value` or nil value it points to is treated as
+// not found).
+//
+// Deprecated: use `LoadBatchFunc`.
 type LoadMultipleFunc[K comparable, T any] func(IDs []K) (entries []LoadedEntry[K, *T])
+type WriteThroughFunc[K comparable, T any] func(ID K, value *T) (err error)
+
+// PostLoadFunc transforms or validates result of a load before it is cached.
+// Returned value replaces the loaded one, returned error turns the result into
+// an error outcome (nil error keeps or clears the loader error).
+type PostLoadFunc[K comparable, T any] func(ID K, value *T, err error) (*T, error)
 
 type Params[K comparable, T any] struct {
-	Context         context.Context
-	Log             zerolog.Logger
+	Context context.Context
+	Log     zerolog.Logger
+	// MetricsRegistry enables metrics of the cache (optional). Names of caches
+	// sharing the registry must be unique (`ErrMetricsRegistered` is returned
+	// otherwise).
 	MetricsRegistry *cadre_metrics.Registry
+	// MetricsRegisterer enables metrics of the cache registered into plain
+	// prometheus registerer instead of `MetricsRegistry` (optional, both cannot be
+	// set). Names of caches sharing the registerer must be unique as well.
+	MetricsRegisterer prometheus.Registerer
+	// MetricsLabels are added to constant labels of all metrics of the cache
+	// (optional, "name" label is set to `Name` and cannot be overridden). Caches
+	// sharing prometheus registry must use the same label names.
+	MetricsLabels map[string]string
 	// Invalidations    *Invalidations
 	Name string
 	// LoadOneFunc server to load one entry by its ID
 	LoadOneFunc LoadOneFunc[K, T]
-	// LoadMultipleFunc server to load in batch multiple entries by their IDs
-	// (which should be more efficient than calling LoadOneFunc multiple times)
+	// LoadOneWithArgsFunc replaces `LoadOneFunc` when loads need arguments which
+	// are not part of the key (e.g. locale or auth token, see `Cache.GetWithArgs`).
+	// Only one of them can be set.
+	LoadOneWithArgsFunc LoadOneWithArgsFunc[K, T]
+	// Fallbacks are loaders tried in order when `LoadOneFunc` (or previous fallback)
+	// fails with an error which is not classified as not found (e.g. replica and
+	// static defaults behind primary storage). Success or not found result of any
+	// loader is used, error of the last one is used when all of them fail.
+	// Fallbacks are not used by batch loads (`LoadBatchFunc`).
+	Fallbacks []LoadOneFunc[K, T]
+	// LoadBatchFunc server to load in batch multiple entries by their IDs
+	// (which should be more efficient than calling LoadOneFunc multiple times).
+	// Requested entries missing in the result are treated as not found. When
+	// the result contains the same ID multiple times, only its first occurrence
+	// is used. Returned entries which were not requested are ignored unless
+	// `CacheUnrequestedEntries` is set.
+	LoadBatchFunc LoadBatchFunc[K, T]
+	// LoadMultipleFunc is used as `LoadBatchFunc` when it is not set (only one of
+	// them can be set).
+	//
+	// Deprecated: use `LoadBatchFunc`.
 	LoadMultipleFunc LoadMultipleFunc[K, T]
-	Timeouts         Timeouts
+	// PostLoad is applied on results of all loads (`LoadOneFunc` and `LoadBatchFunc`,
+	// including automatic reloads) before they are cached (optional).
+	PostLoad PostLoadFunc[K, T]
+	// Equal compares reloaded value of an entry with the cached one (optional). When
+	// set, reloads are counted as changed or unchanged (see `Stats`), which helps
+	// to tune `ReloadInterval`. Not found values are equal only to not found ones,
+	// failed reloads (and not found reloads within `NotFoundGrace`) are not counted.
+	Equal func(old, new *T) bool
+	// Clone copies values returned to callers (optional), so callers can modify
+	// them without affecting the cached value (e.g. for mutable structs). Without
+	// it, returned values are shared by all readers and must be treated as
+	// read-only. Every read of a value (`Get` and its variants, `Peek`, `All`, ...)
+	// then allocates its copy, which makes reads considerably slower, so cloning
+	// should be preferred only for values which are really modified by callers.
+	// Values passed to other hooks (e.g. `PostLoad`, indexes) are not cloned.
+	Clone func(value *T) *T
+	// CacheUnrequestedEntries enables storing entries returned by `LoadBatchFunc`
+	// which were not requested (the same way as preloaded entries).
+	CacheUnrequestedEntries bool
+	// MaxBatchSize limits number of IDs requested by one `LoadBatchFunc` call.
+	// Larger batches are split into chunks loaded one after another. If set to 0,
+	// batches are not limited.
+	MaxBatchSize int
+	// MaxBackgroundLoads limits number of routines running background (re)loads
+	// started by `TryGet` and `GetStale` (64 by default). Loads over the limit are
+	// queued until a routine is free, entries stay locked (as being loaded) in the
+	// meantime. It protects the process from bursts of reloads, e.g. when many
+	// entries expire at once.
+	MaxBackgroundLoads int
+	// MaxConcurrentLoads limits number of concurrent loader calls (`LoadOneFunc`,
+	// its fallbacks and `LoadBatchFunc`) of all loads of the cache, e.g. to
+	// stay within connection pool of the data storage. Loads over the limit wait
+	// for a free slot. If set to 0, loader calls are not limited (unless
+	// `LoadLimiter` is set).
+	MaxConcurrentLoads int
+	// LoadLimiter limits loader calls as `MaxConcurrentLoads`, but it can be
+	// shared by multiple caches (optional, see `NewLoadLimiter`). Only one of them
+	// can be set.
+	LoadLimiter *LoadLimiter
+	// HealthWindow is number of the most recent loads whose outcomes are used by
+	// `Cache.Health` (100 by default).
+	HealthWindow int
+	// HealthMaxErrorRate is ratio of failed loads (transient errors, see
+	// `ClassifyError`) in `HealthWindow` above which the cache is reported as
+	// unhealthy by `Cache.Health` (0.5 by default). It must be between 0 and 1.
+	HealthMaxErrorRate float64
+	Timeouts           Timeouts
 	// PreloadChan serves to preload entries into cache, usually right after cache
-	// initialization. Preloading finishes when the channel is closed.
-	PreloadChan     <-chan LoadedEntry[K, T]
+	// initialization. Preloading finishes when the channel is closed. Preloaded
+	// entries never replace entries already cached by reads (which are fresher).
+	PreloadChan <-chan LoadedEntry[K, T]
+	// PreloadResults receives outcome of each entry read from `PreloadChan`
+	// (optional). The cache waits until the result is received before it reads
+	// next entry, so the producer gets backpressure (but it has to consume the
+	// results, otherwise preloading stalls). Buffer of the channel should be at
+	// least as big as buffer of `PreloadChan` for the producer not to be blocked by
+	// results it has not read yet. The channel is closed when preloading finishes.
+	PreloadResults chan<- PreloadResult[K, T]
+	// PreloadWait specifies how long `Get` of not cached entry waits for preloading
+	// to finish before the entry is loaded, so entries about to be preloaded are
+	// not loaded twice. If set to 0, reads do not wait.
+	PreloadWait     time.Duration
 	AutomaticReload AutomaticReload
+	// AutomaticReloadBatchWindow enables batching of automatic reloads by
+	// `LoadBatchFunc` (ignored when it is not set). Entries whose automatic
+	// reloads are due within the window after the first one are reloaded together.
+	// If set to 0, entries are reloaded one by one by `LoadOneFunc`.
+	AutomaticReloadBatchWindow time.Duration
+	// SingleScheduler replaces TTL and reload watchers (each running its own
+	// goroutines) by one goroutine scheduling both expirations and automatic
+	// reloads, which saves goroutines when there are many caches in the process.
+	// Expiration of an entry precedes its reload scheduled for the same instant.
+	// Reloads run in a separate goroutine only while there are any to be done.
+	SingleScheduler bool
+	// HardMemoryCeiling specifies memory size of cached values (in bytes) above which
+	// new entries are not inserted into cache. Reads of not cached entries then
+	// return nil without loading until memory size drops. Already cached entries
+	// are served and reloaded normally. Memory size is measured periodically
+	// (see `Timeouts.MemsizeUpdate`, which must be set).
+	// If set to 0, new entries are always inserted. It can be changed by `Cache.Resize`.
+	HardMemoryCeiling uint64
+	// MemsizeWorkers is maximal number of routines measuring memory size of cached
+	// values (see `Timeouts.MemsizeUpdate`). Values are split into shards measured
+	// concurrently, which shortens each measurement of large caches (CPU time
+	// spent is the same). All values are still measured at once, so the measured
+	// size is exact at the time of measurement. Caches with less than 1000 values
+	// per routine use fewer routines and there is no point in using more routines
+	// than GOMAXPROCS. If set to 0 (or 1), values are measured by one routine.
+	MemsizeWorkers int
+	// MaxEntries limits number of cached entries. When a new entry is inserted over
+	// the limit, other entries are evicted according to `EvictionPolicy`.
+	// If set to 0, number of entries is not limited. It can be changed by `Cache.Resize`.
+	MaxEntries int
+	// PinnedKeys are pinned when the cache is created (see `Cache.Pin`).
+	PinnedKeys []K
+	// EvictionPolicy specifies which entries are evicted (`EvictionSampledLRU` by default).
+	EvictionPolicy EvictionPolicy
+	// EvictionSamples is number of entries sampled by `EvictionSampledLRU` policy
+	// (5 by default).
+	EvictionSamples int
+	// CapacityDryRun disables eviction of entries, entries which would be evicted
+	// are only counted (see `Stats.WouldEvictions`), e.g. to size `MaxEntries`
+	// on live traffic before eviction is enabled. Every insertion over `MaxEntries`
+	// counts one eviction, `Cache.Resize` counts entries over new limits. Number
+	// of entries (and memory usage) is then not bounded by capacity limits at all,
+	// only by TTL. Insertions rejected by `HardMemoryCeiling` are not affected.
+	CapacityDryRun bool
+	// ClassifyError decides how errors returned by loaders are cached (optional,
+	// `DefaultClassifyError` is used when not set).
+	ClassifyError ClassifyErrorFunc
+	// PermanentErrorTTL returns TTL of entry which load failed with the permanent
+	// error (optional). It allows to cache different permanent errors for different
+	// durations. When it is not set or it returns false, `Timeouts.PermanentErrorTTL`
+	// is used.
+	PermanentErrorTTL func(err error) (ttl time.Duration, ok bool)
+	// ShouldNegativeCache decides whether not found entry is cached for `NotFoundTTL`
+	// (optional, all not found entries are cached when not set). When it returns
+	// false, the entry is removed from cache instead, so every read of the entry
+	// loads it again. Use it only for small set of keys, each read of such absent
+	// key hits the data storage.
+	ShouldNegativeCache func(ID K) bool
+	// RetainValueOnNotFound enables caching of value returned by loader together
+	// with not found error (e.g. tombstone of soft-deleted record) instead of
+	// caching not found entry without value. The value is cached for `NotFoundTTL`.
+	RetainValueOnNotFound bool
+	// SkipCancelledLoads disables caching of loads which failed with an error
+	// caused by cancelled context (wrapping `context.Canceled` or
+	// `context.DeadlineExceeded`), e.g. when the loader uses context of the request
+	// and the client went away. Such failure says nothing about the data storage,
+	// so not cached entry stays not cached (instead of being cached for `ErrorTTL`)
+	// and expired entry stays expired (its next read loads it again). Loaders do
+	// not get context from the cache, so the cache cannot tell who cancelled the
+	// context: loader which enforces its own timeout should return a different
+	// error when its timeout passes, if such failure should be cached.
+	SkipCancelledLoads bool
+	// InsertAfterLoad disables inserting of empty entry into cache before the first
+	// load of the entry by `Get` (and its variants) or `TryGet`. The entry is
+	// inserted only after its load finishes with a result which should be cached,
+	// so scans (e.g. `Len`) never see entries being loaded and entries which should
+	// not be cached (e.g. errors with zero `ErrorTTL`) never appear in cache. The
+	// tradeoff is weaker deduplication: concurrent reads of the same not cached
+	// entry load it independently (the first loaded result is kept, see
+	// `CoalesceColdLoads`). Batch loads
+	// (`GetMultiple`) still insert empty entries before loading.
+	InsertAfterLoad bool
+	// CoalesceColdLoads makes reads of not cached entry which is being loaded by
+	// other read wait up to given duration for the result of that load instead of
+	// loading the entry independently (e.g. 5ms, so concurrent reads of hot keys
+	// right after start do not hit the data storage many times). It trades
+	// latency of such reads (when the other load takes longer) for fewer loads.
+	// It applies only with `InsertAfterLoad`, other reads always wait for the
+	// load of the entry inserted into cache by the first read. If set to 0, reads
+	// do not wait.
+	CoalesceColdLoads time.Duration
+	// LazyFirstLoad makes reads by `Get` (and its variants) never wait for the first
+	// load of the entry: read of not cached entry starts its load in the background
+	// and returns nil right away, so the first read of every entry is a miss (and
+	// so are other reads until the load finishes). Only one load of the entry runs
+	// at a time. Reloads of cached entries are not affected. Batch loads
+	// (`GetMultiple`) are not affected either. It cannot be used with
+	// `InsertAfterLoad`.
+	LazyFirstLoad bool
+	// NormalizeKey returns canonical form of the key (e.g. lowercased string), so
+	// different forms of the same key share one entry (optional). It is applied on
+	// keys passed to all methods of the cache and on keys of preloaded entries, so
+	// only normalized keys are cached (and distributed to other instances). Loaders
+	// get normalized keys. It must be idempotent.
+	NormalizeKey func(ID K) K
+	// OnHealthChange is called when loads of an entry start failing (first load
+	// failed with transient error after successful ones) and when they recover
+	// (first successful or not found load, or `Set`, after failed ones). It is not called
+	// on every load, so it is suitable for alerting. New entries are considered
+	// healthy. It is called synchronously by the routine which loaded the entry
+	// (outside of entry lock), so it should be fast.
+	OnHealthChange func(ID K, healthy bool)
+	// OnTTLAssigned is called every time loaded (or set) data are stored into an
+	// entry (loads, reloads, preloads, `Set`, ...) with TTL and reload interval
+	// assigned to the entry (after randomization by `Timeouts.Randomizer`), e.g. to
+	// check spread of the intervals. TTL is negative when it was not changed (e.g.
+	// failed reload keeps previous TTL). It is called synchronously under the entry
+	// lock, so it must be fast and it must not access the cache.
+	OnTTLAssigned func(ID K, ttl, reloadInterval time.Duration)
+	// OnLoadStart is called before every loader call (`LoadOneFunc` with its
+	// fallbacks and `LoadBatchFunc`, once for every requested ID) with context
+	// of the read which triggered the load (see `Cache.GetWithContext`) or context
+	// of the cache (automatic reloads, batch loads and other reads). Returned
+	// context (e.g. with started tracing span) is passed to `OnLoadEnd`, which is
+	// called when the loader returns with its error (before `PostLoad`). Preloaded
+	// entries are not loaded by the cache, so hooks are not called for them.
+	// Both hooks are optional and called synchronously by the loading routine.
+	OnLoadStart func(ctx context.Context, ID K) context.Context
+	// OnLoadEnd see `OnLoadStart`.
+	OnLoadEnd func(ctx context.Context, ID K, err error)
+	// SlowLoadThreshold enables warning log of loader calls (`LoadOneFunc` with
+	// its fallbacks or `LoadBatchFunc`) taking longer, e.g. to correlate them
+	// with incidents of the data storage. Slow batch loads are logged with number
+	// of requested keys instead of the keys. If set to 0, slow loads are not logged.
+	SlowLoadThreshold time.Duration
+	// MaxStaleness limits age of values served by `Get` (and its variants like
+	// `GetWithError`) when reloads of the entry fail. Once the last successful
+	// load of the entry is older, the value is not served anymore and nil is
+	// returned instead (`GetWithError` returns the error of the last load), until
+	// the entry is reloaded successfully. The value stays cached meanwhile.
+	// If set to 0, stale values are served until the entry expires.
+	MaxStaleness time.Duration
+	// ValidKey reports whether the key can exist at all (optional). Reads of invalid
+	// keys return nil without calling loaders and the keys are not cached (not even
+	// as not found), so garbage keys do not reach the data storage nor fill cache.
+	ValidKey func(ID K) bool
+	// ReloadAt returns time when successfully loaded value should be reloaded
+	// (optional). It replaces `ReloadInterval` (without randomization) unless it
+	// returns zero time. Time in the past reloads the entry on next read. Reload
+	// cannot be postponed beyond TTL, the entry expires then.
+	ReloadAt func(ID K, value *T) time.Time
+	// ExpireAt returns absolute expiration of successfully loaded value (optional),
+	// e.g. expiration of a token. When it returns true, the entry expires at the
+	// time instead of `TTL` (without randomization) and it is reloaded shortly
+	// before (after 90 % of remaining lifetime, unless `ReloadAt` returns earlier
+	// time) instead of `ReloadInterval`. Value which is already expired is not
+	// cached. Normal timeouts are used when it returns false.
+	ExpireAt func(ID K, value *T) (time.Time, bool)
+	// Compress enables caching of values in compressed form, which trades CPU for
+	// memory (values are decompressed on every read). `*T` has to implement
+	// `Compressible`. Memory size of cached values is measured compressed.
+	Compress bool
+	// TopKeys enables tracking of most frequently read keys (see `Cache.TopKeys`)
+	// with given number of counters (memory is bounded by it). Keys read more often
+	// than once per TopKeys reads are always tracked. Tracking adds lock contention
+	// to reads. If set to 0, keys are not tracked.
+	TopKeys int
+	// GraceEntries keeps values of given number of entries most recently expired by
+	// TTL. `Get` (and its variants) of such a key returns the kept value (as
+	// expired) and reloads the entry in the background instead of loading it
+	// synchronously, which helps keys read sporadically (less often than TTL).
+	// Restored entry expires after `ErrorTTL` unless it is reloaded successfully.
+	// Kept values stay in memory until they are replaced by newer ones
+	// (at most GraceEntries values), they are not included in memory size of the
+	// cache. Entries removed otherwise (e.g. by `Remove` or eviction) are not kept.
+	// If set to 0, expired entries are dropped.
+	GraceEntries int
+	// MapCompactionRatio enables automatic rebuild of the map of cached entries
+	// (see `Cache.Compact`) when number of entries removed since the last rebuild
+	// exceeds number of cached entries multiplied by the ratio (and it is at least
+	// 1000), e.g. 2 for rebuild when the map shrank to one third. Go maps do not
+	// release memory of removed entries, so caches with many short-lived keys keep
+	// a map sized for their peak otherwise. If set to 0, the map is rebuilt only by
+	// `Cache.Compact`.
+	MapCompactionRatio float64
+	// DecayAccessCounts makes loads halve access counts of entries (number of reads
+	// by `Get` and its variants, see `EntryInfo.AccessCount`) instead of clearing
+	// them, so counts reflect frequency of reads over longer time with recent
+	// reads weighted more (each reload interval halves weight of older reads).
+	DecayAccessCounts bool
+	// Clock provides current time (optional, real time is used when not set).
+	Clock Clock
+	// EventsBuffer enables channel of lifecycle events (see `Events`) with given
+	// buffer size. Events which do not fit into the buffer are dropped.
+	// If set to 0, events are disabled.
+	EventsBuffer int
+	// CircuitBreaker stops loading entries when loaders keep failing (optional,
+	// see `CircuitBreaker`).
+	CircuitBreaker *CircuitBreaker
+	// MemoryPressure evicts entries when heap of the process grows over a threshold
+	// (optional, see `MemoryPressure`).
+	MemoryPressure *MemoryPressure
+	// Distribution enables sharing of loaded entries between cache instances over
+	// NATS (optional, see `Distribution`).
+	Distribution *Distribution[K, T]
+	// WriteThrough serves to persist entries set by `Set` function into data storage
+	// (optional).
+	WriteThrough WriteThroughFunc[K, T]
+	// WriteThroughPolicy specifies whether cache is updated when `WriteThrough` fails.
+	WriteThroughPolicy WriteThroughPolicy
+}
+
+// loadBatch returns batch loader of the params (`LoadBatchFunc` is adapted to
+// `LoadBatchFunc`)
+func (p *Params[K, T]) loadBatch() LoadBatchFunc[K, T] {
+	if p.LoadBatchFunc != nil || p.LoadMultipleFunc == nil {
+		return p.LoadBatchFunc
+	}
+
+	loadMultiple := p.LoadMultipleFunc
+	return func(IDs []K) []LoadedEntry[K, T] {
+		loaded := loadMultiple(IDs)
+		entries := make([]LoadedEntry[K, T], 0, len(loaded))
+		for _, e := range loaded {
+			entry := LoadedEntry[K, T]{ID: e.ID, Err: e.Err}
+			if e.Value != nil {
+				entry.Value = *e.Value
+			}
+			entries = append(entries, entry)
+		}
+
+		return entries
+	}
 }
 
 func (p *Params[K, T]) check() error {
 	if p.Context == nil {
-		return errors.New("context must be set")
+		return ErrContextNil
 	}
 
 	if p.Name == "" {
-		return errors.New("name must be set")
+		return ErrNameEmpty
 	}
 
-	if p.LoadOneFunc == nil {
-		return errors.New("LoadOneFunc must be provided")
+	if p.LoadOneFunc == nil && p.LoadOneWithArgsFunc == nil {
+		return ErrLoaderNil
+	}
+
+	if p.LoadOneFunc != nil && p.LoadOneWithArgsFunc != nil {
+		return fmt.Errorf("%w: only one of LoadOneFunc and LoadOneWithArgsFunc can be set", ErrInvalidParams)
+	}
+
+	if p.LoadBatchFunc != nil && p.LoadMultipleFunc != nil {
+		return fmt.Errorf("%w: only one of LoadBatchFunc and LoadMultipleFunc can be set", ErrInvalidParams)
+	}
+
+	for _, fallback := range p.Fallbacks {
+		if fallback == nil {
+			return fmt.Errorf("%w: Fallbacks cannot contain nil loader", ErrInvalidParams)
+		}
 	}
 
 	err := p.Timeouts.check()
@@ -61,6 +458,119 @@ func (p *Params[K, T]) check() error {
 		return err
 	}
 
+	if p.CircuitBreaker != nil {
+		err = p.CircuitBreaker.check()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.MemoryPressure != nil {
+		err = p.MemoryPressure.check()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Distribution != nil {
+		err = p.Distribution.check()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.AutomaticReloadBatchWindow < 0 {
+		return fmt.Errorf("%w: AutomaticReloadBatchWindow cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MetricsRegistry != nil && p.MetricsRegisterer != nil {
+		return fmt.Errorf("%w: MetricsRegistry and MetricsRegisterer cannot be set together", ErrInvalidParams)
+	}
+
+	if _, exists := p.MetricsLabels["name"]; exists {
+		return fmt.Errorf("%w: MetricsLabels cannot contain name label", ErrInvalidParams)
+	}
+
+	if p.PreloadWait < 0 {
+		return fmt.Errorf("%w: PreloadWait cannot be negative", ErrInvalidParams)
+	}
+
+	if _, compressible := any((*T)(nil)).(Compressible[T]); p.Compress && !compressible {
+		return fmt.Errorf("%w: values must implement Compressible to be compressed", ErrInvalidParams)
+	}
+
+	if p.TopKeys < 0 {
+		return fmt.Errorf("%w: TopKeys cannot be negative", ErrInvalidParams)
+	}
+
+	if p.GraceEntries < 0 {
+		return fmt.Errorf("%w: GraceEntries cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MapCompactionRatio < 0 {
+		return fmt.Errorf("%w: MapCompactionRatio cannot be negative", ErrInvalidParams)
+	}
+
+	if p.CoalesceColdLoads < 0 {
+		return fmt.Errorf("%w: CoalesceColdLoads cannot be negative", ErrInvalidParams)
+	}
+
+	if p.LazyFirstLoad && p.InsertAfterLoad {
+		return fmt.Errorf("%w: LazyFirstLoad cannot be used with InsertAfterLoad", ErrInvalidParams)
+	}
+
+	if p.MaxStaleness < 0 {
+		return fmt.Errorf("%w: MaxStaleness cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MaxBatchSize < 0 {
+		return fmt.Errorf("%w: MaxBatchSize cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MaxBackgroundLoads < 0 {
+		return fmt.Errorf("%w: MaxBackgroundLoads cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MaxConcurrentLoads < 0 {
+		return fmt.Errorf("%w: MaxConcurrentLoads cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MaxConcurrentLoads > 0 && p.LoadLimiter != nil {
+		return fmt.Errorf("%w: only one of MaxConcurrentLoads and LoadLimiter can be set", ErrInvalidParams)
+	}
+
+	if p.HealthWindow < 0 {
+		return fmt.Errorf("%w: HealthWindow cannot be negative", ErrInvalidParams)
+	}
+
+	if p.HealthMaxErrorRate < 0 || p.HealthMaxErrorRate > 1 {
+		return fmt.Errorf("%w: HealthMaxErrorRate must be between 0 and 1", ErrInvalidParams)
+	}
+
+	if p.EventsBuffer < 0 {
+		return fmt.Errorf("%w: EventsBuffer cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MaxEntries < 0 {
+		return fmt.Errorf("%w: MaxEntries cannot be negative", ErrInvalidParams)
+	}
+
+	if p.EvictionSamples < 0 {
+		return fmt.Errorf("%w: EvictionSamples cannot be negative", ErrInvalidParams)
+	}
+
+	if p.MemsizeWorkers < 0 {
+		return fmt.Errorf("%w: MemsizeWorkers cannot be negative", ErrInvalidParams)
+	}
+
+	if p.SlowLoadThreshold < 0 {
+		return fmt.Errorf("%w: SlowLoadThreshold cannot be negative", ErrInvalidParams)
+	}
+
+	if p.HardMemoryCeiling > 0 && p.Timeouts.MemsizeUpdate == 0 {
+		return fmt.Errorf("%w: HardMemoryCeiling requires MemsizeUpdate to be set", ErrInvalidParams)
+	}
+
 	// if p.Invalidations != nil {
 	// 	return p.Invalidations.check()
 	// }
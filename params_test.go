@@ -0,0 +1,166 @@
+package lazy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moderntv/lazy-cache/internal/test_utils"
+)
+
+func TestParamsCheck(t *testing.T) {
+	validParams := func() Params[int, string] {
+		return Params[int, string]{
+			Context: context.Background(),
+			Log:     test_utils.Logger(),
+			Name:    "test_cache1",
+			LoadOneFunc: func(ID int) (entry *string, err error) {
+				return nil, ErrNotFound
+			},
+			Timeouts: cacheTestTimeouts,
+		}
+	}
+
+	tests := map[string]struct {
+		modify   func(p *Params[int, string])
+		expected error
+	}{
+		"valid": {
+			modify:   func(p *Params[int, string]) {},
+			expected: nil,
+		},
+		"nil_context": {
+			modify:   func(p *Params[int, string]) { p.Context = nil },
+			expected: ErrContextNil,
+		},
+		"empty_name": {
+			modify:   func(p *Params[int, string]) { p.Name = "" },
+			expected: ErrNameEmpty,
+		},
+		"nil_loader": {
+			modify:   func(p *Params[int, string]) { p.LoadOneFunc = nil },
+			expected: ErrLoaderNil,
+		},
+		"both_batch_loaders": {
+			modify: func(p *Params[int, string]) {
+				p.LoadBatchFunc = func(IDs []int) []LoadedEntry[int, string] { return nil }
+				p.LoadMultipleFunc = func(IDs []int) []LoadedEntry[int, *string] { return nil }
+			},
+			expected: ErrInvalidParams,
+		},
+		"nil_fallback": {
+			modify:   func(p *Params[int, string]) { p.Fallbacks = []LoadOneFunc[int, string]{nil} },
+			expected: ErrInvalidParams,
+		},
+		"zero_ttl": {
+			modify:   func(p *Params[int, string]) { p.Timeouts.TTL = 0 },
+			expected: ErrInvalidTimeouts,
+		},
+		"zero_reload_interval": {
+			modify:   func(p *Params[int, string]) { p.Timeouts.ReloadInterval = 0 },
+			expected: ErrInvalidTimeouts,
+		},
+		"reload_interval_over_ttl": {
+			modify:   func(p *Params[int, string]) { p.Timeouts.ReloadInterval = p.Timeouts.TTL + time.Second },
+			expected: ErrInvalidTimeouts,
+		},
+		"negative_max_batch_size": {
+			modify:   func(p *Params[int, string]) { p.MaxBatchSize = -1 },
+			expected: ErrInvalidParams,
+		},
+		"name_metrics_label": {
+			modify:   func(p *Params[int, string]) { p.MetricsLabels = map[string]string{"name": "other"} },
+			expected: ErrInvalidParams,
+		},
+		"negative_coalesce_cold_loads": {
+			modify:   func(p *Params[int, string]) { p.CoalesceColdLoads = -time.Millisecond },
+			expected: ErrInvalidParams,
+		},
+		"negative_slow_load_threshold": {
+			modify:   func(p *Params[int, string]) { p.SlowLoadThreshold = -time.Millisecond },
+			expected: ErrInvalidParams,
+		},
+		"negative_health_window": {
+			modify:   func(p *Params[int, string]) { p.HealthWindow = -1 },
+			expected: ErrInvalidParams,
+		},
+		"health_max_error_rate_over_one": {
+			modify:   func(p *Params[int, string]) { p.HealthMaxErrorRate = 1.5 },
+			expected: ErrInvalidParams,
+		},
+		"lazy_first_load_insert_after_load": {
+			modify: func(p *Params[int, string]) {
+				p.LazyFirstLoad = true
+				p.InsertAfterLoad = true
+			},
+			expected: ErrInvalidParams,
+		},
+		"negative_max_concurrent_loads": {
+			modify:   func(p *Params[int, string]) { p.MaxConcurrentLoads = -1 },
+			expected: ErrInvalidParams,
+		},
+		"max_concurrent_loads_with_load_limiter": {
+			modify: func(p *Params[int, string]) {
+				p.MaxConcurrentLoads = 1
+				p.LoadLimiter = NewLoadLimiter(1)
+			},
+			expected: ErrInvalidParams,
+		},
+		"load_one_with_args_and_load_one": {
+			modify: func(p *Params[int, string]) {
+				p.LoadOneWithArgsFunc = func(ID int, args any) (*string, error) { return nil, ErrNotFound }
+			},
+			expected: ErrInvalidParams,
+		},
+		"negative_map_compaction_ratio": {
+			modify:   func(p *Params[int, string]) { p.MapCompactionRatio = -1 },
+			expected: ErrInvalidParams,
+		},
+		"memory_pressure_without_threshold": {
+			modify: func(p *Params[int, string]) {
+				p.MemoryPressure = &MemoryPressure{EvictFraction: 0.1, CheckInterval: time.Second}
+			},
+			expected: ErrInvalidParams,
+		},
+		"memory_pressure_evict_fraction_over_one": {
+			modify: func(p *Params[int, string]) {
+				p.MemoryPressure = &MemoryPressure{HeapThreshold: 1, EvictFraction: 2, CheckInterval: time.Second}
+			},
+			expected: ErrInvalidParams,
+		},
+		"negative_memsize_workers": {
+			modify:   func(p *Params[int, string]) { p.MemsizeWorkers = -1 },
+			expected: ErrInvalidParams,
+		},
+		"negative_grace_entries": {
+			modify:   func(p *Params[int, string]) { p.GraceEntries = -1 },
+			expected: ErrInvalidParams,
+		},
+		"negative_max_background_loads": {
+			modify:   func(p *Params[int, string]) { p.MaxBackgroundLoads = -1 },
+			expected: ErrInvalidParams,
+		},
+		"negative_max_entries": {
+			modify:   func(p *Params[int, string]) { p.MaxEntries = -1 },
+			expected: ErrInvalidParams,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := validParams()
+			tt.modify(&p)
+
+			err := p.check()
+			if tt.expected == nil {
+				assert.Nil(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, tt.expected)
+			assert.ErrorIs(t, err, ErrInvalidParams)
+		})
+	}
+}
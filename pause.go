@@ -0,0 +1,57 @@
+package lazy
+
+import "sync"
+
+// pausedReloads keeps IDs of entries whose automatic reloads were skipped while
+// the cache was paused
+type pausedReloads[K comparable] struct {
+	mu  sync.Mutex
+	IDs map[K]struct{}
+}
+
+func (p *pausedReloads[K]) add(ID K) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.IDs == nil {
+		p.IDs = make(map[K]struct{})
+	}
+	p.IDs[ID] = struct{}{}
+}
+
+// take returns all IDs and clears them
+func (p *pausedReloads[K]) take() map[K]struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	IDs := p.IDs
+	p.IDs = nil
+
+	return IDs
+}
+
+// Pause stops loading of entries (e.g. during maintenance of the data storage).
+// While paused, reads serve cached values (expired as well) without loading them
+// and not cached entries are returned as nil. Automatic reloads are postponed
+// until the cache is resumed. Writes (e.g. `Set`) and preloading are not affected.
+func (c *Cache[K, T]) Pause() {
+	c.paused.Store(true)
+}
+
+// Resume restores loading of entries stopped by `Pause`. Automatic reloads
+// postponed while paused are scheduled immediately, expired entries are reloaded
+// on their next read.
+func (c *Cache[K, T]) Resume() {
+	if !c.paused.Swap(false) {
+		return
+	}
+
+	for ID := range c.pausedReloads.take() {
+		c.reloadWatcher.Push(ID, 0)
+	}
+}
+
+// Paused returns true when the cache is paused (see `Pause`).
+func (c *Cache[K, T]) Paused() bool {
+	return c.paused.Load()
+}
@@ -0,0 +1,94 @@
+package lazy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pinnedKeys keeps keys of entries exempt from TTL expiration and eviction
+type pinnedKeys[K comparable] struct {
+	count atomic.Int64 // number of pinned keys (checked before locking)
+	mu    sync.RWMutex
+	IDs   map[K]struct{}
+}
+
+// add pins the key and returns true if it was not pinned before
+func (p *pinnedKeys[K]) add(ID K) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, pinned := p.IDs[ID]; pinned {
+		return false
+	}
+
+	if p.IDs == nil {
+		p.IDs = make(map[K]struct{})
+	}
+	p.IDs[ID] = struct{}{}
+	p.count.Add(1)
+
+	return true
+}
+
+// remove unpins the key and returns true if it was pinned
+func (p *pinnedKeys[K]) remove(ID K) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, pinned := p.IDs[ID]; !pinned {
+		return false
+	}
+
+	delete(p.IDs, ID)
+	p.count.Add(-1)
+
+	return true
+}
+
+func (p *pinnedKeys[K]) has(ID K) bool {
+	// avoid locking in caches without pinned keys
+	if p.count.Load() == 0 {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, pinned := p.IDs[ID]
+	return pinned
+}
+
+// Pin marks the entry as pinned (e.g. global configuration), the key does not have
+// to be cached yet. Pinned entries are never expired by TTL, evicted (by
+// `MaxEntries` or `HardMemoryCeiling`) or compacted, but they are reloaded as other
+// entries (automatic reload reloads them even when they are not accessed).
+// Pinned entries can be still removed explicitly (e.g. by `Remove`). When all
+// entries are pinned, cache can exceed its capacity limits.
+func (c *Cache[K, T]) Pin(ID K) {
+	c.pinned.add(c.normalizeKey(ID))
+}
+
+// Unpin removes pin of the entry set by `Pin`. Entry whose TTL passed while it was
+// pinned expires immediately.
+func (c *Cache[K, T]) Unpin(ID K) {
+	ID = c.normalizeKey(ID)
+	if !c.pinned.remove(ID) {
+		return
+	}
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		return
+	}
+	defer c.releaseEntry(entry)
+
+	// TTL watcher skipped the entry while it was pinned
+	ttl := time.Duration(entry.expiresAt.Load()-c.nowMillis()) * time.Millisecond
+	c.ttlWatcher.Push(ID, max(ttl, 0))
+}
+
+// Pinned returns true if the entry is pinned (see `Pin`).
+func (c *Cache[K, T]) Pinned(ID K) bool {
+	return c.pinned.has(c.normalizeKey(ID))
+}
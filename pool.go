@@ -0,0 +1,61 @@
+package lazy
+
+// Entries are reused (through `sync.Pool`) to lower allocations of workloads with
+// many unique keys. An entry can be reused only when nobody uses it anymore, so
+// entries are reference counted: cache map holds one reference and every routine
+// using the entry outside of the cache lock holds another one. References can be
+// acquired only under the cache lock while the entry is stored in the map (so
+// a released entry can never be acquired again).
+
+// newEntry returns empty entry with one reference held by the caller
+func (c *Cache[K, T]) newEntry() *cachedEntry[T] {
+	entry, _ := c.entryPool.Get().(*cachedEntry[T])
+	if entry == nil {
+		entry = &cachedEntry[T]{}
+	}
+	entry.refs.Store(1)
+
+	return entry
+}
+
+// acquireEntry returns cached entry with reference held by the caller. The entry
+// has to be released by `releaseEntry`.
+func (c *Cache[K, T]) acquireEntry(ID K) (entry *cachedEntry[T], exists bool) {
+	c.mu.RLock()
+	entry, exists = c.data[ID]
+	if exists {
+		entry.refs.Add(1)
+	}
+	c.mu.RUnlock()
+
+	return
+}
+
+// releaseEntry releases reference of the entry and reuses the entry when it was
+// the last one
+func (c *Cache[K, T]) releaseEntry(entry *cachedEntry[T]) {
+	if entry.refs.Add(-1) > 0 {
+		return
+	}
+
+	entry.reset()
+	c.entryPool.Put(entry)
+}
+
+// storeEntry stores entry into cache map (map acquires its own reference). Cache
+// has to be locked.
+func (c *Cache[K, T]) storeEntry(ID K, entry *cachedEntry[T]) {
+	entry.refs.Add(1)
+	c.data[ID] = entry
+	c.dropGraceEntry(ID)
+}
+
+// deleteEntry deletes entry from cache map and releases reference of the map.
+// Cache has to be locked.
+func (c *Cache[K, T]) deleteEntry(ID K, entry *cachedEntry[T]) {
+	delete(c.data, ID)
+	entry.removed.Store(true)
+	c.unindex(ID)
+	c.releaseEntry(entry)
+	c.countMapDeletion()
+}
@@ -0,0 +1,104 @@
+package lazy
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// MemoryPressure configures eviction of entries when heap of the process grows
+// over a threshold, so the cache shrinks in memory constrained environments
+// (e.g. pods with memory limit) instead of keeping a fixed size. Heap size is
+// polled every `CheckInterval`, each check which finds the heap over
+// `HeapThreshold` evicts `EvictFraction` of cached entries according to
+// `EvictionPolicy` (pinned entries are not evicted and at least one entry is
+// kept). Memory is released by the next garbage collection, so following checks
+// can evict again before it runs.
+type MemoryPressure struct {
+	// HeapThreshold is heap size in bytes above which entries are evicted.
+	HeapThreshold uint64
+	// EvictFraction is fraction of cached entries evicted by one check (e.g. 0.1),
+	// it must be between 0 and 1.
+	EvictFraction float64
+	// CheckInterval is interval of heap size checks.
+	CheckInterval time.Duration
+	// HeapSize returns current heap size (optional). `runtime.ReadMemStats` is
+	// used by default, which briefly stops the world (usually tens of
+	// microseconds), so the interval should be in seconds rather than
+	// milliseconds. Processes with more caches can share cheaper source.
+	HeapSize func() uint64
+}
+
+func (mp *MemoryPressure) check() error {
+	if mp.HeapThreshold == 0 {
+		return fmt.Errorf("%w: memory pressure HeapThreshold must be set", ErrInvalidParams)
+	}
+
+	if mp.EvictFraction <= 0 || mp.EvictFraction > 1 {
+		return fmt.Errorf("%w: memory pressure EvictFraction must be between 0 and 1", ErrInvalidParams)
+	}
+
+	if mp.CheckInterval <= 0 {
+		return fmt.Errorf("%w: memory pressure CheckInterval must be positive", ErrInvalidParams)
+	}
+
+	return nil
+}
+
+// heapAlloc returns size of allocated heap objects
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return stats.HeapAlloc
+}
+
+func (c *Cache[K, T]) startMemoryPressureWatcher(mp MemoryPressure) {
+	heapSize := mp.HeapSize
+	if heapSize == nil {
+		heapSize = heapAlloc
+	}
+
+	for {
+		timer := time.NewTimer(mp.CheckInterval)
+
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+
+		case <-timer.C:
+			heap := heapSize()
+			if heap > mp.HeapThreshold {
+				c.evictUnderPressure(heap, mp.EvictFraction)
+			}
+		}
+	}
+}
+
+// evictUnderPressure evicts given fraction of cached entries (at least one)
+func (c *Cache[K, T]) evictUnderPressure(heap uint64, fraction float64) {
+	c.mu.RLock()
+	entries := len(c.data)
+	c.mu.RUnlock()
+
+	if entries <= 1 {
+		return
+	}
+
+	count := max(int(float64(entries)*fraction), 1)
+	maxEntries := max(entries-count, 1)
+
+	c.log.Info().
+		Uint64("heap", heap).
+		Int("entries", entries).
+		Int("maxEntries", maxEntries).
+		Msg("evicting entries under memory pressure")
+
+	if c.capacityDryRun {
+		c.countWouldEvict(c.wouldEvict(maxEntries, 0))
+		return
+	}
+
+	c.evict(maxEntries, 0)
+}
@@ -0,0 +1,115 @@
+package lazy
+
+import (
+	"context"
+	"iter"
+)
+
+// ReadOnlyCache is a view of the cache which exposes only methods not modifying
+// cached data explicitly, so it can be shared with code which should only read.
+// Reads can still (re)load entries as they do on the underlying cache.
+// It is not a copy: all calls are forwarded to the underlying cache and lifecycle
+// of the view (e.g. cancelling of cache context) is governed by the underlying cache.
+type ReadOnlyCache[K comparable, T any] struct {
+	c *Cache[K, T]
+}
+
+// ReadOnly returns read-only view of the cache.
+func (c *Cache[K, T]) ReadOnly() ReadOnlyCache[K, T] {
+	return ReadOnlyCache[K, T]{c: c}
+}
+
+// Get see `Cache.Get`.
+func (r ReadOnlyCache[K, T]) Get(ID K) *T {
+	return r.c.Get(ID)
+}
+
+// GetWithMeta see `Cache.GetWithMeta`.
+func (r ReadOnlyCache[K, T]) GetWithMeta(ID K) (*T, EntryMeta) {
+	return r.c.GetWithMeta(ID)
+}
+
+// GetWithContext see `Cache.GetWithContext`.
+func (r ReadOnlyCache[K, T]) GetWithContext(ctx context.Context, ID K) *T {
+	return r.c.GetWithContext(ctx, ID)
+}
+
+// GetWithArgs see `Cache.GetWithArgs`.
+func (r ReadOnlyCache[K, T]) GetWithArgs(ID K, args any) *T {
+	return r.c.GetWithArgs(ID, args)
+}
+
+// GetWithError see `Cache.GetWithError`.
+func (r ReadOnlyCache[K, T]) GetWithError(ID K) (*T, error) {
+	return r.c.GetWithError(ID)
+}
+
+// GetMultiple see `Cache.GetMultiple`.
+func (r ReadOnlyCache[K, T]) GetMultiple(IDs []K) map[K]*T {
+	return r.c.GetMultiple(IDs)
+}
+
+// GetStale see `Cache.GetStale`.
+func (r ReadOnlyCache[K, T]) GetStale(ID K) (*T, bool) {
+	return r.c.GetStale(ID)
+}
+
+// WaitForKey see `Cache.WaitForKey`.
+func (r ReadOnlyCache[K, T]) WaitForKey(ctx context.Context, ID K) (*T, error) {
+	return r.c.WaitForKey(ctx, ID)
+}
+
+// TryGet see `Cache.TryGet`.
+func (r ReadOnlyCache[K, T]) TryGet(ID K) (*T, Status) {
+	return r.c.TryGet(ID)
+}
+
+// Peek see `Cache.Peek`.
+func (r ReadOnlyCache[K, T]) Peek(ID K) (*T, bool) {
+	return r.c.Peek(ID)
+}
+
+// IsCached see `Cache.IsCached`.
+func (r ReadOnlyCache[K, T]) IsCached(ID K) bool {
+	return r.c.IsCached(ID)
+}
+
+// Len see `Cache.Len`.
+func (r ReadOnlyCache[K, T]) Len() int {
+	return r.c.Len()
+}
+
+// All see `Cache.All`.
+func (r ReadOnlyCache[K, T]) All() iter.Seq2[K, *T] {
+	return r.c.All()
+}
+
+// KeysSorted see `Cache.KeysSorted`.
+func (r ReadOnlyCache[K, T]) KeysSorted(less func(a, b K) bool) []K {
+	return r.c.KeysSorted(less)
+}
+
+// Stats see `Cache.Stats`.
+func (r ReadOnlyCache[K, T]) Stats() Stats {
+	return r.c.Stats()
+}
+
+// Name see `Cache.Name`.
+func (r ReadOnlyCache[K, T]) Name() string {
+	return r.c.Name()
+}
+
+// AutomaticReloadMode see `Cache.AutomaticReloadMode`.
+func (r ReadOnlyCache[K, T]) AutomaticReloadMode() AutomaticReload {
+	return r.c.AutomaticReloadMode()
+}
+
+// Health see `Cache.Health`.
+func (r ReadOnlyCache[K, T]) Health() HealthStatus {
+	return r.c.Health()
+}
+
+// Timeouts see `Cache.Timeouts`.
+func (r ReadOnlyCache[K, T]) Timeouts() Timeouts {
+	return r.c.Timeouts()
+}
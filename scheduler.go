@@ -0,0 +1,290 @@
+package lazy
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// schedulerClockPoll is maximal wait of scheduler between checks of other than
+// real clock, which can be moved forward at any time (e.g. by tests)
+const schedulerClockPoll = 10 * time.Millisecond
+
+// scheduleKind distinguishes items of the combined schedule. Expirations precede
+// reloads scheduled for the same instant, so expired entry is never reloaded.
+type scheduleKind uint8
+
+const (
+	scheduleExpire scheduleKind = iota
+	scheduleReload
+)
+
+type scheduleKey[K comparable] struct {
+	ID   K
+	kind scheduleKind
+}
+
+type scheduleItem[K comparable] struct {
+	key      scheduleKey[K]
+	deadline time.Time
+	index    int // index in the queue
+}
+
+// scheduleQueue is priority queue of scheduled items ordered by their deadlines
+type scheduleQueue[K comparable] []*scheduleItem[K]
+
+func (q scheduleQueue[K]) Len() int { return len(q) }
+
+func (q scheduleQueue[K]) Less(i, j int) bool {
+	if q[i].deadline.Equal(q[j].deadline) {
+		return q[i].key.kind < q[j].key.kind
+	}
+
+	return q[i].deadline.Before(q[j].deadline)
+}
+
+func (q scheduleQueue[K]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue[K]) Push(x any) {
+	item := x.(*scheduleItem[K])
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *scheduleQueue[K]) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return item
+}
+
+// scheduler replaces TTL and reload watchers by one goroutine handling both
+// expirations and reloads of entries from combined priority queue (see
+// `Params.SingleScheduler`). Reloads are run by a worker goroutine which exists
+// only while there are reloads to be done, so slow loads do not delay expirations.
+// Deadlines follow clock of the cache.
+type scheduler[K comparable] struct {
+	mu     sync.Mutex
+	queue  scheduleQueue[K]
+	items  map[scheduleKey[K]]*scheduleItem[K]
+	counts [2]int        // number of scheduled items by kind
+	wake   chan struct{} // signals change of the earliest deadline
+	now    func() time.Time
+	poll   time.Duration // maximal wait between checks of the clock (0 means no limit)
+
+	expire      func(ID K)
+	reload      func(IDs []K)
+	batchWindow time.Duration // reloads are collected within the window when > 0
+	reloads     []K           // IDs waiting for reload
+	reloading   bool          // true while reload worker runs
+}
+
+func newScheduler[K comparable](now func() time.Time) *scheduler[K] {
+	return &scheduler[K]{
+		items: make(map[scheduleKey[K]]*scheduleItem[K]),
+		wake:  make(chan struct{}, 1),
+		now:   now,
+	}
+}
+
+// push schedules the item after ttl (rescheduled when it is already scheduled)
+func (s *scheduler[K]) push(key scheduleKey[K], ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := s.now().Add(ttl)
+
+	item, exists := s.items[key]
+	if exists {
+		item.deadline = deadline
+		heap.Fix(&s.queue, item.index)
+	} else {
+		item = &scheduleItem[K]{key: key, deadline: deadline}
+		heap.Push(&s.queue, item)
+		s.items[key] = item
+		s.counts[key.kind]++
+	}
+
+	if item.index == 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *scheduler[K]) drop(key scheduleKey[K]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.items[key]
+	if !exists {
+		return
+	}
+
+	heap.Remove(&s.queue, item.index)
+	delete(s.items, key)
+	s.counts[key.kind]--
+}
+
+func (s *scheduler[K]) len(kind scheduleKind) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counts[kind]
+}
+
+// pop removes items whose deadline passed and returns them (ordered) together
+// with time until the next deadline (negative when there is none)
+func (s *scheduler[K]) pop(now time.Time) (keys []scheduleKey[K], next time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) > 0 && !s.queue[0].deadline.After(now) {
+		item := heap.Pop(&s.queue).(*scheduleItem[K])
+		delete(s.items, item.key)
+		s.counts[item.key.kind]--
+		keys = append(keys, item.key)
+	}
+
+	if len(s.queue) == 0 {
+		return keys, -1
+	}
+
+	return keys, s.queue[0].deadline.Sub(now)
+}
+
+// run handles scheduled items until context is done
+func (s *scheduler[K]) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		keys, next := s.pop(s.now())
+		for _, key := range keys {
+			if key.kind == scheduleExpire {
+				s.expire(key.ID)
+			} else {
+				s.queueReload(ctx, key.ID)
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if s.poll > 0 && (next < 0 || next > s.poll) {
+			next = s.poll
+		}
+		// without scheduled items the loop waits only for wake up
+		var timerC <-chan time.Time
+		if next >= 0 {
+			timer.Reset(next)
+			timerC = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timerC:
+		}
+	}
+}
+
+// queueReload adds ID to reloads and starts reload worker when it does not run
+func (s *scheduler[K]) queueReload(ctx context.Context, ID K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reloads = append(s.reloads, ID)
+	if !s.reloading {
+		s.reloading = true
+		go s.runReloads(ctx)
+	}
+}
+
+// runReloads reloads queued entries (one by one or in batches) until there is
+// nothing to reload
+func (s *scheduler[K]) runReloads(ctx context.Context) {
+	for {
+		if s.batchWindow > 0 {
+			timer := time.NewTimer(s.batchWindow)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+
+		s.mu.Lock()
+		if len(s.reloads) == 0 || ctx.Err() != nil {
+			s.reloads = nil
+			s.reloading = false
+			s.mu.Unlock()
+			return
+		}
+
+		IDs := s.reloads
+		if s.batchWindow > 0 {
+			s.reloads = nil
+		} else {
+			IDs = IDs[:1:1]
+			s.reloads = s.reloads[1:]
+		}
+		s.mu.Unlock()
+
+		s.reload(IDs)
+	}
+}
+
+// scheduledWatcher is view of the scheduler for one kind of items, so it can be
+// used in place of TTL or reload watcher
+type scheduledWatcher[K comparable] struct {
+	s    *scheduler[K]
+	kind scheduleKind
+}
+
+func (w scheduledWatcher[K]) Push(ID K, ttl time.Duration) {
+	w.s.push(scheduleKey[K]{ID: ID, kind: w.kind}, ttl)
+}
+
+func (w scheduledWatcher[K]) Drop(ID K) {
+	w.s.drop(scheduleKey[K]{ID: ID, kind: w.kind})
+}
+
+func (w scheduledWatcher[K]) Len() int {
+	return w.s.len(w.kind)
+}
+
+// startScheduler handles expirations and automatic reloads of entries scheduled
+// by single scheduler
+func (c *Cache[K, T]) startScheduler(s *scheduler[K]) {
+	s.expire = c.expireEntry
+
+	switch {
+	case c.automaticReloadType == AutomaticReloadDisabled:
+		s.reload = func([]K) {}
+	case c.loadMultipleFunc != nil && c.reloadBatchWindow > 0:
+		s.batchWindow = c.reloadBatchWindow
+		s.reload = c.automaticReloadBatch
+	default:
+		s.reload = func(IDs []K) {
+			for _, ID := range IDs {
+				c.automaticReload(ID)
+			}
+		}
+	}
+
+	s.run(c.ctx)
+}
@@ -0,0 +1,138 @@
+package lazy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SnapshotOptions configures `Snapshot` and `Restore`.
+type SnapshotOptions[K comparable, T any] struct {
+	// Codec serializes keys and values (JSONCodec is used when not set).
+	Codec Codec[K, T]
+	// IncludeNegative includes not found entries in the snapshot (only their keys
+	// and remaining TTL), so known missing entries are not loaded again after
+	// restore.
+	IncludeNegative bool
+}
+
+func (opts SnapshotOptions[K, T]) codec() Codec[K, T] {
+	if opts.Codec == nil {
+		return JSONCodec[K, T]{}
+	}
+
+	return opts.Codec
+}
+
+// snapshotEntry is one line of the snapshot
+type snapshotEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// Negative marks not found entry
+	Negative bool `json:"negative,omitempty"`
+	// TTL is remaining TTL of not found entry in milliseconds
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// Snapshot writes cached entries to w (as JSON lines), so they can be restored
+// by `Restore` e.g. after restart. Entries which are being loaded and entries
+// with transient errors are skipped.
+func (c *Cache[K, T]) Snapshot(w io.Writer, opts SnapshotOptions[K, T]) error {
+	type cachedValue struct {
+		ID        K
+		value     *T
+		expiresAt int64
+	}
+
+	c.mu.RLock()
+	values := make([]cachedValue, 0, len(c.data))
+	for ID, entry := range c.data {
+		value := entry.value.Load()
+		// not loaded yet (or failed)
+		if value == nil && (!opts.IncludeNegative || entry.lastLoaded.Load() == 0) {
+			continue
+		}
+
+		values = append(values, cachedValue{ID: ID, value: value, expiresAt: entry.expiresAt.Load()})
+	}
+	c.mu.RUnlock()
+
+	codec := opts.codec()
+	encoder := json.NewEncoder(w)
+	nowMillis := c.nowMillis()
+
+	for _, cachedValue := range values {
+		var err error
+		line := snapshotEntry{}
+
+		line.Key, err = codec.EncodeKey(cachedValue.ID)
+		if err != nil {
+			return fmt.Errorf("encoding key %v: %w", cachedValue.ID, err)
+		}
+
+		if cachedValue.value == nil {
+			line.Negative = true
+			line.TTL = cachedValue.expiresAt - nowMillis
+			// already expired
+			if line.TTL <= 0 {
+				continue
+			}
+		} else {
+			line.Value, err = codec.EncodeValue(c.decompress(cachedValue.value))
+			if err != nil {
+				return fmt.Errorf("encoding value of %v: %w", cachedValue.ID, err)
+			}
+		}
+
+		err = encoder.Encode(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore reads entries written by `Snapshot` from r and stores them into cache
+// (cached entries with the same IDs are replaced). Values are cached as freshly
+// loaded, not found entries are cached for their remaining TTL (at most
+// `NotFoundTTL`). Not found entries are skipped unless `IncludeNegative` is set.
+func (c *Cache[K, T]) Restore(r io.Reader, opts SnapshotOptions[K, T]) error {
+	codec := opts.codec()
+	decoder := json.NewDecoder(r)
+
+	for {
+		line := snapshotEntry{}
+		err := decoder.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if line.Negative && !opts.IncludeNegative {
+			continue
+		}
+
+		loadedEntry := LoadedEntry[K, T]{}
+		loadedEntry.ID, err = codec.DecodeKey(line.Key)
+		if err != nil {
+			return fmt.Errorf("decoding key: %w", err)
+		}
+
+		if line.Negative {
+			loadedEntry.Err = ErrNotFound
+			c.addLoadedEntry(loadedEntry, c.nowMillis(), time.Duration(line.TTL)*time.Millisecond, false)
+			continue
+		}
+
+		loadedEntry.Value, err = codec.DecodeValue(line.Value)
+		if err != nil {
+			return fmt.Errorf("decoding value of %v: %w", loadedEntry.ID, err)
+		}
+		c.addLoadedEntry(loadedEntry, c.nowMillis(), 0, false)
+	}
+}
@@ -0,0 +1,267 @@
+package lazy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains cache statistics collected since the cache was created.
+// Unlike metrics, statistics are collected even when no metrics registry is set.
+type Stats struct {
+	// Reads is number of reads (`Get` and its variants, `TryGet`, `GetStale`).
+	Reads uint64
+	// Hits is number of reads by `Get` (and its variants) served from cache and
+	// Misses number of the other ones (which loaded the entry or returned nil
+	// without loading it, e.g. for invalid keys).
+	Hits   uint64
+	Misses uint64
+	// LazyLoads is number of loads triggered by reads (including loads by
+	// `LoadBatchFunc`, counted per entry) and AutomaticLoads number of
+	// automatic reloads. ErrorLoads is number of loads which failed (except errors
+	// classified as not found, see `Params.ClassifyError`).
+	LazyLoads      uint64
+	AutomaticLoads uint64
+	ErrorLoads     uint64
+	// DedupedLoads is number of reads of expired or not yet loaded entries
+	// which did not trigger a load, because other routine loaded the entry
+	// during waiting for the entry lock.
+	DedupedLoads uint64
+	// RejectedInsertions is number of reads of not cached entries which were not
+	// loaded, because memory size exceeded hard memory ceiling.
+	RejectedInsertions uint64
+	// CircuitOpenSkips is number of loads which were not performed, because
+	// circuit breaker was open.
+	CircuitOpenSkips uint64
+	// CircuitSkippedReloads is number of automatic reloads which were postponed,
+	// because circuit breaker was open.
+	CircuitSkippedReloads uint64
+	// Preloads is number of entries received from `PreloadChan`.
+	Preloads uint64
+	// Evictions is number of entries evicted because of `MaxEntries` limit.
+	Evictions uint64
+	// CompactedEntries is number of not found entries removed by negative compaction.
+	CompactedEntries uint64
+	// DroppedEvents is number of lifecycle events dropped, because events channel
+	// was full.
+	DroppedEvents uint64
+	// ChangedReloads is number of reloads which changed the value of the entry
+	// (counted only when `Params.Equal` is set).
+	ChangedReloads uint64
+	// UnchangedReloads is number of reloads which loaded the same value as was
+	// cached (counted only when `Params.Equal` is set). High ratio of unchanged
+	// reloads suggests that `ReloadInterval` is too short.
+	UnchangedReloads uint64
+	// WouldEvictions is number of entries which would be evicted, if
+	// `CapacityDryRun` was not set.
+	WouldEvictions uint64
+	// AccessedEntries is number of entries accessed since their last load and
+	// UnaccessedEntries number of the other ones (which are not reloaded by
+	// `AutomaticReloadAccessedEntries` and expire unless they are accessed). Both
+	// are measured together with memory size (see `Timeouts.MemsizeUpdate` and
+	// `Cache.RefreshMemSize`), so they are 0 until the first measurement.
+	AccessedEntries   uint64
+	UnaccessedEntries uint64
+	// RunningBackgroundLoads is current number of routines running background
+	// (re)loads and QueuedBackgroundLoads number of loads waiting for them (see
+	// `Params.MaxBackgroundLoads`).
+	RunningBackgroundLoads uint64
+	QueuedBackgroundLoads  uint64
+	// GraceHits is number of reads served by values of expired entries kept in
+	// grace ring (see `Params.GraceEntries`).
+	GraceHits uint64
+	// AcquiredLoadSlots is current number of loader calls of the cache holding a
+	// slot of load limiter and LoadSlotsWait total time loader calls waited for
+	// the slots (see `Params.MaxConcurrentLoads` and `Params.LoadLimiter`).
+	AcquiredLoadSlots uint64
+	LoadSlotsWait     time.Duration
+	// MapCompactions is number of rebuilds of the map of cached entries (see
+	// `Cache.Compact`).
+	MapCompactions uint64
+}
+
+type cacheStats struct {
+	reads                 atomic.Uint64
+	hits                  atomic.Uint64
+	misses                atomic.Uint64
+	lazyLoads             atomic.Uint64
+	automaticLoads        atomic.Uint64
+	errorLoads            atomic.Uint64
+	dedupedLoads          atomic.Uint64
+	rejectedInsertions    atomic.Uint64
+	circuitOpenSkips      atomic.Uint64
+	circuitSkippedReloads atomic.Uint64
+	preloads              atomic.Uint64
+	evictions             atomic.Uint64
+	compactedEntries      atomic.Uint64
+	droppedEvents         atomic.Uint64
+	changedReloads        atomic.Uint64
+	unchangedReloads      atomic.Uint64
+	wouldEvictions        atomic.Uint64
+	accessedEntries       atomic.Uint64
+	unaccessedEntries     atomic.Uint64
+	graceHits             atomic.Uint64
+	acquiredLoadSlots     atomic.Int64
+	loadSlotsWait         atomic.Uint64 // in nanoseconds
+	mapCompactions        atomic.Uint64
+}
+
+// Stats returns current cache statistics.
+func (c *Cache[K, T]) Stats() Stats {
+	c.background.mu.Lock()
+	running, queued := c.background.running, len(c.background.queue)
+	c.background.mu.Unlock()
+
+	return Stats{
+		Reads:                  c.stats.reads.Load(),
+		Hits:                   c.stats.hits.Load(),
+		Misses:                 c.stats.misses.Load(),
+		LazyLoads:              c.stats.lazyLoads.Load(),
+		AutomaticLoads:         c.stats.automaticLoads.Load(),
+		ErrorLoads:             c.stats.errorLoads.Load(),
+		DedupedLoads:           c.stats.dedupedLoads.Load(),
+		RejectedInsertions:     c.stats.rejectedInsertions.Load(),
+		CircuitOpenSkips:       c.stats.circuitOpenSkips.Load(),
+		CircuitSkippedReloads:  c.stats.circuitSkippedReloads.Load(),
+		Preloads:               c.stats.preloads.Load(),
+		Evictions:              c.stats.evictions.Load(),
+		CompactedEntries:       c.stats.compactedEntries.Load(),
+		DroppedEvents:          c.stats.droppedEvents.Load(),
+		ChangedReloads:         c.stats.changedReloads.Load(),
+		UnchangedReloads:       c.stats.unchangedReloads.Load(),
+		WouldEvictions:         c.stats.wouldEvictions.Load(),
+		AccessedEntries:        c.stats.accessedEntries.Load(),
+		UnaccessedEntries:      c.stats.unaccessedEntries.Load(),
+		RunningBackgroundLoads: uint64(running),
+		QueuedBackgroundLoads:  uint64(queued),
+		GraceHits:              c.stats.graceHits.Load(),
+		AcquiredLoadSlots:      uint64(c.stats.acquiredLoadSlots.Load()),
+		LoadSlotsWait:          time.Duration(c.stats.loadSlotsWait.Load()),
+		MapCompactions:         c.stats.mapCompactions.Load(),
+	}
+}
+
+// MetricsSnapshot returns current values of cache statistics (see `Stats`), number
+// of cached entries and their memory size as a map (e.g. for periodic logging
+// where metrics are not scraped). Names are the same as names of Prometheus
+// metrics (without prefixes), values are available even when no metrics registry
+// is set.
+func (c *Cache[K, T]) MetricsSnapshot() map[string]float64 {
+	stats := c.Stats()
+	loadsDisabled := 0.0
+	if c.fatalErr.Load() != nil {
+		loadsDisabled = 1
+	}
+
+	return map[string]float64{
+		"items_count":              float64(c.Len()),
+		"memory_usage":             float64(c.memSizeValue.Load()),
+		"reads_count":              float64(stats.Reads),
+		"hits":                     float64(stats.Hits),
+		"misses":                   float64(stats.Misses),
+		"lazy_loads":               float64(stats.LazyLoads),
+		"automatic_loads":          float64(stats.AutomaticLoads),
+		"error_loads":              float64(stats.ErrorLoads),
+		"deduped_loads":            float64(stats.DedupedLoads),
+		"rejected_insertions":      float64(stats.RejectedInsertions),
+		"circuit_open_skips":       float64(stats.CircuitOpenSkips),
+		"circuit_skipped_reloads":  float64(stats.CircuitSkippedReloads),
+		"preloads":                 float64(stats.Preloads),
+		"evictions":                float64(stats.Evictions),
+		"compacted_entries":        float64(stats.CompactedEntries),
+		"dropped_events":           float64(stats.DroppedEvents),
+		"changed_reloads":          float64(stats.ChangedReloads),
+		"unchanged_reloads":        float64(stats.UnchangedReloads),
+		"would_evictions":          float64(stats.WouldEvictions),
+		"accessed_items_count":     float64(stats.AccessedEntries),
+		"unaccessed_items_count":   float64(stats.UnaccessedEntries),
+		"background_loads_running": float64(stats.RunningBackgroundLoads),
+		"background_loads_queued":  float64(stats.QueuedBackgroundLoads),
+		"grace_hits":               float64(stats.GraceHits),
+		"loads_disabled":           loadsDisabled,
+		"load_slots_acquired":      float64(stats.AcquiredLoadSlots),
+		"load_slots_wait_seconds":  stats.LoadSlotsWait.Seconds(),
+		"map_compactions":          float64(stats.MapCompactions),
+	}
+}
+
+// countRead counts read in statistics and metrics
+func (c *Cache[K, T]) countRead() {
+	c.stats.reads.Add(1)
+	if c.metrics != nil {
+		c.metrics.ReadsCount.Inc()
+	}
+}
+
+// countLoad counts finished load (automatic reload or load triggered by read) in
+// statistics and metrics
+func (c *Cache[K, T]) countLoad(automatic bool, err error) {
+	failed := err != nil && c.errorClass(err) != ErrorClassNotFound
+
+	if automatic {
+		c.stats.automaticLoads.Add(1)
+	} else {
+		c.stats.lazyLoads.Add(1)
+	}
+	if failed {
+		c.stats.errorLoads.Add(1)
+	}
+
+	if c.metrics == nil {
+		return
+	}
+
+	if automatic {
+		c.metrics.AutomaticLoadCount.Inc()
+	} else {
+		c.metrics.LazyLoadCount.Inc()
+	}
+	if failed {
+		c.metrics.ErrorLoadCount.Inc()
+	}
+}
+
+// FreshnessStats describes ages of cached entries (time since their last
+// successful or not found load). Entries which were never loaded successfully
+// are not included.
+type FreshnessStats struct {
+	Entries int
+	Oldest  time.Duration
+	Newest  time.Duration
+	Average time.Duration
+}
+
+// FreshnessStats returns ages of cached entries.
+func (c *Cache[K, T]) FreshnessStats() (stats FreshnessStats) {
+	nowMillis := c.nowMillis()
+
+	var oldest, newest, total int64
+
+	c.mu.RLock()
+	for _, entry := range c.data {
+		lastLoaded := entry.lastLoaded.Load()
+		if lastLoaded == 0 {
+			continue
+		}
+
+		age := nowMillis - lastLoaded
+		if stats.Entries == 0 || age > oldest {
+			oldest = age
+		}
+		if stats.Entries == 0 || age < newest {
+			newest = age
+		}
+		total += age
+		stats.Entries++
+	}
+	c.mu.RUnlock()
+
+	if stats.Entries == 0 {
+		return
+	}
+
+	stats.Oldest = time.Duration(oldest) * time.Millisecond
+	stats.Newest = time.Duration(newest) * time.Millisecond
+	stats.Average = time.Duration(total/int64(stats.Entries)) * time.Millisecond
+
+	return
+}
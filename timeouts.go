@@ -1,7 +1,7 @@
 package lazy
 
 import (
-	"errors"
+	"fmt"
 	"time"
 )
 
@@ -24,12 +24,27 @@ type Timeouts struct {
 	// If set to 0, not-found entries are not stored in cache.
 	NotFoundTTL time.Duration
 
+	// NotFoundGrace specifies how long the entry keeps serving its last value when
+	// reload of previously found entry returns not found. Until the grace period
+	// passes, the entry behaves like the reload failed (value and TTL stay the same)
+	// and it is reloaded again at latest when the grace period ends. Not found
+	// returned after the grace period clears the value and `NotFoundTTL` applies.
+	// Does not apply for first load (there is no value to be kept).
+	// If set to 0, value is cleared immediately.
+	NotFoundGrace time.Duration
+
 	// TTL for entry which first time load failed with an error (except NotFound).
 	// Does not apply for reloads.
 	// The duration is being randomized by `Randomizer`.
 	// If set to 0, these entries are not stored in cache.
 	ErrorTTL time.Duration
 
+	// TTL for entry which load failed with a permanent error (see `ClassifyError`
+	// in `Params`). Such entries are cached like not found entries.
+	// The duration is being randomized by `Randomizer`.
+	// If set to 0, these entries are not stored in cache.
+	PermanentErrorTTL time.Duration
+
 	// ReloadInterval specifies how often the entry should be reloaded or how long its data
 	// are valid in cache.
 	// The duration is being randomized by `Randomizer`.
@@ -37,8 +52,25 @@ type Timeouts struct {
 	// is triggered only when entry was accessed (via `Get` function) since last reload. If
 	// entry was not accessed, the reload is postponed until `ReloadInterval` duration passes
 	// (if `AutomaticReload` is enabled) or until `Get` function is called on the entry.
+	// It has to be positive (with 0 every read would reload the entry, which would
+	// disable caching).
 	ReloadInterval time.Duration
 
+	// ErrorRetryInterval specifies how often reads retry load of entry in error state
+	// (entry without value whose last load failed with an error other than not
+	// found), so the error is not served as cached nil for whole `ReloadInterval`.
+	// Reads within the interval after failed load return nil without loading.
+	// The state is resolved by successful or not found load.
+	// If set to 0, entries in error state are reloaded every `ReloadInterval`.
+	ErrorRetryInterval time.Duration
+
+	// MaxErrorBackoff enables exponential backoff of reloads of failing entries.
+	// Each consecutive failed load (error other than not found or permanent error)
+	// doubles the reload interval of the entry up to this value. Successful (or not
+	// found) load resets the interval back to `ReloadInterval`.
+	// If set to 0, failing entries are reloaded every `ReloadInterval`.
+	MaxErrorBackoff time.Duration
+
 	// Randomizer specifies how much the timeouts/durations should be randomized.
 	// value 0 means no randomization, 0.1 means 10% randomization, etc. Any value above 1
 	// is treated as 1.
@@ -51,22 +83,48 @@ type Timeouts struct {
 	// the cache memory size is recalculated in specified intervals.
 	// If set to 0, memory size is not updated.
 	MemsizeUpdate time.Duration
+
+	// NegativeCompaction specifies how often not found entries which were not
+	// accessed since their last load are removed from cache (before their
+	// `NotFoundTTL` passes) to reclaim memory.
+	// If set to 0, not found entries are removed only when their TTL passes.
+	NegativeCompaction time.Duration
 }
 
 func (t *Timeouts) check() error {
 	if t.TTL == 0 {
-		return errors.New("TTL cannot be 0")
+		return fmt.Errorf("%w: TTL cannot be 0", ErrInvalidTimeouts)
+	}
+
+	if t.NotFoundGrace < 0 {
+		return fmt.Errorf("%w: NotFoundGrace cannot be negative", ErrInvalidTimeouts)
+	}
+
+	if t.NegativeCompaction < 0 {
+		return fmt.Errorf("%w: NegativeCompaction cannot be negative", ErrInvalidTimeouts)
+	}
+
+	if t.ErrorRetryInterval < 0 {
+		return fmt.Errorf("%w: ErrorRetryInterval cannot be negative", ErrInvalidTimeouts)
+	}
+
+	if t.MaxErrorBackoff < 0 {
+		return fmt.Errorf("%w: MaxErrorBackoff cannot be negative", ErrInvalidTimeouts)
+	}
+
+	if t.ReloadInterval <= 0 {
+		return fmt.Errorf("%w: ReloadInterval must be positive", ErrInvalidTimeouts)
 	}
 
 	if t.ReloadInterval > t.TTL {
-		return errors.New("ReloadInterval must be less than or equal to TTL")
+		return fmt.Errorf("%w: ReloadInterval must be less than or equal to TTL", ErrInvalidTimeouts)
 	}
 
 	if t.Randomizer < 0 {
-		return errors.New("Randomizer cannot be negative")
+		return fmt.Errorf("%w: Randomizer cannot be negative", ErrInvalidTimeouts)
 	}
 	if t.Randomizer > 1 {
-		return errors.New("Randomizer cannot be greater than 1")
+		return fmt.Errorf("%w: Randomizer cannot be greater than 1", ErrInvalidTimeouts)
 	}
 
 	return nil
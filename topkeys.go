@@ -0,0 +1,138 @@
+package lazy
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+	"sync"
+)
+
+// KeyStat describes reads of a key tracked by `TopKeys`.
+type KeyStat[K comparable] struct {
+	Key K
+	// Count is estimated number of reads of the key. It is overestimated by at
+	// most `Error` (reads of keys previously tracked in its place).
+	Count uint64
+	Error uint64
+	// Hits, Misses (reads which loaded the entry) and Errors (failed loads) are
+	// counted since the key is tracked.
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+}
+
+type keyCounter[K comparable] struct {
+	KeyStat[K]
+	heapIndex int
+}
+
+// keyCounters is min-heap of counters by count
+type keyCounters[K comparable] []*keyCounter[K]
+
+func (h keyCounters[K]) Len() int           { return len(h) }
+func (h keyCounters[K]) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h keyCounters[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *keyCounters[K]) Push(x any) {
+	counter := x.(*keyCounter[K])
+	counter.heapIndex = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *keyCounters[K]) Pop() any {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return counter
+}
+
+// topKeys tracks most frequently read keys by space-saving algorithm: fixed number
+// of counters is kept and a new key takes over counter with the lowest count.
+type topKeys[K comparable] struct {
+	mu       sync.Mutex
+	capacity int
+	counters keyCounters[K]
+	keys     map[K]*keyCounter[K]
+}
+
+func newTopKeys[K comparable](capacity int) *topKeys[K] {
+	return &topKeys[K]{
+		capacity: capacity,
+		counters: make(keyCounters[K], 0, capacity),
+		keys:     make(map[K]*keyCounter[K], capacity),
+	}
+}
+
+func (tk *topKeys[K]) read(ID K, hit bool) {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+
+	counter, tracked := tk.keys[ID]
+	switch {
+	case tracked:
+		counter.Count++
+	case len(tk.counters) < tk.capacity:
+		counter = &keyCounter[K]{KeyStat: KeyStat[K]{Key: ID, Count: 1}}
+		heap.Push(&tk.counters, counter)
+		tk.keys[ID] = counter
+	default:
+		// replace key with the lowest count
+		counter = tk.counters[0]
+		delete(tk.keys, counter.Key)
+		counter.KeyStat = KeyStat[K]{Key: ID, Count: counter.Count + 1, Error: counter.Count}
+		tk.keys[ID] = counter
+	}
+
+	if hit {
+		counter.Hits++
+	} else {
+		counter.Misses++
+	}
+	heap.Fix(&tk.counters, counter.heapIndex)
+}
+
+func (tk *topKeys[K]) loadFailed(ID K) {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+
+	if counter, tracked := tk.keys[ID]; tracked {
+		counter.Errors++
+	}
+}
+
+// TopKeys returns statistics of at most n most frequently read keys (sorted by
+// count descending). Returns nil when tracking is disabled (see `Params.TopKeys`)
+// or n is not positive.
+func (c *Cache[K, T]) TopKeys(n int) []KeyStat[K] {
+	if c.topKeys == nil || n <= 0 {
+		return nil
+	}
+
+	c.topKeys.mu.Lock()
+	stats := make([]KeyStat[K], 0, len(c.topKeys.counters))
+	for _, counter := range c.topKeys.counters {
+		stats = append(stats, counter.KeyStat)
+	}
+	c.topKeys.mu.Unlock()
+
+	slices.SortFunc(stats, func(a, b KeyStat[K]) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+
+	return stats[:min(n, len(stats))]
+}
+
+// trackLoad counts failed load of the key (when keys are tracked). Errors
+// classified as not found (see `ClassifyError`) are not failures.
+func (c *Cache[K, T]) trackLoad(ID K, err error) {
+	if c.topKeys == nil || err == nil || c.errorClass(err) == ErrorClassNotFound {
+		return
+	}
+
+	c.topKeys.loadFailed(ID)
+}
@@ -0,0 +1,125 @@
+package lazy
+
+// Status is state of an entry returned by `TryGet`.
+type Status int
+
+const (
+	// StatusReady means the value is loaded and valid.
+	StatusReady Status = iota
+	// StatusLoading means the entry is being loaded (not cached or expired entry).
+	StatusLoading
+	// StatusAbsent means the entry has no value (it was not found or it cannot be
+	// loaded, e.g. the key is not valid).
+	StatusAbsent
+)
+
+// TryGet returns value of the entry without ever blocking on its load. Valid
+// entries are returned as `StatusReady` (or `StatusAbsent` when not found). When
+// the entry is not cached or it is expired, its load is started in the background
+// (unless it is already in progress) and `StatusLoading` is returned together
+// with the expired value (nil for not cached entry).
+func (c *Cache[K, T]) TryGet(ID K) (*T, Status) {
+	ID = c.normalizeKey(ID)
+
+	c.countRead()
+
+	if !c.isValidKey(ID) {
+		return nil, StatusAbsent
+	}
+
+	nowMillis := c.nowMillis()
+
+	entry, exists := c.acquireEntry(ID)
+	if !exists {
+		if c.overMemoryCeiling() || c.paused.Load() || c.fatalErr.Load() != nil {
+			return nil, StatusAbsent
+		}
+
+		if c.insertAfterLoad {
+			// only one background load of the key is started by a burst of reads
+			if _, loading := c.tryGetLoads.LoadOrStore(ID, struct{}{}); loading {
+				return nil, StatusLoading
+			}
+
+			c.runInBackground(func() {
+				defer c.tryGetLoads.Delete(ID)
+				c.loadDetachedEntry(c.ctx, ID, nil, nowMillis)
+			})
+			return nil, StatusLoading
+		}
+
+		c.mu.Lock()
+
+		// check if entry was not created by other routine during waiting for lock
+		entry, exists = c.data[ID]
+		if exists {
+			entry.refs.Add(1)
+		} else {
+			entry = c.newEntry()
+			entry.mu.Lock()
+			c.markAccess(entry, nowMillis)
+			c.storeEntry(ID, entry)
+		}
+
+		c.mu.Unlock()
+
+		if !exists {
+			c.enforceCapacity()
+
+			// reference of the entry is released after the load
+			c.runInBackground(func() {
+				defer c.releaseEntry(entry)
+				c.loadNewEntry(c.ctx, ID, entry, nowMillis)
+			})
+
+			return nil, StatusLoading
+		}
+	}
+
+	c.markAccess(entry, nowMillis)
+
+	// valid value
+	if nowMillis < entry.nextReload.Load() {
+		defer c.releaseEntry(entry)
+		return c.readyValue(entry)
+	}
+
+	// expired value is served when loading is paused (or disabled)
+	if c.paused.Load() || c.fatalErr.Load() != nil {
+		defer c.releaseEntry(entry)
+		return c.readyValue(entry)
+	}
+
+	// entry is being loaded by other routine
+	if !entry.mu.TryLock() {
+		defer c.releaseEntry(entry)
+		return c.output(entry.value.Load()), StatusLoading
+	}
+
+	// entry was loaded by other routine meanwhile
+	if nowMillis < entry.nextReload.Load() {
+		entry.mu.Unlock()
+		defer c.releaseEntry(entry)
+		return c.readyValue(entry)
+	}
+
+	// value is read before the reload starts (reference of the entry is released
+	// after the reload)
+	value := c.output(entry.value.Load())
+	c.runInBackground(func() {
+		defer c.releaseEntry(entry)
+		_, _ = c.reloadEntry(c.ctx, ID, entry, nowMillis)
+	})
+
+	return value, StatusLoading
+}
+
+// readyValue returns value of valid entry and its status
+func (c *Cache[K, T]) readyValue(entry *cachedEntry[T]) (*T, Status) {
+	value := entry.get()
+	if value == nil {
+		return nil, StatusAbsent
+	}
+
+	return c.output(value), StatusReady
+}
@@ -0,0 +1,102 @@
+package lazy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/moderntv/deathrow"
+)
+
+// entryWatcher schedules entries (for expiration or automatic reload)
+type entryWatcher[K comparable] interface {
+	Push(ID K, ttl time.Duration)
+	Drop(ID K)
+	Len() int
+}
+
+// watchedItem is item of deathrow prison. Generation distinguishes item pushed
+// again from its previous generation, which could expire meanwhile.
+type watchedItem[K comparable] struct {
+	ID         K
+	generation uint64
+}
+
+// watcher wraps deathrow prison and counts its items, because the prison does
+// not expose its size (needed to detect leaking watched items)
+type watcher[K comparable] struct {
+	prison      *deathrow.Prison[watchedItem[K]]
+	generations sync.Map // generation of watched item by its ID
+	generation  atomic.Uint64
+	count       atomic.Int64
+}
+
+func newWatcher[K comparable]() *watcher[K] {
+	return &watcher[K]{
+		prison: deathrow.NewPrison[watchedItem[K]](),
+	}
+}
+
+func (w *watcher[K]) Push(ID K, ttl time.Duration) {
+	generation := w.generation.Add(1)
+
+	previous, watched := w.generations.Swap(ID, generation)
+	if watched {
+		w.prison.Drop(watchedItem[K]{ID: ID, generation: previous.(uint64)})
+	} else {
+		w.count.Add(1)
+	}
+	w.prison.Push(watchedItem[K]{ID: ID, generation: generation}, ttl)
+}
+
+func (w *watcher[K]) Drop(ID K) {
+	generation, watched := w.generations.LoadAndDelete(ID)
+	if !watched {
+		return
+	}
+
+	w.count.Add(-1)
+	w.prison.Drop(watchedItem[K]{ID: ID, generation: generation.(uint64)})
+}
+
+// Len returns number of watched items
+func (w *watcher[K]) Len() int {
+	return int(w.count.Load())
+}
+
+// popper returns channel of IDs of expired items. The channel is closed when
+// context is done.
+func (w *watcher[K]) popper(ctx context.Context, resolution time.Duration) <-chan K {
+	items := w.prison.PopperWithResolution(ctx, resolution)
+	ch := make(chan K)
+
+	go func() {
+		defer close(ch)
+
+		for item := range items {
+			expired := item.ID()
+			// item pushed again after its previous generation expired stays watched
+			if !w.generations.CompareAndDelete(expired.ID, expired.generation) {
+				continue
+			}
+			w.count.Add(-1)
+
+			ch <- expired.ID
+		}
+	}()
+
+	return ch
+}
+
+// TTLWatcherLen returns number of entries watched for TTL expiration. It should
+// not diverge from `Len` persistently (that would mean leaking watched entries).
+func (c *Cache[K, T]) TTLWatcherLen() int {
+	return c.ttlWatcher.Len()
+}
+
+// ReloadWatcherLen returns number of entries scheduled for automatic reload. It
+// should not exceed `Len` persistently (that would mean leaking watched entries).
+func (c *Cache[K, T]) ReloadWatcherLen() int {
+	return c.reloadWatcher.Len()
+}